@@ -0,0 +1,327 @@
+/*
+ Copyright 2016 Padduck, LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+ 	http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pufferpanel/pufferd/logging"
+	"github.com/pufferpanel/pufferd/utils"
+)
+
+const (
+	definitionEntry = "definition.json"
+	filesPrefix     = "files/"
+)
+
+//Decides which paths under a server root are backed up.
+type fileFilter struct {
+	include []string
+	exclude []string
+}
+
+//Reports whether a path relative to the root is backed up, and for directories whether
+//anything under it could be. A pattern matching a directory applies to everything in it.
+func (f *fileFilter) matches(relative string, isDir bool) (include bool, descend bool) {
+	if matchesAncestor(f.exclude, relative) {
+		return false, false
+	}
+	if len(f.include) == 0 || matchesAncestor(f.include, relative) {
+		return true, true
+	}
+	return false, isDir
+}
+
+func matchesAncestor(patterns []string, relative string) bool {
+	for current := relative; current != "." && current != "/" && current != ""; current = path.Dir(current) {
+		for _, pattern := range patterns {
+			if utils.MatchGlob(pattern, current) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//Calls fn for each directory, regular file and symlink under root the filter accepts, parents before children.
+//Link holds the target of symlinks.
+func walkFiles(root string, filter *fileFilter, fn func(file string, relative string, info os.FileInfo, link string) error) error {
+	return filepath.Walk(root, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			if file == root && os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if file == root {
+			return nil
+		}
+		relative, err := filepath.Rel(root, file)
+		if err != nil {
+			return err
+		}
+		relative = filepath.ToSlash(relative)
+
+		include, descend := filter.matches(relative, info.IsDir())
+		if !include {
+			if info.IsDir() && !descend {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		link := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			link, err = os.Readlink(file)
+			if err != nil {
+				return err
+			}
+		} else if !info.IsDir() && !info.Mode().IsRegular() {
+			return nil
+		}
+		return fn(file, relative, info, link)
+	})
+}
+
+//Writes a gzip compressed tar holding the server definition and the files under root the filter accepts.
+//Progress is given the number of file bytes archived so far.
+func writeArchive(writer io.Writer, definition []byte, root string, filter *fileFilter, progress utils.CopyProgress) (files int, err error) {
+	compressed := gzip.NewWriter(writer)
+	archive := tar.NewWriter(compressed)
+
+	err = archive.WriteHeader(&tar.Header{Name: definitionEntry, Mode: 0644, Size: int64(len(definition)), Typeflag: tar.TypeReg})
+	if err == nil {
+		_, err = archive.Write(definition)
+	}
+	if err != nil {
+		return
+	}
+
+	var done int64
+	err = walkFiles(root, filter, func(file string, relative string, info os.FileInfo, link string) error {
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		header.Name = filesPrefix + relative
+		if info.IsDir() {
+			header.Name += "/"
+		}
+		err = archive.WriteHeader(header)
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+
+		source, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer source.Close()
+		copied, err := io.CopyN(archive, source, header.Size)
+		if err == io.EOF {
+			//the server can shrink a file while it is archived, such as when rotating logs,
+			//and the entry has to be as long as its header says
+			logging.Warnf("File %s shrank while it was backed up, padding it to %d bytes", relative, header.Size)
+			_, err = io.CopyN(archive, zeros{}, header.Size-copied)
+		}
+		files++
+		done += header.Size
+		if progress != nil {
+			progress(done)
+		}
+		return err
+	})
+
+	if err == nil {
+		err = archive.Close()
+	}
+	if err == nil {
+		err = compressed.Close()
+	}
+	return
+}
+
+//Reads as an endless run of zero bytes.
+type zeros struct{}
+
+func (zeros) Read(data []byte) (int, error) {
+	for i := range data {
+		data[i] = 0
+	}
+	return len(data), nil
+}
+
+//Extracts an archive written by writeArchive, placing the files under root and returning the definition.
+//Entries which would end up outside of root, including through symlinks, are rejected.
+func extractArchive(reader io.Reader, root string) (definition []byte, files int, err error) {
+	compressed, err := gzip.NewReader(reader)
+	if err != nil {
+		return
+	}
+	defer compressed.Close()
+	archive := tar.NewReader(compressed)
+
+	for {
+		var header *tar.Header
+		header, err = archive.Next()
+		if err == io.EOF {
+			err = nil
+			return
+		}
+		if err != nil {
+			return
+		}
+
+		if header.Name == definitionEntry {
+			definition, err = ioutil.ReadAll(archive)
+			if err != nil {
+				return
+			}
+			continue
+		}
+
+		var relative string
+		relative, err = entryPath(header.Name)
+		if err != nil {
+			return
+		}
+		if relative == "" {
+			continue
+		}
+		err = extractEntry(archive, header, root, relative)
+		if err != nil {
+			return
+		}
+		if header.Typeflag == tar.TypeReg || header.Typeflag == tar.TypeRegA {
+			files++
+		}
+	}
+}
+
+//Converts an archive entry name to a clean path relative to the root, rejecting any which escape it.
+func entryPath(name string) (string, error) {
+	if !strings.HasPrefix(name, filesPrefix) {
+		return "", errors.New("Unexpected entry " + name + " in backup")
+	}
+	relative := path.Clean(strings.TrimPrefix(name, filesPrefix))
+	if relative == "." {
+		return "", nil
+	}
+	if path.IsAbs(relative) || relative == ".." || strings.HasPrefix(relative, "../") {
+		return "", errors.New("Entry " + name + " is outside of the server root")
+	}
+	return relative, nil
+}
+
+func extractEntry(archive *tar.Reader, header *tar.Header, root string, relative string) error {
+	switch header.Typeflag {
+	case tar.TypeLink:
+		source, err := entryPath(header.Linkname)
+		if err != nil || source == "" {
+			return errors.New("Hardlink " + relative + " points outside of the server root")
+		}
+		err = checkParents(root, source)
+		if err == nil {
+			err = checkParents(root, relative)
+		}
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(root, filepath.FromSlash(relative))
+		err = os.MkdirAll(filepath.Dir(target), 0755)
+		if err == nil {
+			err = os.Link(filepath.Join(root, filepath.FromSlash(source)), target)
+		}
+		return err
+	case tar.TypeRegA:
+		header.Typeflag = tar.TypeReg
+	}
+	return restoreEntry(root, relative, header.Typeflag, os.FileMode(header.Mode), header.Linkname, header.ModTime, archive)
+}
+
+//Creates a directory, regular file or symlink under root from a backup. Kind is a tar type flag.
+//Symlinks must stay inside the root, and nothing is written through a symlinked directory.
+func restoreEntry(root string, relative string, kind byte, mode os.FileMode, link string, modified time.Time, contents io.Reader) error {
+	target := filepath.Join(root, filepath.FromSlash(relative))
+	err := checkParents(root, relative)
+	if err != nil {
+		return err
+	}
+
+	switch kind {
+	case tar.TypeDir:
+		return os.MkdirAll(target, mode.Perm()|0700)
+	case tar.TypeSymlink:
+		resolved := path.Join(path.Dir(relative), link)
+		if path.IsAbs(link) || resolved == ".." || strings.HasPrefix(resolved, "../") {
+			return errors.New("Symlink " + relative + " points outside of the server root")
+		}
+		err = os.MkdirAll(filepath.Dir(target), 0755)
+		if err == nil {
+			err = os.Symlink(link, target)
+		}
+		return err
+	case tar.TypeReg:
+		err = os.MkdirAll(filepath.Dir(target), 0755)
+		if err != nil {
+			return err
+		}
+		file, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode.Perm())
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(file, contents)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil {
+			err = os.Chtimes(target, modified, modified)
+		}
+		return err
+	}
+	//devices, fifos and other special files are not restored
+	return nil
+}
+
+//Ensures none of the directories leading to a path are symlinks, so writing to it cannot leave the root.
+func checkParents(root string, relative string) error {
+	current := root
+	parts := strings.Split(relative, "/")
+	for _, part := range parts[:len(parts)-1] {
+		current = filepath.Join(current, part)
+		info, err := os.Lstat(current)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return errors.New("Entry " + relative + " is inside a symlinked directory")
+		}
+	}
+	return nil
+}
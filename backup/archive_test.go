@@ -0,0 +1,226 @@
+/*
+ Copyright 2016 Padduck, LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+ 	http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+
+package backup_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pufferpanel/pufferd/backup"
+	"github.com/pufferpanel/pufferd/jobs"
+	"github.com/pufferpanel/pufferd/programs"
+)
+
+//Creates a server with the given backup format, keeping its files and backups in a temporary folder.
+func setupServer(t *testing.T, format string) (dir string, program programs.Program) {
+	dir, err := ioutil.TempDir("", "pufferd-backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	programs.ServerFolder = filepath.Join(dir, "servers")
+	backup.Folder = filepath.Join(dir, "backups")
+	backup.Initialize()
+	os.MkdirAll(programs.ServerFolder, 0755)
+	os.MkdirAll(filepath.Join(dir, "root"), 0755)
+
+	definition := []byte(`{"pufferd":{"schemaVersion":2,"run":{"stop":"stop","program":"./game","arguments":[]},
+		"environment":{"type":"standard","root":"` + filepath.ToSlash(filepath.Join(dir, "root")) + `"},
+		"backup":{"format":"` + format + `"}}}`)
+	err = ioutil.WriteFile(filepath.Join(programs.ServerFolder, "backedup.json"), definition, 0644)
+	if err == nil {
+		program, err = programs.LoadFromData("backedup", definition)
+	}
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return
+}
+
+func writeFile(t *testing.T, file string, contents []byte, mode os.FileMode) {
+	err := os.MkdirAll(filepath.Dir(file), 0755)
+	if err == nil {
+		err = ioutil.WriteFile(file, contents, mode)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readFile(t *testing.T, file string) string {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func waitFor(t *testing.T, job *jobs.Job) jobs.Status {
+	for {
+		updated := job.Updated()
+		status := job.Status()
+		if status.Status != jobs.StatusRunning {
+			return status
+		}
+		select {
+		case <-updated:
+		case <-time.After(30 * time.Second):
+			t.Fatalf("Job %s did not finish", status.Type)
+		}
+	}
+}
+
+func createBackup(t *testing.T, program programs.Program) backup.Backup {
+	job, err := backup.Create(program)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := waitFor(t, job); status.Status != jobs.StatusCompleted {
+		t.Fatalf("Backup failed: %s", status.Error)
+	}
+	backups, err := backup.List(program.Id())
+	if err != nil || len(backups) == 0 {
+		t.Fatalf("Expected the backup to be listed but got %v, %v", backups, err)
+	}
+	return backups[0]
+}
+
+func restoreBackup(t *testing.T, program programs.Program, id string) jobs.Status {
+	job, err := backup.Restore(program, id, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return waitFor(t, job)
+}
+
+func TestArchive_RoundTrip(t *testing.T) {
+	dir, program := setupServer(t, programs.BackupFormatArchive)
+	defer os.RemoveAll(dir)
+	root := filepath.Join(dir, "root")
+	writeFile(t, filepath.Join(root, "world", "level.dat"), []byte("level"), 0600)
+	writeFile(t, filepath.Join(root, "server.properties"), []byte("motd=hello"), 0644)
+	os.Symlink("world/level.dat", filepath.Join(root, "level"))
+
+	created := createBackup(t, program)
+	if created.Format != programs.BackupFormatArchive || created.Files != 2 {
+		t.Errorf("Expected an archive of 2 files but got %+v", created)
+	}
+
+	os.RemoveAll(filepath.Join(root, "world"))
+	writeFile(t, filepath.Join(root, "server.properties"), []byte("motd=changed"), 0644)
+	writeFile(t, filepath.Join(root, "added.txt"), []byte("added"), 0644)
+	if status := restoreBackup(t, program, created.Id); status.Status != jobs.StatusCompleted {
+		t.Fatalf("Restore failed: %s", status.Error)
+	}
+
+	if contents := readFile(t, filepath.Join(root, "server.properties")); contents != "motd=hello" {
+		t.Errorf("Expected server.properties to be restored but got %q", contents)
+	}
+	if contents := readFile(t, filepath.Join(root, "level")); contents != "level" {
+		t.Errorf("Expected the symlink to lead to the restored level.dat but got %q", contents)
+	}
+	if info, err := os.Stat(filepath.Join(root, "world", "level.dat")); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("Expected level.dat to keep its mode but got %v, %v", info, err)
+	}
+	if _, err := os.Lstat(filepath.Join(root, "added.txt")); !os.IsNotExist(err) {
+		t.Errorf("Expected files which were not backed up to be removed but got %v", err)
+	}
+}
+
+func TestArchive_Malicious(t *testing.T) {
+	cases := map[string][]tar.Header{
+		"parent entry": {
+			{Name: "files/../escaped", Typeflag: tar.TypeReg},
+		},
+		"absolute symlink": {
+			{Name: "files/escaped", Typeflag: tar.TypeSymlink, Linkname: "/"},
+		},
+		"escaping symlink": {
+			{Name: "files/world/escaped", Typeflag: tar.TypeSymlink, Linkname: "../../.."},
+		},
+		"hardlink outside of root": {
+			{Name: "files/escaped", Typeflag: tar.TypeLink, Linkname: "files/../../secret"},
+		},
+		"hardlink outside of files": {
+			{Name: "files/escaped", Typeflag: tar.TypeLink, Linkname: "../secret"},
+		},
+		"symlinked parent": {
+			{Name: "files/world/", Typeflag: tar.TypeDir},
+			{Name: "files/alias", Typeflag: tar.TypeSymlink, Linkname: "world"},
+			{Name: "files/alias/escaped", Typeflag: tar.TypeReg},
+		},
+	}
+
+	for name, entries := range cases {
+		dir, program := setupServer(t, programs.BackupFormatArchive)
+		root := filepath.Join(dir, "root")
+		writeFile(t, filepath.Join(root, "server.properties"), []byte("motd=hello"), 0644)
+		writeFile(t, filepath.Join(dir, "secret"), []byte("secret"), 0600)
+
+		id := "20260101-000000-0000"
+		writeFile(t, filepath.Join(backup.Folder, "backedup", id+".tar.gz"), buildArchive(t, entries), 0644)
+		writeFile(t, filepath.Join(backup.Folder, "backedup", id+".json"),
+			[]byte(`{"id":"`+id+`","server":"backedup","format":"archive"}`), 0644)
+
+		status := restoreBackup(t, program, id)
+		if status.Status != jobs.StatusFailed {
+			t.Errorf("%s: expected the restore to fail but it completed", name)
+		}
+		if contents := readFile(t, filepath.Join(root, "server.properties")); contents != "motd=hello" {
+			t.Errorf("%s: expected the server files to be left alone but got %q", name, contents)
+		}
+		for _, file := range []string{filepath.Join(dir, "escaped"), filepath.Join(root, "escaped"), filepath.Join(root, "world", "escaped")} {
+			if _, err := os.Lstat(file); !os.IsNotExist(err) {
+				t.Errorf("%s: expected %s not to be written but got %v", name, file, err)
+			}
+		}
+		if entries, _ := ioutil.ReadDir(dir); len(entries) != 4 {
+			t.Errorf("%s: expected nothing to be left next to the root but found %d entries", name, len(entries))
+		}
+		os.RemoveAll(dir)
+	}
+}
+
+//Builds a backup archive holding the entries, each regular file containing "escaped".
+func buildArchive(t *testing.T, entries []tar.Header) []byte {
+	var buffer bytes.Buffer
+	compressed := gzip.NewWriter(&buffer)
+	archive := tar.NewWriter(compressed)
+	for _, entry := range entries {
+		entry.Mode = 0644
+		if entry.Typeflag == tar.TypeReg {
+			entry.Size = int64(len("escaped"))
+		}
+		err := archive.WriteHeader(&entry)
+		if err == nil && entry.Typeflag == tar.TypeReg {
+			_, err = archive.Write([]byte("escaped"))
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	archive.Close()
+	compressed.Close()
+	return buffer.Bytes()
+}
@@ -0,0 +1,508 @@
+/*
+ Copyright 2016 Padduck, LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+ 	http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+//Takes, lists and restores backups of servers, keeping them in a Storage.
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pufferpanel/pufferd/config"
+	"github.com/pufferpanel/pufferd/jobs"
+	"github.com/pufferpanel/pufferd/logging"
+	"github.com/pufferpanel/pufferd/programs"
+	"github.com/pufferpanel/pufferd/utils"
+)
+
+//Where local backups are kept.
+var Folder = utils.JoinPath("data", "backups")
+
+var store Storage
+
+//A finished backup of a server. Stored as <server>/<id>.json next to the archive <server>/<id>.tar.gz,
+//or the snapshot <server>/<id>.snapshot.
+//Size is how much the backup takes up in the storage. For snapshots Total is the size of the files it holds.
+type Backup struct {
+	Id      string    `json:"id"`
+	Server  string    `json:"server"`
+	Format  string    `json:"format"`
+	Created time.Time `json:"created"`
+	Size    int64     `json:"size"`
+	Total   int64     `json:"total,omitempty"`
+	Files   int       `json:"files"`
+}
+
+var (
+	busy     = make(map[string]bool)
+	busyLock sync.Mutex
+)
+
+//Where backups are kept, set by "backupstorage" in the config. Without it backups are kept in Folder.
+type storageConfig struct {
+	Type string `json:"type"`
+	S3Config
+}
+
+func Initialize() {
+	Folder = config.GetOrDefault("backupfolder", Folder)
+	store = NewLocalStorage(Folder)
+
+	var settings storageConfig
+	err := config.GetObject("backupstorage", &settings)
+	if err != nil {
+		logging.Error("Error reading backup storage config, keeping backups in "+Folder, err)
+		return
+	}
+	switch settings.Type {
+	case "", "local":
+	case "s3":
+		s3, err := NewS3Storage(settings.S3Config)
+		if err != nil {
+			logging.Error("Error configuring S3 backup storage, keeping backups in "+Folder, err)
+			return
+		}
+		store = s3
+		logging.Infof("Keeping backups in S3 bucket %s", settings.Bucket)
+	default:
+		logging.Errorf("Unknown backup storage type %s, keeping backups in %s", settings.Type, Folder)
+	}
+}
+
+//Starts a backup of a server, returning the job which reports its progress.
+//Only one backup or restore of a server runs at a time.
+func Create(program programs.Program) (*jobs.Job, error) {
+	serverId := program.Id()
+	settings := program.GetBackupSettings()
+	if !claim(serverId) {
+		return nil, errors.New("A backup or restore of this server is already running")
+	}
+
+	job := jobs.Create("backup", serverId)
+	go func() {
+		defer release(serverId)
+		backup, err := create(program, settings, job)
+		if err != nil {
+			logging.Error("Error backing up server "+serverId, err)
+			job.Fail(err)
+			return
+		}
+		logging.Infof("Created backup %s of server %s", backup.Id, serverId)
+		err = prune(serverId, settings.Retention)
+		if err != nil {
+			logging.Error("Error removing old backups of server "+serverId, err)
+		}
+		job.Complete()
+	}()
+	return job, nil
+}
+
+func create(program programs.Program, settings programs.BackupSettings, job *jobs.Job) (*Backup, error) {
+	serverId := program.Id()
+	root := program.GetEnvironment().GetRootDirectory()
+	definition, err := ioutil.ReadFile(utils.JoinPath(programs.ServerFolder, serverId+".json"))
+	if err != nil {
+		return nil, err
+	}
+
+	running := program.IsRunning()
+	if running {
+		job.SetMessage("Preparing server")
+		execute(program, settings.Pre)
+		time.Sleep(time.Duration(settings.Delay) * time.Second)
+	}
+
+	backup := &Backup{Id: newId(), Server: serverId, Format: settings.Format, Created: time.Now()}
+	if backup.Format == "" {
+		backup.Format = programs.BackupFormatArchive
+	}
+	job.SetMessage("Creating backup " + backup.Id)
+	total, err := utils.DirectorySize(root)
+	if err == nil {
+		job.SetProgress(0, total)
+	}
+	progress := func(done int64) {
+		job.SetProgress(done, total)
+	}
+	filter := &fileFilter{include: settings.Include, exclude: settings.Exclude}
+
+	if backup.Format == programs.BackupFormatSnapshot {
+		err = writeSnapshot(backup, definition, root, filter, progress)
+	} else {
+		reader, writer := io.Pipe()
+		go func() {
+			files, err := writeArchive(writer, definition, root, filter, progress)
+			backup.Files = files
+			writer.CloseWithError(err)
+		}()
+		backup.Size, err = store.Put(backup.dataName(), reader)
+		reader.CloseWithError(err)
+	}
+
+	if running {
+		execute(program, settings.Post)
+	}
+	if err != nil {
+		store.Delete(backup.dataName())
+		return nil, err
+	}
+
+	metadata, err := json.Marshal(backup)
+	if err == nil {
+		_, err = store.Put(metadataName(serverId, backup.Id), strings.NewReader(string(metadata)))
+	}
+	if err != nil {
+		store.Delete(backup.dataName())
+		return nil, err
+	}
+	return backup, nil
+}
+
+//Returns the backups of a server, newest first.
+func List(serverId string) ([]Backup, error) {
+	objects, err := store.List(serverId + "/")
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]Backup, 0)
+	for _, object := range objects {
+		if !strings.HasSuffix(object.Name, ".json") {
+			continue
+		}
+		backup, err := readMetadata(object.Name)
+		if err != nil {
+			logging.Error("Error reading backup "+object.Name, err)
+			continue
+		}
+		result = append(result, backup)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Created.After(result[j].Created)
+	})
+	return result, nil
+}
+
+func Get(serverId string, id string) (Backup, error) {
+	if !validId(id) {
+		return Backup{}, os.ErrNotExist
+	}
+	return readMetadata(metadataName(serverId, id))
+}
+
+//Opens a backup for reading as a gzip compressed tar. Snapshots are converted as they are read.
+func Open(serverId string, id string) (io.ReadCloser, error) {
+	backup, err := Get(serverId, id)
+	if err != nil {
+		return nil, err
+	}
+	if backup.Format != programs.BackupFormatSnapshot {
+		return store.Get(backup.dataName())
+	}
+
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(exportSnapshot(writer, backup))
+	}()
+	return reader, nil
+}
+
+//Deletes a backup. Chunks of a snapshot are left for CollectGarbage, as other snapshots may use them.
+func Delete(serverId string, id string) error {
+	backup, err := Get(serverId, id)
+	if err != nil {
+		return err
+	}
+	//the metadata goes first, so a failure part way leaves unlisted data rather than a broken backup
+	err = store.Delete(metadataName(serverId, id))
+	if err == nil {
+		err = store.Delete(backup.dataName())
+	}
+	return err
+}
+
+//Checks a backup can be read in full, returning the job which reports the result.
+//For snapshots every chunk is checked against its hash.
+func Verify(serverId string, id string) (*jobs.Job, error) {
+	backup, err := Get(serverId, id)
+	if err != nil {
+		return nil, err
+	}
+
+	job := jobs.Create("verify", serverId)
+	job.SetMessage("Verifying backup " + id)
+	go func() {
+		var err error
+		if backup.Format == programs.BackupFormatSnapshot {
+			err = verifySnapshot(backup, job)
+		} else {
+			err = verifyArchive(backup)
+		}
+		if err != nil {
+			logging.Error("Backup "+id+" of server "+serverId+" failed verification", err)
+			job.Fail(err)
+			return
+		}
+		job.Complete()
+	}()
+	return job, nil
+}
+
+func verifyArchive(backup Backup) error {
+	reader, err := store.Get(backup.dataName())
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	compressed, err := gzip.NewReader(reader)
+	if err != nil {
+		return err
+	}
+	archive := tar.NewReader(compressed)
+	for {
+		_, err = archive.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err == nil {
+			_, err = io.Copy(ioutil.Discard, archive)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+//Starts removing the snapshot chunks which are no longer used, returning the job which reports the result.
+func StartGarbageCollection() *jobs.Job {
+	job := jobs.Create("gc", "")
+	job.SetMessage("Removing unused chunks")
+	go func() {
+		removed, freed, err := CollectGarbage()
+		if err != nil {
+			logging.Error("Error removing unused chunks", err)
+			job.Fail(err)
+			return
+		}
+		job.SetMessage(fmt.Sprintf("Removed %d chunks, freeing %d bytes", removed, freed))
+		job.Complete()
+	}()
+	return job
+}
+
+//Replaces the files of a stopped server with those in a backup, and its definition too if restoreDefinition is set.
+//The current files are only removed once the backup has been fully extracted.
+func Restore(program programs.Program, id string, restoreDefinition bool) (*jobs.Job, error) {
+	serverId := program.Id()
+	if program.IsRunning() {
+		return nil, errors.New("Server must be stopped to restore a backup")
+	}
+	if _, err := Get(serverId, id); err != nil {
+		return nil, err
+	}
+	if !claim(serverId) {
+		return nil, errors.New("A backup or restore of this server is already running")
+	}
+
+	job := jobs.Create("restore", serverId)
+	job.SetMessage("Restoring backup " + id)
+	go func() {
+		defer release(serverId)
+		err := restore(program, id, restoreDefinition)
+		if err != nil {
+			logging.Error("Error restoring backup "+id+" of server "+serverId, err)
+			job.Fail(err)
+			return
+		}
+		logging.Infof("Restored backup %s of server %s", id, serverId)
+		job.Complete()
+	}()
+	return job, nil
+}
+
+func restore(program programs.Program, id string, restoreDefinition bool) error {
+	serverId := program.Id()
+	root := program.GetEnvironment().GetRootDirectory()
+	staging := root + ".restore"
+	previous := root + ".old"
+
+	backup, err := Get(serverId, id)
+	if err != nil {
+		return err
+	}
+
+	os.RemoveAll(staging)
+	err = os.MkdirAll(staging, 0755)
+	if err != nil {
+		return err
+	}
+	var definition []byte
+	if backup.Format == programs.BackupFormatSnapshot {
+		definition, err = restoreSnapshot(backup, staging)
+	} else {
+		definition, err = extractStored(backup, staging)
+	}
+	if err == nil && restoreDefinition {
+		if definition == nil {
+			err = errors.New("Backup does not contain a server definition")
+		} else {
+			_, err = programs.LoadFromData(serverId, definition)
+		}
+	}
+	if err != nil {
+		os.RemoveAll(staging)
+		return err
+	}
+
+	os.RemoveAll(previous)
+	err = os.Rename(root, previous)
+	if err != nil && !os.IsNotExist(err) {
+		os.RemoveAll(staging)
+		return err
+	}
+	err = os.Rename(staging, root)
+	if err != nil {
+		os.Rename(previous, root)
+		os.RemoveAll(staging)
+		return err
+	}
+	os.RemoveAll(previous)
+
+	if !restoreDefinition {
+		return nil
+	}
+	err = utils.WriteFileAtomic(utils.JoinPath(programs.ServerFolder, serverId+".json"), definition, 0664)
+	if err != nil {
+		return err
+	}
+	return programs.Reload(serverId)
+}
+
+func extractStored(backup Backup, root string) ([]byte, error) {
+	reader, err := store.Get(backup.dataName())
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	definition, _, err := extractArchive(reader, root)
+	return definition, err
+}
+
+//Deletes the backups of a server which fall outside of the retention limits,
+//then the chunks of any deleted snapshots no other snapshot uses.
+func prune(serverId string, retention programs.BackupRetention) error {
+	if retention.Count == 0 && retention.Days == 0 {
+		return nil
+	}
+	backups, err := List(serverId)
+	if err != nil {
+		return err
+	}
+
+	cutoff := time.Now().AddDate(0, 0, -retention.Days)
+	removedSnapshot := false
+	for i, backup := range backups {
+		expired := retention.Days > 0 && backup.Created.Before(cutoff)
+		if (retention.Count > 0 && i >= retention.Count) || expired {
+			err = Delete(serverId, backup.Id)
+			if err != nil {
+				return err
+			}
+			logging.Debugf("Removed backup %s of server %s", backup.Id, serverId)
+			removedSnapshot = removedSnapshot || backup.Format == programs.BackupFormatSnapshot
+		}
+	}
+
+	if removedSnapshot {
+		removed, freed, err := CollectGarbage()
+		if err != nil {
+			return err
+		}
+		logging.Debugf("Removed %d unused chunks, freeing %d bytes", removed, freed)
+	}
+	return nil
+}
+
+func readMetadata(name string) (Backup, error) {
+	var backup Backup
+	reader, err := store.Get(name)
+	if err != nil {
+		return backup, err
+	}
+	defer reader.Close()
+	err = json.NewDecoder(reader).Decode(&backup)
+	return backup, err
+}
+
+func execute(program programs.Program, commands []string) {
+	for _, command := range commands {
+		err := program.Execute(command)
+		if err != nil {
+			logging.Error("Error sending backup command to server "+program.Id(), err)
+		}
+	}
+}
+
+func claim(serverId string) bool {
+	busyLock.Lock()
+	defer busyLock.Unlock()
+	if busy[serverId] {
+		return false
+	}
+	busy[serverId] = true
+	return true
+}
+
+func release(serverId string) {
+	busyLock.Lock()
+	defer busyLock.Unlock()
+	delete(busy, serverId)
+}
+
+//Backup ids sort by the time they were taken.
+func newId() string {
+	bytes := make([]byte, 2)
+	rand.Read(bytes)
+	return time.Now().UTC().Format("20060102-150405") + "-" + hex.EncodeToString(bytes)
+}
+
+func validId(id string) bool {
+	return id != "" && !strings.ContainsAny(id, "/\\.")
+}
+
+//Returns the name of the archive or snapshot holding the backup.
+func (b Backup) dataName() string {
+	if b.Format == programs.BackupFormatSnapshot {
+		return b.Server + "/" + b.Id + snapshotSuffix
+	}
+	return b.Server + "/" + b.Id + ".tar.gz"
+}
+
+func metadataName(serverId string, id string) string {
+	return serverId + "/" + id + ".json"
+}
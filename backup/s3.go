@@ -0,0 +1,389 @@
+/*
+ Copyright 2016 Padduck, LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+ 	http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package backup
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//Where backups are kept in an S3 compatible object store. Objects are addressed path style,
+//as <endpoint>/<bucket>/<prefix><name>, which every S3 compatible store supports.
+type S3Config struct {
+	Endpoint  string `json:"endpoint"`
+	Region    string `json:"region,omitempty"`
+	Bucket    string `json:"bucket"`
+	Prefix    string `json:"prefix,omitempty"`
+	AccessKey string `json:"accessKey"`
+	SecretKey string `json:"secretKey"`
+
+	//Size of each part of a multipart upload, which is also the most a single upload holds in memory.
+	//S3 requires every part but the last to be at least 5 MiB.
+	PartSize int64 `json:"partSize,omitempty"`
+}
+
+const defaultPartSize = 16 * 1024 * 1024
+
+//Stores backups as objects in a bucket. Uploads are streamed in parts, so an object only becomes
+//visible once all of it has been uploaded.
+type s3Storage struct {
+	endpoint  *url.URL
+	region    string
+	bucket    string
+	prefix    string
+	accessKey string
+	secretKey string
+	partSize  int64
+	client    *http.Client
+}
+
+func NewS3Storage(config S3Config) (Storage, error) {
+	if config.Endpoint == "" || config.Bucket == "" || config.AccessKey == "" || config.SecretKey == "" {
+		return nil, errors.New("S3 storage requires an endpoint, bucket, accessKey and secretKey")
+	}
+	endpoint, err := url.Parse(strings.TrimSuffix(config.Endpoint, "/"))
+	if err != nil {
+		return nil, err
+	}
+	if endpoint.Scheme != "http" && endpoint.Scheme != "https" {
+		return nil, errors.New("S3 endpoint must be a http or https url")
+	}
+
+	storage := &s3Storage{
+		endpoint:  endpoint,
+		region:    config.Region,
+		bucket:    config.Bucket,
+		prefix:    strings.Trim(config.Prefix, "/"),
+		accessKey: config.AccessKey,
+		secretKey: config.SecretKey,
+		partSize:  config.PartSize,
+		client:    &http.Client{},
+	}
+	if storage.region == "" {
+		storage.region = "us-east-1"
+	}
+	if storage.prefix != "" {
+		storage.prefix += "/"
+	}
+	if storage.partSize <= 0 {
+		storage.partSize = defaultPartSize
+	}
+	return storage, nil
+}
+
+func (s *s3Storage) Put(name string, contents io.Reader) (size int64, err error) {
+	key := s.prefix + name
+	buffer := make([]byte, s.partSize)
+	read, err := io.ReadFull(contents, buffer)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		response, err := s.request("PUT", key, nil, buffer[:read])
+		if err != nil {
+			return 0, err
+		}
+		response.Body.Close()
+		return int64(read), nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	uploadId, err := s.createUpload(key)
+	if err != nil {
+		return 0, err
+	}
+	parts := make([]s3Part, 0)
+	for {
+		var etag string
+		etag, err = s.uploadPart(key, uploadId, len(parts)+1, buffer[:read])
+		if err != nil {
+			break
+		}
+		parts = append(parts, s3Part{Number: len(parts) + 1, ETag: etag})
+		size += int64(read)
+
+		read, err = io.ReadFull(contents, buffer)
+		if err == io.EOF {
+			err = s.completeUpload(key, uploadId, parts)
+			break
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			break
+		}
+	}
+
+	if err != nil {
+		if abortErr := s.abortUpload(key, uploadId); abortErr != nil {
+			err = errors.New(err.Error() + ", and the upload could not be aborted: " + abortErr.Error())
+		}
+		return 0, err
+	}
+	return size, nil
+}
+
+func (s *s3Storage) Get(name string) (io.ReadCloser, error) {
+	response, err := s.request("GET", s.prefix+name, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return response.Body, nil
+}
+
+func (s *s3Storage) Delete(name string) error {
+	response, err := s.request("DELETE", s.prefix+name, nil, nil)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	return nil
+}
+
+func (s *s3Storage) List(prefix string) ([]Object, error) {
+	result := make([]Object, 0)
+	query := url.Values{"list-type": {"2"}, "prefix": {s.prefix + prefix}}
+	for {
+		response, err := s.request("GET", "", query, nil)
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			Contents []struct {
+				Key          string
+				Size         int64
+				LastModified time.Time
+			}
+			IsTruncated           bool
+			NextContinuationToken string
+		}
+		err = xml.NewDecoder(response.Body).Decode(&page)
+		response.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, object := range page.Contents {
+			result = append(result, Object{Name: strings.TrimPrefix(object.Key, s.prefix), Size: object.Size, Modified: object.LastModified})
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return result, nil
+		}
+		query.Set("continuation-token", page.NextContinuationToken)
+	}
+}
+
+type s3Part struct {
+	Number int    `xml:"PartNumber"`
+	ETag   string `xml:"ETag"`
+}
+
+func (s *s3Storage) createUpload(key string) (string, error) {
+	response, err := s.request("POST", key, url.Values{"uploads": {""}}, nil)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	var result struct {
+		UploadId string
+	}
+	err = xml.NewDecoder(response.Body).Decode(&result)
+	if err == nil && result.UploadId == "" {
+		err = errors.New("S3 did not return an upload id")
+	}
+	return result.UploadId, err
+}
+
+func (s *s3Storage) uploadPart(key string, uploadId string, number int, data []byte) (string, error) {
+	query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {uploadId}}
+	response, err := s.request("PUT", key, query, data)
+	if err != nil {
+		return "", err
+	}
+	response.Body.Close()
+	return response.Header.Get("ETag"), nil
+}
+
+func (s *s3Storage) completeUpload(key string, uploadId string, parts []s3Part) error {
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []s3Part `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		return err
+	}
+	response, err := s.request("POST", key, url.Values{"uploadId": {uploadId}}, body)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	//completing can fail after the response has started, in which case the error is in the body
+	result, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	return s3Error(result)
+}
+
+func (s *s3Storage) abortUpload(key string, uploadId string) error {
+	response, err := s.request("DELETE", key, url.Values{"uploadId": {uploadId}}, nil)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	return nil
+}
+
+//Sends a signed request for an object, or the bucket if the key is empty.
+//Responses other than 2xx are turned into errors, with a missing object reported as os.ErrNotExist.
+func (s *s3Storage) request(method string, key string, query url.Values, body []byte) (*http.Response, error) {
+	path := s.endpoint.Path + "/" + uriEncode(s.bucket, false)
+	if key != "" {
+		path += "/" + uriEncode(key, false)
+	}
+	target := *s.endpoint
+	target.RawPath = path
+	target.Path, _ = url.PathUnescape(path)
+	target.RawQuery = canonicalQuery(query)
+	request, err := http.NewRequest(method, target.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	request.ContentLength = int64(len(body))
+	s.sign(request, path, body)
+
+	response, err := s.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode >= 200 && response.StatusCode < 300 {
+		return response, nil
+	}
+
+	defer response.Body.Close()
+	if response.StatusCode == http.StatusNotFound && method != "POST" {
+		return nil, &os.PathError{Op: strings.ToLower(method), Path: key, Err: os.ErrNotExist}
+	}
+	result, _ := ioutil.ReadAll(response.Body)
+	err = s3Error(result)
+	if err == nil {
+		err = errors.New("S3 request failed with status " + response.Status)
+	}
+	return nil, err
+}
+
+//Adds an AWS signature version 4 Authorization header to a request.
+func (s *s3Storage) sign(request *http.Request, path string, body []byte) {
+	now := time.Now().UTC()
+	timestamp := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payload := sha256.Sum256(body)
+	payloadHash := hex.EncodeToString(payload[:])
+
+	request.Header.Set("X-Amz-Date", timestamp)
+	request.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		request.Method,
+		path,
+		request.URL.RawQuery,
+		"host:" + request.URL.Host,
+		"x-amz-content-sha256:" + payloadHash,
+		"x-amz-date:" + timestamp,
+		"",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + s.region + "/s3/aws4_request"
+	hashedRequest := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + timestamp + "\n" + scope + "\n" + hex.EncodeToString(hashedRequest[:])
+
+	key := hmacSha256([]byte("AWS4"+s.secretKey), date)
+	key = hmacSha256(key, s.region)
+	key = hmacSha256(key, "s3")
+	key = hmacSha256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSha256(key, stringToSign))
+
+	request.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+s.accessKey+"/"+scope+", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func hmacSha256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+//Builds a query string sorted by key, encoded the way signature version 4 requires.
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, key := range keys {
+		for _, value := range query[key] {
+			parts = append(parts, uriEncode(key, true)+"="+uriEncode(value, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+//Percent encodes everything except unreserved characters, and slashes unless encodeSlash is set.
+func uriEncode(value string, encodeSlash bool) string {
+	var result bytes.Buffer
+	for _, b := range []byte(value) {
+		switch {
+		case b >= 'A' && b <= 'Z', b >= 'a' && b <= 'z', b >= '0' && b <= '9', b == '-', b == '_', b == '.', b == '~':
+			result.WriteByte(b)
+		case b == '/' && !encodeSlash:
+			result.WriteByte(b)
+		default:
+			result.WriteString("%" + strings.ToUpper(hex.EncodeToString([]byte{b})))
+		}
+	}
+	return result.String()
+}
+
+//Returns the error in an S3 error document, or nil if the body is not one.
+func s3Error(body []byte) error {
+	var result struct {
+		XMLName xml.Name
+		Code    string
+		Message string
+	}
+	if xml.Unmarshal(body, &result) != nil || result.XMLName.Local != "Error" {
+		return nil
+	}
+	return errors.New("S3 request failed: " + result.Code + ": " + result.Message)
+}
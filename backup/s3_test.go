@@ -0,0 +1,301 @@
+/*
+ Copyright 2016 Padduck, LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+ 	http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package backup_test
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pufferpanel/pufferd/backup"
+)
+
+const (
+	testAccessKey = "access"
+	testSecretKey = "secret"
+	testRegion    = "test-region"
+)
+
+//A minimal stand-in for an S3 compatible store, checking signatures the way S3 does.
+type fakeS3 struct {
+	lock      sync.Mutex
+	objects   map[string][]byte
+	uploads   map[string]map[int][]byte
+	completed int
+	pageSize  int
+}
+
+func newFakeS3() *fakeS3 {
+	return &fakeS3{objects: make(map[string][]byte), uploads: make(map[string]map[int][]byte), pageSize: 1000}
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	if message := checkSignature(r, body); message != "" {
+		writeError(w, 403, "SignatureDoesNotMatch", message)
+		return
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	query := r.URL.Query()
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+	if len(parts) == 1 {
+		f.list(w, query)
+		return
+	}
+	key := parts[1]
+
+	switch {
+	case r.Method == "POST" && query.Get("uploadId") == "":
+		id := strconv.Itoa(len(f.uploads) + f.completed + 1)
+		f.uploads[id] = make(map[int][]byte)
+		fmt.Fprintf(w, "<InitiateMultipartUploadResult><UploadId>%s</UploadId></InitiateMultipartUploadResult>", id)
+	case r.Method == "PUT" && query.Get("uploadId") != "":
+		number, _ := strconv.Atoi(query.Get("partNumber"))
+		f.uploads[query.Get("uploadId")][number] = body
+		w.Header().Set("ETag", etag(body))
+	case r.Method == "POST":
+		var request struct {
+			Parts []struct {
+				PartNumber int
+				ETag       string
+			} `xml:"Part"`
+		}
+		xml.Unmarshal(body, &request)
+		uploaded := f.uploads[query.Get("uploadId")]
+		var object []byte
+		for i, part := range request.Parts {
+			if part.PartNumber != i+1 || part.ETag != etag(uploaded[part.PartNumber]) {
+				writeError(w, 400, "InvalidPart", "Part "+strconv.Itoa(part.PartNumber)+" does not match")
+				return
+			}
+			object = append(object, uploaded[part.PartNumber]...)
+		}
+		delete(f.uploads, query.Get("uploadId"))
+		f.objects[key] = object
+		f.completed++
+		fmt.Fprint(w, "<CompleteMultipartUploadResult></CompleteMultipartUploadResult>")
+	case r.Method == "DELETE" && query.Get("uploadId") != "":
+		delete(f.uploads, query.Get("uploadId"))
+		w.WriteHeader(204)
+	case r.Method == "PUT":
+		f.objects[key] = body
+	case r.Method == "GET":
+		object, exists := f.objects[key]
+		if !exists {
+			writeError(w, 404, "NoSuchKey", "The specified key does not exist.")
+			return
+		}
+		w.Write(object)
+	case r.Method == "DELETE":
+		delete(f.objects, key)
+		w.WriteHeader(204)
+	}
+}
+
+func (f *fakeS3) list(w http.ResponseWriter, query url.Values) {
+	keys := make([]string, 0)
+	for key := range f.objects {
+		if strings.HasPrefix(key, query.Get("prefix")) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	start, _ := strconv.Atoi(query.Get("continuation-token"))
+	end := start + f.pageSize
+	truncated := end < len(keys)
+	if !truncated {
+		end = len(keys)
+	}
+	fmt.Fprint(w, "<ListBucketResult>")
+	for _, key := range keys[start:end] {
+		fmt.Fprintf(w, "<Contents><Key>%s</Key><Size>%d</Size><LastModified>%s</LastModified></Contents>", key, len(f.objects[key]), time.Now().UTC().Format(time.RFC3339))
+	}
+	fmt.Fprintf(w, "<IsTruncated>%v</IsTruncated><NextContinuationToken>%d</NextContinuationToken></ListBucketResult>", truncated, end)
+}
+
+func checkSignature(r *http.Request, body []byte) string {
+	payload := sha256.Sum256(body)
+	if r.Header.Get("X-Amz-Content-Sha256") != hex.EncodeToString(payload[:]) {
+		return "Payload hash does not match"
+	}
+
+	timestamp := r.Header.Get("X-Amz-Date")
+	date := strings.SplitN(timestamp, "T", 2)[0]
+	scope := date + "/" + testRegion + "/s3/aws4_request"
+
+	keys := make([]string, 0)
+	for key := range r.URL.Query() {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	query := make([]string, 0)
+	for _, key := range keys {
+		query = append(query, url.QueryEscape(key)+"="+url.QueryEscape(r.URL.Query().Get(key)))
+	}
+
+	canonical := r.Method + "\n" + r.URL.EscapedPath() + "\n" + strings.Join(query, "&") + "\n" +
+		"host:" + r.Host + "\nx-amz-content-sha256:" + r.Header.Get("X-Amz-Content-Sha256") + "\nx-amz-date:" + timestamp + "\n\n" +
+		"host;x-amz-content-sha256;x-amz-date\n" + r.Header.Get("X-Amz-Content-Sha256")
+	hashed := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + timestamp + "\n" + scope + "\n" + hex.EncodeToString(hashed[:])
+
+	key := []byte("AWS4" + testSecretKey)
+	for _, part := range []string{date, testRegion, "s3", "aws4_request", toSign} {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(part))
+		key = mac.Sum(nil)
+	}
+	expected := "AWS4-HMAC-SHA256 Credential=" + testAccessKey + "/" + scope + ", SignedHeaders=host;x-amz-content-sha256;x-amz-date, Signature=" + hex.EncodeToString(key)
+	if r.Header.Get("Authorization") != expected {
+		return "The request signature we calculated does not match the signature you provided."
+	}
+	return ""
+}
+
+func writeError(w http.ResponseWriter, status int, code string, message string) {
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message></Error>", code, message)
+}
+
+func etag(data []byte) string {
+	sum := md5.Sum(data)
+	return "\"" + hex.EncodeToString(sum[:]) + "\""
+}
+
+func newTestStorage(t *testing.T, endpoint string, secretKey string) backup.Storage {
+	storage, err := backup.NewS3Storage(backup.S3Config{
+		Endpoint:  endpoint,
+		Region:    testRegion,
+		Bucket:    "backups",
+		Prefix:    "node1",
+		AccessKey: testAccessKey,
+		SecretKey: secretKey,
+		PartSize:  1024,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return storage
+}
+
+func TestS3Storage(t *testing.T) {
+	fake := newFakeS3()
+	fake.pageSize = 1
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	storage := newTestStorage(t, server.URL, testSecretKey)
+
+	small := []byte(`{"id":"a"}`)
+	large := make([]byte, 3000)
+	rand.Read(large)
+	for name, data := range map[string][]byte{"srv/a.json": small, "srv/a.tar.gz": large} {
+		size, err := storage.Put(name, bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if size != int64(len(data)) {
+			t.Errorf("expected size %d for %s, got %d", len(data), name, size)
+		}
+	}
+	if fake.completed != 1 || len(fake.uploads) != 0 {
+		t.Errorf("expected 1 completed multipart upload and none pending, got %d and %d", fake.completed, len(fake.uploads))
+	}
+	if _, exists := fake.objects["node1/srv/a.tar.gz"]; !exists {
+		t.Errorf("expected object under the prefix, got %v", fake.objects)
+	}
+
+	reader, err := storage.Get("srv/a.tar.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	downloaded, err := ioutil.ReadAll(reader)
+	reader.Close()
+	if err != nil || !bytes.Equal(downloaded, large) {
+		t.Errorf("downloaded object does not match upload: %v", err)
+	}
+
+	objects, err := storage.List("srv/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 2 || objects[0].Name != "srv/a.json" || objects[1].Name != "srv/a.tar.gz" || objects[1].Size != 3000 {
+		t.Errorf("unexpected listing %+v", objects)
+	}
+
+	if err = storage.Delete("srv/a.json"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = storage.Get("srv/a.json"); !os.IsNotExist(err) {
+		t.Errorf("expected deleted object to not exist, got %v", err)
+	}
+	if err = storage.Delete("srv/a.json"); err != nil {
+		t.Errorf("expected deleting a missing object to succeed, got %v", err)
+	}
+}
+
+func TestS3Storage_AbortsFailedUpload(t *testing.T) {
+	fake := newFakeS3()
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	storage := newTestStorage(t, server.URL, testSecretKey)
+
+	failing := io.MultiReader(bytes.NewReader(make([]byte, 1500)), &errorReader{})
+	if _, err := storage.Put("srv/b.tar.gz", failing); err == nil {
+		t.Fatal("expected upload to fail")
+	}
+	if len(fake.uploads) != 0 || len(fake.objects) != 0 {
+		t.Errorf("expected the upload to be aborted, got %d uploads and %d objects", len(fake.uploads), len(fake.objects))
+	}
+}
+
+func TestS3Storage_BadCredentials(t *testing.T) {
+	server := httptest.NewServer(newFakeS3())
+	defer server.Close()
+	storage := newTestStorage(t, server.URL, "wrong")
+
+	_, err := storage.Put("srv/a.json", strings.NewReader("{}"))
+	if err == nil || !strings.Contains(err.Error(), "SignatureDoesNotMatch") {
+		t.Errorf("expected signature error, got %v", err)
+	}
+}
+
+type errorReader struct{}
+
+func (e *errorReader) Read(data []byte) (int, error) {
+	return 0, errors.New("Read failed")
+}
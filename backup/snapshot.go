@@ -0,0 +1,522 @@
+/*
+ Copyright 2016 Padduck, LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+ 	http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pufferpanel/pufferd/jobs"
+	"github.com/pufferpanel/pufferd/utils"
+)
+
+//Snapshots store each file as a list of chunks. Chunks are cut where a rolling hash of the content matches,
+//so a change to a file only alters the chunks around it. Each chunk is compressed and stored once, under the
+//sha256 of its content in .chunks/, shared by the snapshots of every server. A snapshot itself is a compressed
+//manifest at <server>/<id>.snapshot listing its files and their chunks.
+const (
+	chunkPrefix    = ".chunks/"
+	snapshotSuffix = ".snapshot"
+
+	minChunkSize = 512 * 1024
+	maxChunkSize = 4 * 1024 * 1024
+	//past the minimum size a chunk is cut on average once every MiB
+	chunkMask = 1<<20 - 1
+
+	entryDirectory = "dir"
+	entryFile      = "file"
+	entrySymlink   = "symlink"
+)
+
+var gearTable = func() (table [256]uint64) {
+	for i := range table {
+		sum := sha256.Sum256([]byte{byte(i)})
+		table[i] = binary.LittleEndian.Uint64(sum[:])
+	}
+	return
+}()
+
+//Keeps chunks from being collected while a snapshot which will reference them is written.
+var repositoryLock sync.RWMutex
+
+type snapshot struct {
+	Definition []byte          `json:"definition"`
+	Entries    []snapshotEntry `json:"entries"`
+}
+
+type snapshotEntry struct {
+	Path     string      `json:"path"`
+	Type     string      `json:"type"`
+	Mode     os.FileMode `json:"mode"`
+	Modified time.Time   `json:"modified"`
+	Size     int64       `json:"size,omitempty"`
+	Link     string      `json:"link,omitempty"`
+	Chunks   []string    `json:"chunks,omitempty"`
+}
+
+//Stores the files under root the filter accepts as a snapshot, writing only chunks which are not already stored.
+//The size of the backup is what it added to the storage, and its total the size of the files it holds.
+func writeSnapshot(backup *Backup, definition []byte, root string, filter *fileFilter, progress utils.CopyProgress) error {
+	repositoryLock.RLock()
+	defer repositoryLock.RUnlock()
+
+	known, err := storedChunks()
+	if err != nil {
+		return err
+	}
+
+	manifest := snapshot{Definition: definition, Entries: make([]snapshotEntry, 0)}
+	buffer := make([]byte, maxChunkSize)
+	err = walkFiles(root, filter, func(file string, relative string, info os.FileInfo, link string) error {
+		entry := snapshotEntry{Path: relative, Mode: info.Mode().Perm(), Modified: info.ModTime()}
+		switch {
+		case info.IsDir():
+			entry.Type = entryDirectory
+		case info.Mode()&os.ModeSymlink != 0:
+			entry.Type = entrySymlink
+			entry.Link = link
+		default:
+			entry.Type = entryFile
+			chunks, size, stored, err := storeFile(file, known, buffer, func(read int64) {
+				if progress != nil {
+					progress(backup.Total + read)
+				}
+			})
+			if err != nil {
+				return err
+			}
+			entry.Chunks = chunks
+			entry.Size = size
+			backup.Total += size
+			backup.Size += stored
+			backup.Files++
+		}
+		manifest.Entries = append(manifest.Entries, entry)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	data, err := compressJson(manifest)
+	if err != nil {
+		return err
+	}
+	size, err := store.Put(backup.dataName(), bytes.NewReader(data))
+	backup.Size += size
+	return err
+}
+
+//Splits a file into chunks and stores those not yet known, returning the chunk hashes,
+//the size of the file and the number of bytes stored. Progress is given the bytes read from the file.
+func storeFile(file string, known map[string]bool, buffer []byte, progress utils.CopyProgress) (chunks []string, size int64, stored int64, err error) {
+	source, err := os.Open(file)
+	if err != nil {
+		return
+	}
+	defer source.Close()
+
+	chunker := &chunker{reader: source, buffer: buffer}
+	chunks = make([]string, 0)
+	for {
+		var chunk []byte
+		chunk, err = chunker.next()
+		if err == io.EOF {
+			err = nil
+			return
+		}
+		if err != nil {
+			return
+		}
+
+		sum := sha256.Sum256(chunk)
+		hash := hex.EncodeToString(sum[:])
+		if !known[hash] {
+			var written int64
+			written, err = putChunk(hash, chunk)
+			if err != nil {
+				return
+			}
+			known[hash] = true
+			stored += written
+		}
+		chunks = append(chunks, hash)
+		size += int64(len(chunk))
+		progress(size)
+	}
+}
+
+//Cuts a stream into content defined chunks using a gear hash.
+type chunker struct {
+	reader io.Reader
+	buffer []byte
+	start  int
+	end    int
+	eof    bool
+}
+
+//Returns the next chunk, which is only valid until the following call.
+func (c *chunker) next() ([]byte, error) {
+	if c.end-c.start < maxChunkSize && !c.eof {
+		copy(c.buffer, c.buffer[c.start:c.end])
+		c.end -= c.start
+		c.start = 0
+		read, err := io.ReadFull(c.reader, c.buffer[c.end:])
+		c.end += read
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			c.eof = true
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	data := c.buffer[c.start:c.end]
+	if len(data) == 0 {
+		return nil, io.EOF
+	}
+	cut := cutPoint(data)
+	c.start += cut
+	return data[:cut], nil
+}
+
+func cutPoint(data []byte) int {
+	if len(data) <= minChunkSize {
+		return len(data)
+	}
+	limit := len(data)
+	if limit > maxChunkSize {
+		limit = maxChunkSize
+	}
+	var hash uint64
+	for i := minChunkSize; i < limit; i++ {
+		hash = hash<<1 + gearTable[data[i]]
+		if hash&chunkMask == 0 {
+			return i + 1
+		}
+	}
+	return limit
+}
+
+func putChunk(hash string, chunk []byte) (int64, error) {
+	var compressed bytes.Buffer
+	writer, _ := gzip.NewWriterLevel(&compressed, gzip.BestSpeed)
+	writer.Write(chunk)
+	err := writer.Close()
+	if err != nil {
+		return 0, err
+	}
+	return store.Put(chunkName(hash), &compressed)
+}
+
+//Returns the hashes of the chunks in the storage.
+func storedChunks() (map[string]bool, error) {
+	objects, err := store.List(chunkPrefix)
+	if err != nil {
+		return nil, err
+	}
+	known := make(map[string]bool, len(objects))
+	for _, object := range objects {
+		known[path.Base(object.Name)] = true
+	}
+	return known, nil
+}
+
+func chunkName(hash string) string {
+	return chunkPrefix + hash[:2] + "/" + hash
+}
+
+//Rebuilds the tree of a snapshot under root, returning the definition it holds.
+//Every chunk is checked against its hash as it is read.
+func restoreSnapshot(backup Backup, root string) ([]byte, error) {
+	manifest, err := readSnapshot(backup.dataName())
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range manifest.Entries {
+		relative, err := entryPath(filesPrefix + entry.Path)
+		if err != nil {
+			return nil, err
+		}
+		if relative == "" {
+			continue
+		}
+
+		switch entry.Type {
+		case entryDirectory:
+			err = restoreEntry(root, relative, tar.TypeDir, entry.Mode, "", entry.Modified, nil)
+		case entrySymlink:
+			err = restoreEntry(root, relative, tar.TypeSymlink, entry.Mode, entry.Link, entry.Modified, nil)
+		case entryFile:
+			reader := newChunkReader(entry.Chunks)
+			err = restoreEntry(root, relative, tar.TypeReg, entry.Mode, "", entry.Modified, reader)
+			reader.Close()
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return manifest.Definition, nil
+}
+
+//Writes a snapshot in the same form as writeArchive, so it can be downloaded like any other backup.
+func exportSnapshot(writer io.Writer, backup Backup) error {
+	manifest, err := readSnapshot(backup.dataName())
+	if err != nil {
+		return err
+	}
+
+	compressed := gzip.NewWriter(writer)
+	archive := tar.NewWriter(compressed)
+	err = archive.WriteHeader(&tar.Header{Name: definitionEntry, Mode: 0644, Size: int64(len(manifest.Definition)), Typeflag: tar.TypeReg})
+	if err == nil {
+		_, err = archive.Write(manifest.Definition)
+	}
+
+	for _, entry := range manifest.Entries {
+		if err != nil {
+			return err
+		}
+		header := &tar.Header{Name: filesPrefix + entry.Path, Mode: int64(entry.Mode), ModTime: entry.Modified}
+		switch entry.Type {
+		case entryDirectory:
+			header.Typeflag = tar.TypeDir
+			header.Name += "/"
+		case entrySymlink:
+			header.Typeflag = tar.TypeSymlink
+			header.Linkname = entry.Link
+		default:
+			header.Typeflag = tar.TypeReg
+			header.Size = entry.Size
+		}
+		err = archive.WriteHeader(header)
+		if err != nil || header.Typeflag != tar.TypeReg {
+			continue
+		}
+		reader := newChunkReader(entry.Chunks)
+		_, err = io.CopyN(archive, reader, entry.Size)
+		reader.Close()
+	}
+
+	if err == nil {
+		err = archive.Close()
+	}
+	if err == nil {
+		err = compressed.Close()
+	}
+	return err
+}
+
+//Reads every chunk of a snapshot, failing if any is missing or does not match its hash.
+func verifySnapshot(backup Backup, job *jobs.Job) error {
+	manifest, err := readSnapshot(backup.dataName())
+	if err != nil {
+		return err
+	}
+
+	chunks := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, entry := range manifest.Entries {
+		for _, chunk := range entry.Chunks {
+			if !seen[chunk] {
+				seen[chunk] = true
+				chunks = append(chunks, chunk)
+			}
+		}
+	}
+
+	failed := make([]string, 0)
+	for i, chunk := range chunks {
+		reader := newChunkReader([]string{chunk})
+		_, err := io.Copy(ioutil.Discard, reader)
+		reader.Close()
+		if err != nil {
+			failed = append(failed, chunk)
+		}
+		job.SetProgress(int64(i+1), int64(len(chunks)))
+	}
+
+	if len(failed) > 0 {
+		if len(failed) > 10 {
+			failed = append(failed[:10], "...")
+		}
+		return fmt.Errorf("%d of %d chunks are missing or corrupt: %s", len(failed), len(chunks), strings.Join(failed, ", "))
+	}
+	job.SetMessage(fmt.Sprintf("Verified %d chunks", len(chunks)))
+	return nil
+}
+
+//Deletes the chunks no snapshot references, returning how many were removed and their size.
+//Nothing is deleted if any snapshot cannot be read, since its chunks would be lost.
+func CollectGarbage() (removed int, freed int64, err error) {
+	repositoryLock.Lock()
+	defer repositoryLock.Unlock()
+
+	objects, err := store.List("")
+	if err != nil {
+		return
+	}
+
+	referenced := make(map[string]bool)
+	for _, object := range objects {
+		if !strings.HasSuffix(object.Name, snapshotSuffix) {
+			continue
+		}
+		var manifest *snapshot
+		manifest, err = readSnapshot(object.Name)
+		if err != nil {
+			err = errors.New("Error reading snapshot " + object.Name + ": " + err.Error())
+			return
+		}
+		for _, entry := range manifest.Entries {
+			for _, chunk := range entry.Chunks {
+				referenced[chunk] = true
+			}
+		}
+	}
+
+	for _, object := range objects {
+		if !strings.HasPrefix(object.Name, chunkPrefix) || referenced[path.Base(object.Name)] {
+			continue
+		}
+		err = store.Delete(object.Name)
+		if err != nil {
+			return
+		}
+		removed++
+		freed += object.Size
+	}
+	return
+}
+
+func readSnapshot(name string) (*snapshot, error) {
+	reader, err := store.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	decompressed, err := gzip.NewReader(reader)
+	if err != nil {
+		return nil, err
+	}
+	manifest := &snapshot{}
+	err = json.NewDecoder(decompressed).Decode(manifest)
+	return manifest, err
+}
+
+func compressJson(value interface{}) ([]byte, error) {
+	var buffer bytes.Buffer
+	writer := gzip.NewWriter(&buffer)
+	err := json.NewEncoder(writer).Encode(value)
+	if err == nil {
+		err = writer.Close()
+	}
+	return buffer.Bytes(), err
+}
+
+//Reads the contents of a list of chunks in order, failing if a chunk does not match its hash.
+type chunkReader struct {
+	chunks   []string
+	current  io.ReadCloser
+	content  io.Reader
+	hash     hash.Hash
+	expected string
+}
+
+func newChunkReader(chunks []string) *chunkReader {
+	return &chunkReader{chunks: chunks}
+}
+
+func (c *chunkReader) Read(data []byte) (int, error) {
+	for {
+		if c.content == nil {
+			if len(c.chunks) == 0 {
+				return 0, io.EOF
+			}
+			err := c.open(c.chunks[0])
+			c.chunks = c.chunks[1:]
+			if err != nil {
+				return 0, err
+			}
+		}
+
+		read, err := c.content.Read(data)
+		c.hash.Write(data[:read])
+		if err == io.EOF {
+			err = c.finish()
+			if read > 0 || err != nil {
+				return read, err
+			}
+			continue
+		}
+		return read, err
+	}
+}
+
+func (c *chunkReader) open(chunk string) error {
+	if len(chunk) < 2 {
+		return errors.New("Invalid chunk " + chunk)
+	}
+	reader, err := store.Get(chunkName(chunk))
+	if err != nil {
+		return err
+	}
+	content, err := gzip.NewReader(reader)
+	if err != nil {
+		reader.Close()
+		return err
+	}
+	c.current = reader
+	c.content = content
+	c.hash = sha256.New()
+	c.expected = chunk
+	return nil
+}
+
+func (c *chunkReader) finish() error {
+	c.current.Close()
+	c.current = nil
+	c.content = nil
+	if hex.EncodeToString(c.hash.Sum(nil)) != c.expected {
+		return errors.New("Chunk " + c.expected + " is corrupt")
+	}
+	return nil
+}
+
+func (c *chunkReader) Close() error {
+	if c.current != nil {
+		c.current.Close()
+		c.current = nil
+		c.content = nil
+	}
+	return nil
+}
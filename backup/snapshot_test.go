@@ -0,0 +1,227 @@
+/*
+ Copyright 2016 Padduck, LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+ 	http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+
+package backup_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pufferpanel/pufferd/backup"
+	"github.com/pufferpanel/pufferd/jobs"
+	"github.com/pufferpanel/pufferd/programs"
+)
+
+func randomBytes(t *testing.T, size int) []byte {
+	data := make([]byte, size)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+//Returns the chunks in the storage, by hash.
+func storedChunks(t *testing.T) map[string]string {
+	files, err := filepath.Glob(filepath.Join(backup.Folder, ".chunks", "*", "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunks := make(map[string]string, len(files))
+	for _, file := range files {
+		chunks[filepath.Base(file)] = file
+	}
+	return chunks
+}
+
+func verifyBackup(t *testing.T, serverId string, id string) jobs.Status {
+	job, err := backup.Verify(serverId, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return waitFor(t, job)
+}
+
+func TestSnapshot_RoundTrip(t *testing.T) {
+	dir, program := setupServer(t, programs.BackupFormatSnapshot)
+	defer os.RemoveAll(dir)
+	root := filepath.Join(dir, "root")
+	world := randomBytes(t, 3*1024*1024)
+	writeFile(t, filepath.Join(root, "world", "region.mca"), world, 0600)
+	writeFile(t, filepath.Join(root, "server.properties"), []byte("motd=hello"), 0644)
+	os.Symlink("world/region.mca", filepath.Join(root, "region"))
+
+	first := createBackup(t, program)
+	if first.Format != programs.BackupFormatSnapshot || first.Files != 2 || first.Total != int64(len(world))+10 {
+		t.Errorf("Expected a snapshot of 2 files but got %+v", first)
+	}
+	chunks := storedChunks(t)
+	if len(chunks) < 3 {
+		t.Errorf("Expected the world to be split into chunks but found %d chunks", len(chunks))
+	}
+
+	//an unchanged tree only adds the manifest
+	second := createBackup(t, program)
+	if second.Size >= first.Size/10 || len(storedChunks(t)) != len(chunks) {
+		t.Errorf("Expected no chunks to be stored again but the snapshot took %d bytes", second.Size)
+	}
+
+	//a change at the end of a file only replaces its last chunk
+	copy(world[len(world)-100:], randomBytes(t, 100))
+	writeFile(t, filepath.Join(root, "world", "region.mca"), world, 0600)
+	third := createBackup(t, program)
+	if count := len(storedChunks(t)); count != len(chunks)+1 {
+		t.Errorf("Expected one new chunk but found %d chunks, up from %d", count, len(chunks))
+	}
+
+	os.RemoveAll(filepath.Join(root, "world"))
+	writeFile(t, filepath.Join(root, "server.properties"), []byte("motd=changed"), 0644)
+	if status := restoreBackup(t, program, third.Id); status.Status != jobs.StatusCompleted {
+		t.Fatalf("Restore failed: %s", status.Error)
+	}
+	if contents := readFile(t, filepath.Join(root, "server.properties")); contents != "motd=hello" {
+		t.Errorf("Expected server.properties to be restored but got %q", contents)
+	}
+	if contents := readFile(t, filepath.Join(root, "region")); contents != string(world) {
+		t.Error("Expected the symlink to lead to the restored region.mca")
+	}
+	if info, err := os.Stat(filepath.Join(root, "world", "region.mca")); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("Expected region.mca to keep its mode but got %v, %v", info, err)
+	}
+
+	//snapshots download as archives
+	reader, err := backup.Open("backedup", third.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	compressed, err := gzip.NewReader(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive := tar.NewReader(compressed)
+	found := false
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if header.Name == "files/world/region.mca" {
+			contents, err := ioutil.ReadAll(archive)
+			found = err == nil && bytes.Equal(contents, world)
+		}
+	}
+	if !found {
+		t.Error("Expected the exported archive to hold region.mca")
+	}
+}
+
+func TestSnapshot_Verify(t *testing.T) {
+	dir, program := setupServer(t, programs.BackupFormatSnapshot)
+	defer os.RemoveAll(dir)
+	root := filepath.Join(dir, "root")
+	writeFile(t, filepath.Join(root, "world", "region.mca"), randomBytes(t, 2*1024*1024), 0644)
+	writeFile(t, filepath.Join(root, "server.properties"), []byte("motd=hello"), 0644)
+
+	created := createBackup(t, program)
+	if status := verifyBackup(t, "backedup", created.Id); status.Status != jobs.StatusCompleted {
+		t.Fatalf("Expected the snapshot to verify but got %s", status.Error)
+	}
+
+	var corrupt, missing string
+	for hash, file := range storedChunks(t) {
+		if corrupt == "" {
+			corrupt = hash
+			var replacement bytes.Buffer
+			writer := gzip.NewWriter(&replacement)
+			writer.Write([]byte("corrupt"))
+			writer.Close()
+			writeFile(t, file, replacement.Bytes(), 0644)
+		} else if missing == "" {
+			missing = hash
+			os.Remove(file)
+		}
+	}
+
+	status := verifyBackup(t, "backedup", created.Id)
+	if status.Status != jobs.StatusFailed || !strings.Contains(status.Error, corrupt) || !strings.Contains(status.Error, missing) {
+		t.Errorf("Expected chunks %s and %s to fail verification but got %+v", corrupt, missing, status)
+	}
+	if status := restoreBackup(t, program, created.Id); status.Status != jobs.StatusFailed {
+		t.Error("Expected restoring a corrupt snapshot to fail")
+	}
+	if contents := readFile(t, filepath.Join(root, "server.properties")); contents != "motd=hello" {
+		t.Errorf("Expected the server files to be left alone but got %q", contents)
+	}
+}
+
+func TestCollectGarbage(t *testing.T) {
+	dir, program := setupServer(t, programs.BackupFormatSnapshot)
+	defer os.RemoveAll(dir)
+	root := filepath.Join(dir, "root")
+	writeFile(t, filepath.Join(root, "world", "region.mca"), randomBytes(t, 2*1024*1024), 0644)
+	writeFile(t, filepath.Join(root, "server.properties"), []byte("motd=hello"), 0644)
+
+	first := createBackup(t, program)
+	firstChunks := storedChunks(t)
+	writeFile(t, filepath.Join(root, "world", "region.mca"), randomBytes(t, 2*1024*1024), 0644)
+	second := createBackup(t, program)
+	allChunks := storedChunks(t)
+
+	if removed, _, err := backup.CollectGarbage(); err != nil || removed != 0 {
+		t.Errorf("Expected no chunks to be removed while every snapshot is kept but removed %d, %v", removed, err)
+	}
+
+	//nothing is collected while a snapshot cannot be read
+	err := backup.Delete("backedup", first.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(backup.Folder, "other", "broken.snapshot"), []byte("broken"), 0644)
+	if _, _, err := backup.CollectGarbage(); err == nil || len(storedChunks(t)) != len(allChunks) {
+		t.Errorf("Expected collection to stop at the broken snapshot but got %v", err)
+	}
+	os.Remove(filepath.Join(backup.Folder, "other", "broken.snapshot"))
+
+	//only the first world's chunks are removed, the unchanged server.properties is shared with the second snapshot
+	removed, freed, err := backup.CollectGarbage()
+	if err != nil || removed != len(firstChunks)-1 || freed <= 0 {
+		t.Errorf("Expected %d chunks to be removed but removed %d freeing %d bytes, %v", len(firstChunks)-1, removed, freed, err)
+	}
+	remaining := storedChunks(t)
+	for hash := range allChunks {
+		if _, existed := firstChunks[hash]; !existed && remaining[hash] == "" {
+			t.Errorf("Expected chunk %s of the kept snapshot to remain", hash)
+		}
+	}
+	if status := verifyBackup(t, "backedup", second.Id); status.Status != jobs.StatusCompleted {
+		t.Errorf("Expected the kept snapshot to verify but got %s", status.Error)
+	}
+	if removed, _, err := backup.CollectGarbage(); err != nil || removed != 0 {
+		t.Errorf("Expected nothing left to collect but removed %d, %v", removed, err)
+	}
+}
@@ -0,0 +1,143 @@
+/*
+ Copyright 2016 Padduck, LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+ 	http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package backup
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+//Somewhere backups are kept. Names are slash separated paths relative to the root of the storage.
+type Storage interface {
+	//Stores the contents read from the reader under the name, replacing anything already there.
+	//Readers of the name never see a partially written object.
+	Put(name string, contents io.Reader) (size int64, err error)
+
+	Get(name string) (io.ReadCloser, error)
+
+	Delete(name string) error
+
+	//Lists the objects whose names start with the prefix.
+	List(prefix string) ([]Object, error)
+}
+
+type Object struct {
+	Name     string
+	Size     int64
+	Modified time.Time
+}
+
+//Stores backups as files under a folder.
+type localStorage struct {
+	root string
+}
+
+func NewLocalStorage(root string) Storage {
+	return &localStorage{root: root}
+}
+
+func (l *localStorage) path(name string) (string, error) {
+	clean := filepath.FromSlash(name)
+	if name == "" || filepath.IsAbs(clean) || strings.HasPrefix(filepath.Clean(clean), "..") {
+		return "", errors.New("Invalid object name " + name)
+	}
+	return filepath.Join(l.root, clean), nil
+}
+
+func (l *localStorage) Put(name string, contents io.Reader) (size int64, err error) {
+	path, err := l.path(name)
+	if err != nil {
+		return
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return
+	}
+
+	file, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return
+	}
+	size, err = io.Copy(file, contents)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), path)
+	}
+	if err != nil {
+		os.Remove(file.Name())
+	}
+	return
+}
+
+func (l *localStorage) Get(name string) (io.ReadCloser, error) {
+	path, err := l.path(name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(path)
+}
+
+func (l *localStorage) Delete(name string) error {
+	path, err := l.path(name)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (l *localStorage) List(prefix string) ([]Object, error) {
+	result := make([]Object, 0)
+	start := l.root
+	if index := strings.LastIndex(prefix, "/"); index > 0 {
+		start = filepath.Join(l.root, filepath.FromSlash(prefix[:index]))
+	}
+	err := filepath.Walk(start, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() || strings.HasPrefix(info.Name(), ".") {
+			return nil
+		}
+		relative, err := filepath.Rel(l.root, path)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(relative)
+		if strings.HasPrefix(name, prefix) {
+			result = append(result, Object{Name: name, Size: info.Size(), Modified: info.ModTime()})
+		}
+		return nil
+	})
+	return result, err
+}
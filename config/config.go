@@ -69,3 +69,17 @@ func GetOrDefault(key string, def string) string {
 	}
 	return val
 }
+
+//Decodes a structured config value, such as a list or object, into target.
+//Target is left untouched if the key is not set.
+func GetObject(key string, target interface{}) error {
+	val := config[key]
+	if val == nil {
+		return nil
+	}
+	data, err := json.Marshal(val)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, target)
+}
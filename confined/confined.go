@@ -0,0 +1,327 @@
+/*
+ Copyright 2016 Padduck, LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+ 	http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+//Confines file access to a directory, such as the root of a server.
+//Paths are resolved a component at a time, following symlinks only while they stay inside the directory,
+//so neither .. nor a symlink made by the server can reach files outside of it.
+package confined
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+//How many symlinks can be followed resolving one path, as with the limit of the kernel.
+const maxSymlinks = 40
+
+var ErrOutside = errors.New("Path is outside of the root")
+
+var errTooManySymlinks = errors.New("Too many levels of symbolic links")
+
+type FS struct {
+	root string
+}
+
+//Confines access to the directory, which does not need to exist yet.
+func New(root string) *FS {
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		abs = filepath.Clean(root)
+	}
+	//the root may be reached through a symlink itself, which is trusted
+	if real, err := filepath.EvalSymlinks(abs); err == nil {
+		abs = real
+	}
+	return &FS{root: abs}
+}
+
+func (f *FS) Root() string {
+	return f.root
+}
+
+//Reports whether an absolute path is the root or inside it, comparing whole path components.
+func (f *FS) Contains(file string) bool {
+	relative, err := filepath.Rel(f.root, file)
+	if err != nil {
+		return false
+	}
+	return relative != ".." && !strings.HasPrefix(relative, ".."+string(filepath.Separator)) && !filepath.IsAbs(relative)
+}
+
+//Returns the path of a file inside the root relative to it, with slashes. The root itself is an empty path.
+func (f *FS) Rel(file string) (string, error) {
+	if !f.Contains(file) {
+		return "", ErrOutside
+	}
+	relative, err := filepath.Rel(f.root, file)
+	if err != nil {
+		return "", err
+	}
+	if relative == "." {
+		return "", nil
+	}
+	return filepath.ToSlash(relative), nil
+}
+
+//Returns the absolute path of a name relative to the root, following every symlink along it.
+//Fails with ErrOutside if the name, or a symlink, leads out of the root. Parts of the path which do not
+//exist yet are kept as they are, so the result can be used to create a file.
+func (f *FS) Resolve(name string) (string, error) {
+	return f.resolve(name, true)
+}
+
+//Resolves a name as Resolve does, except a symlink at the end of it is not followed,
+//for removing, renaming or reading the link itself.
+func (f *FS) Lresolve(name string) (string, error) {
+	return f.resolve(name, false)
+}
+
+func (f *FS) resolve(name string, followLast bool) (string, error) {
+	parts, err := split(name)
+	if err != nil {
+		return "", err
+	}
+
+	current := f.root
+	followed := 0
+	for len(parts) > 0 {
+		part := parts[0]
+		parts = parts[1:]
+		if part == ".." {
+			if current == f.root {
+				return "", ErrOutside
+			}
+			current = filepath.Dir(current)
+			continue
+		}
+
+		next := filepath.Join(current, part)
+		if len(parts) == 0 && !followLast {
+			return next, nil
+		}
+		info, err := os.Lstat(next)
+		if os.IsNotExist(err) {
+			//nothing below a missing directory can be a symlink, and the name holds no .. past its start
+			return filepath.Join(append([]string{next}, parts...)...), nil
+		}
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			current = next
+			continue
+		}
+
+		followed++
+		if followed > maxSymlinks {
+			return "", errTooManySymlinks
+		}
+		link, err := os.Readlink(next)
+		if err != nil {
+			return "", err
+		}
+		if filepath.IsAbs(link) {
+			//absolute links are allowed as long as they point inside the root
+			if !f.Contains(filepath.Clean(link)) {
+				return "", ErrOutside
+			}
+			relative, _ := f.Rel(filepath.Clean(link))
+			current = f.root
+			link = relative
+		}
+		linkParts, err := split(link)
+		if err != nil {
+			return "", err
+		}
+		parts = append(linkParts, parts...)
+	}
+	return current, nil
+}
+
+//Splits a name into cleaned components. Any .. left after cleaning comes first.
+func split(name string) ([]string, error) {
+	if strings.IndexByte(name, 0) >= 0 {
+		return nil, errors.New("Path contains a zero byte")
+	}
+	cleaned := path.Clean(filepath.ToSlash(name))
+	cleaned = strings.TrimPrefix(cleaned, "/")
+	if cleaned == "." || cleaned == "" {
+		return []string{}, nil
+	}
+	return strings.Split(cleaned, "/"), nil
+}
+
+//Opens a file for reading.
+func (f *FS) Open(name string) (*os.File, error) {
+	return f.OpenFile(name, os.O_RDONLY, 0)
+}
+
+//Opens a file as os.OpenFile does. Where the kernel supports it, the open itself is confined to the root,
+//so a symlink swapped in after the path was resolved cannot lead out of it.
+func (f *FS) OpenFile(name string, flag int, perm os.FileMode) (*os.File, error) {
+	resolved, err := f.Resolve(name)
+	if err != nil {
+		return nil, err
+	}
+	return openBeneath(f.root, resolved, flag, perm)
+}
+
+func (f *FS) Stat(name string) (os.FileInfo, error) {
+	resolved, err := f.Resolve(name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Stat(resolved)
+}
+
+func (f *FS) Lstat(name string) (os.FileInfo, error) {
+	resolved, err := f.Lresolve(name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Lstat(resolved)
+}
+
+func (f *FS) ReadDir(name string) ([]os.FileInfo, error) {
+	directory, err := f.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer directory.Close()
+	return directory.Readdir(-1)
+}
+
+func (f *FS) ReadFile(name string) ([]byte, error) {
+	file, err := f.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ioutil.ReadAll(file)
+}
+
+func (f *FS) Mkdir(name string, perm os.FileMode) error {
+	resolved, err := f.Lresolve(name)
+	if err != nil {
+		return err
+	}
+	return os.Mkdir(resolved, perm)
+}
+
+func (f *FS) MkdirAll(name string, perm os.FileMode) error {
+	resolved, err := f.Resolve(name)
+	if err != nil {
+		return err
+	}
+	return os.MkdirAll(resolved, perm)
+}
+
+//Removes a file or empty directory. A symlink is removed rather than what it points to.
+func (f *FS) Remove(name string) error {
+	resolved, err := f.Lresolve(name)
+	if err != nil {
+		return err
+	}
+	if resolved == f.root {
+		return ErrOutside
+	}
+	return os.Remove(resolved)
+}
+
+//Removes a file or directory with everything in it. Symlinks inside it are removed, not followed.
+func (f *FS) RemoveAll(name string) error {
+	resolved, err := f.Lresolve(name)
+	if err != nil {
+		return err
+	}
+	if resolved == f.root {
+		return ErrOutside
+	}
+	return os.RemoveAll(resolved)
+}
+
+//Renames a file, directory or symlink. An existing file at the new name is replaced rather than written through.
+func (f *FS) Rename(oldName string, newName string) error {
+	from, err := f.Lresolve(oldName)
+	if err != nil {
+		return err
+	}
+	to, err := f.Lresolve(newName)
+	if err != nil {
+		return err
+	}
+	if from == f.root || to == f.root {
+		return ErrOutside
+	}
+	return os.Rename(from, to)
+}
+
+//Creates a symlink at name pointing to target, which must lead to somewhere inside the root.
+func (f *FS) Symlink(target string, name string) error {
+	resolved, err := f.Lresolve(name)
+	if err != nil {
+		return err
+	}
+	if resolved == f.root {
+		return ErrOutside
+	}
+	destination := target
+	if !filepath.IsAbs(target) {
+		destination = filepath.Join(filepath.Dir(resolved), target)
+	}
+	if !f.Contains(filepath.Clean(destination)) {
+		return ErrOutside
+	}
+	return os.Symlink(target, resolved)
+}
+
+func (f *FS) Readlink(name string) (string, error) {
+	resolved, err := f.Lresolve(name)
+	if err != nil {
+		return "", err
+	}
+	return os.Readlink(resolved)
+}
+
+//Changes the mode of a file, or of what a symlink inside the root points to.
+func (f *FS) Chmod(name string, mode os.FileMode) error {
+	resolved, err := f.Resolve(name)
+	if err != nil {
+		return err
+	}
+	return os.Chmod(resolved, mode)
+}
+
+func (f *FS) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	resolved, err := f.Resolve(name)
+	if err != nil {
+		return err
+	}
+	return os.Chtimes(resolved, atime, mtime)
+}
+
+func (f *FS) Truncate(name string, size int64) error {
+	resolved, err := f.Resolve(name)
+	if err != nil {
+		return err
+	}
+	return os.Truncate(resolved, size)
+}
@@ -0,0 +1,103 @@
+/*
+ Copyright 2016 Padduck, LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+ 	http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package confined_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pufferpanel/pufferd/confined"
+)
+
+func TestResolve(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pufferd-confined")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	root := filepath.Join(dir, "server")
+	os.MkdirAll(filepath.Join(root, "world"), 0755)
+	os.MkdirAll(filepath.Join(dir, "server2"), 0755)
+	ioutil.WriteFile(filepath.Join(root, "world", "level.dat"), []byte("level"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "server2", "secret"), []byte("secret"), 0644)
+	os.Symlink("world", filepath.Join(root, "current"))
+	os.Symlink(filepath.Join(root, "world", "level.dat"), filepath.Join(root, "level"))
+	os.Symlink("../server2", filepath.Join(root, "sibling"))
+	os.Symlink("/etc", filepath.Join(root, "etc"))
+	os.Symlink("loop", filepath.Join(root, "loop"))
+
+	fs := confined.New(root)
+	root = fs.Root()
+
+	tests := []struct {
+		name     string
+		expected string
+		err      error
+	}{
+		{name: "", expected: root},
+		{name: "/world/../world/level.dat", expected: filepath.Join(root, "world", "level.dat")},
+		{name: "current/level.dat", expected: filepath.Join(root, "world", "level.dat")},
+		{name: "level", expected: filepath.Join(root, "world", "level.dat")},
+		{name: "current/new/file", expected: filepath.Join(root, "world", "new", "file")},
+		{name: "..", err: confined.ErrOutside},
+		{name: "../server2/secret", err: confined.ErrOutside},
+		{name: "sibling/secret", err: confined.ErrOutside},
+		{name: "etc/passwd", err: confined.ErrOutside},
+	}
+	for _, test := range tests {
+		resolved, err := fs.Resolve(test.name)
+		if err != test.err || resolved != test.expected {
+			t.Errorf("Resolving %q got %q, %v", test.name, resolved, err)
+		}
+	}
+	if _, err := fs.Resolve("loop"); err == nil {
+		t.Error("Expected a symlink loop to fail")
+	}
+
+	if resolved, err := fs.Lresolve("sibling"); err != nil || resolved != filepath.Join(root, "sibling") {
+		t.Errorf("Expected the link itself but got %q, %v", resolved, err)
+	}
+	if err := fs.Remove("sibling"); err != nil {
+		t.Error(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "server2", "secret")); err != nil {
+		t.Error("Expected removing a link to leave its target")
+	}
+
+	if data, err := fs.ReadFile("current/level.dat"); err != nil || string(data) != "level" {
+		t.Errorf("Unexpected contents %q, %v", data, err)
+	}
+	if file, err := fs.OpenFile("current/level.dat", os.O_WRONLY|os.O_APPEND, 0644); err != nil {
+		t.Errorf("Expected opening an existing file to write to work but got %v", err)
+	} else {
+		file.Close()
+	}
+	if _, err := fs.Open("etc/passwd"); err != confined.ErrOutside {
+		t.Errorf("Expected opening through a link out of the root to fail but got %v", err)
+	}
+	if err := fs.Symlink("../../server2", "world/escape"); err != confined.ErrOutside {
+		t.Errorf("Expected a link out of the root to be refused but got %v", err)
+	}
+	if err := fs.Symlink("../level", "world/inside"); err != nil {
+		t.Error(err)
+	}
+	if !fs.Contains(filepath.Join(root, "world")) || fs.Contains(filepath.Join(dir, "server2")) {
+		t.Error("Unexpected result checking whether paths are inside the root")
+	}
+}
@@ -0,0 +1,93 @@
+// +build linux
+
+/*
+ Copyright 2016 Padduck, LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+ 	http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package confined
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"unsafe"
+)
+
+//openat2 is numbered the same on every architecture
+const sysOpenat2 = 437
+
+const (
+	resolveNoMagiclinks = 0x02
+	resolveBeneath      = 0x08
+)
+
+type openHow struct {
+	flags   uint64
+	mode    uint64
+	resolve uint64
+}
+
+//Opens a resolved path with openat2, which makes the kernel refuse to leave the root while walking it.
+//Kernels without openat2 fall back to refusing a symlink in place of the final component.
+func openBeneath(root string, resolved string, flag int, perm os.FileMode) (*os.File, error) {
+	relative, err := filepath.Rel(root, resolved)
+	if err != nil {
+		return nil, err
+	}
+
+	rootFd, err := syscall.Open(root, syscall.O_DIRECTORY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: root, Err: err}
+	}
+	defer syscall.Close(rootFd)
+
+	name, err := syscall.BytePtrFromString(relative)
+	if err != nil {
+		return nil, err
+	}
+	how := openHow{
+		flags:   uint64(flag | syscall.O_CLOEXEC),
+		resolve: resolveBeneath | resolveNoMagiclinks,
+	}
+	//unlike open, openat2 refuses a mode when no file is created
+	if flag&os.O_CREATE != 0 {
+		how.mode = uint64(syscallMode(perm))
+	}
+	fd, _, errno := syscall.Syscall6(sysOpenat2, uintptr(rootFd), uintptr(unsafe.Pointer(name)), uintptr(unsafe.Pointer(&how)), unsafe.Sizeof(how), 0, 0)
+	switch errno {
+	case 0:
+		return os.NewFile(fd, resolved), nil
+	case syscall.ENOSYS, syscall.EPERM:
+		return os.OpenFile(resolved, flag|syscall.O_NOFOLLOW, perm)
+	case syscall.EXDEV:
+		return nil, ErrOutside
+	default:
+		return nil, &os.PathError{Op: "open", Path: resolved, Err: errno}
+	}
+}
+
+func syscallMode(perm os.FileMode) uint32 {
+	mode := uint32(perm.Perm())
+	if perm&os.ModeSetuid != 0 {
+		mode |= syscall.S_ISUID
+	}
+	if perm&os.ModeSetgid != 0 {
+		mode |= syscall.S_ISGID
+	}
+	if perm&os.ModeSticky != 0 {
+		mode |= syscall.S_ISVTX
+	}
+	return mode
+}
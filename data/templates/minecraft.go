@@ -16,15 +16,24 @@
 
 package templates
 
-const Bungeecord = `{
+const MinecraftBase = `{
   "pufferd": {
+    "schemaVersion": 2,
+    "version": "1.0.0",
     "type": "java",
-    "display": "Bungeecord - Minecraft",
-    "install": {
-      "commands": [
+    "display": "Minecraft",
+    "abstract": true,
+    "fragments": {
+      "minecraft-config": [
         {
-          "files": "http://ci.md-5.net/job/BungeeCord/lastSuccessfulBuild/artifact/bootstrap/target/BungeeCord.jar",
-          "type": "download"
+          "type": "writefile",
+          "text": "eula=${eula}",
+          "target": "eula.txt"
+        },
+        {
+          "type": "writefile",
+          "text": "server-ip=${ip}\nserver-port=${port}\n",
+          "target": "server.properties"
         }
       ]
     },
@@ -35,9 +44,15 @@ const Bungeecord = `{
       "arguments": [
       	"-Xmx${memory}M",
       	"-jar",
-      	"BungeeCord.jar"
+      	"server.jar"
       ],
-      "program": "java"
+      "program": "java",
+      "ready": {
+        "console": "Done \\(.*\\)!"
+      },
+      "query": {
+        "protocol": "minecraft"
+      }
     },
     "environment": {
       "type": "standard"
@@ -45,6 +60,8 @@ const Bungeecord = `{
     "data": {
       "memory": {
       	"value": "1024",
+      	"type": "memory",
+      	"min": 128,
       	"required": true,
       	"desc": "How much memory in MB to allocate to the Java Heap",
       	"display": "Memory (MB)",
@@ -52,6 +69,7 @@ const Bungeecord = `{
       },
       "ip": {
         "value": "0.0.0.0",
+        "type": "string",
         "required": true,
         "desc": "What IP to bind the server to",
         "display": "IP",
@@ -59,18 +77,56 @@ const Bungeecord = `{
       },
       "port": {
         "value": "25565",
+        "type": "port",
         "required": true,
         "desc": "What port to bind the server to",
         "display": "Port",
         "internal": false
+      },
+      "eula": {
+        "value": "false",
+        "type": "boolean",
+        "required": true,
+        "desc": "Do you (or the server owner) agree to the <a href='https://account.mojang.com/documents/minecraft_eula'>Minecraft EULA?</a>",
+        "display": "EULA Agreement (true/false)",
+        "internal": false
       }
     }
   }
 }`
 
+const Bungeecord = `{
+  "pufferd": {
+    "schemaVersion": 2,
+    "version": "1.0.0",
+    "extends": "minecraft-base",
+    "display": "Bungeecord - Minecraft",
+    "install": {
+      "commands": [
+        {
+          "files": "http://ci.md-5.net/job/BungeeCord/lastSuccessfulBuild/artifact/bootstrap/target/BungeeCord.jar",
+          "type": "download"
+        }
+      ]
+    },
+    "run": {
+      "arguments": [
+      	"-Xmx${memory}M",
+      	"-jar",
+      	"BungeeCord.jar"
+      ]
+    },
+    "data": {
+      "eula": null
+    }
+  }
+}`
+
 const Spigot = `{
   "pufferd": {
-    "type": "java",
+    "schemaVersion": 2,
+    "version": "1.0.0",
+    "extends": "minecraft-base",
     "display": "Spigot - Minecraft",
     "install": {
       "commands": [
@@ -85,14 +141,8 @@ const Spigot = `{
           "type": "command"
         },
         {
-          "type": "writefile",
-          "text": "eula=${eula}",
-          "target": "eula.txt"
-        },
-        {
-          "type": "writefile",
-          "text": "server-ip=${ip}\nserver-port=${port}\n",
-          "target": "server.properties"
+          "type": "include",
+          "fragment": "minecraft-config"
         },
         {
           "source": "spigot-*.jar",
@@ -101,55 +151,14 @@ const Spigot = `{
         }
       ]
     },
-    "run": {
-      "stop": "stop",
-      "pre": [],
-      "post": [],
-      "arguments": [
-      	"-Xmx${memory}M",
-      	"-jar",
-      	"server.jar"
-      ],
-      "program": "java"
-    },
-    "environment": {
-      "type": "standard"
-    },
     "data": {
       "version": {
       	"value": "1.11.2",
+      	"type": "string",
       	"required": true,
       	"desc": "Version of Minecraft to install",
       	"display": "Version",
       	"internal": false
-      },
-      "memory": {
-      	"value": "1024",
-      	"required": true,
-      	"desc": "How much memory in MB to allocate to the Java Heap",
-      	"display": "Memory (MB)",
-      	"internal": false
-      },
-      "ip": {
-        "value": "0.0.0.0",
-        "required": true,
-        "desc": "What IP to bind the server to",
-        "display": "IP",
-        "internal": false
-      },
-      "port": {
-        "value": "25565",
-        "required": true,
-        "desc": "What port to bind the server to",
-        "display": "Port",
-        "internal": false
-      },
-      "eula": {
-        "value": "false",
-        "required": true,
-        "desc": "Do you agree to the Minecraft EULA?",
-        "display": "EULA Agreement (true/false)",
-        "internal": false
       }
     }
   }
@@ -157,7 +166,9 @@ const Spigot = `{
 
 const CraftbukkitBySpigot = `{
   "pufferd": {
-    "type": "java",
+    "schemaVersion": 2,
+    "version": "1.0.0",
+    "extends": "spigot",
     "display": "CraftBukkit by Spigot - Minecraft",
     "install": {
       "commands": [
@@ -172,14 +183,8 @@ const CraftbukkitBySpigot = `{
           "type": "command"
         },
         {
-          "type": "writefile",
-          "text": "eula=${eula}",
-          "target": "eula.txt"
-        },
-        {
-          "type": "writefile",
-          "text": "server-ip=${ip}\nserver-port=${port}\n",
-          "target": "server.properties"
+          "type": "include",
+          "fragment": "minecraft-config"
         },
         {
           "source": "craftbukkit-*.jar",
@@ -187,64 +192,15 @@ const CraftbukkitBySpigot = `{
           "type": "move"
         }
       ]
-    },
-    "run": {
-      "stop": "stop",
-      "pre": [],
-      "post": [],
-      "arguments": [
-      	"-Xmx${memory}M",
-      	"-jar",
-      	"server.jar"
-      ],
-      "program": "java"
-    },
-    "environment": {
-      "type": "standard"
-    },
-    "data": {
-      "version": {
-      	"value": "1.11.2",
-      	"required": true,
-      	"desc": "Version of Minecraft to install",
-      	"display": "Version",
-      	"internal": false
-      },
-      "memory": {
-      	"value": "1024",
-      	"required": true,
-      	"desc": "How much memory in MB to allocate to the Java Heap",
-      	"display": "Memory (MB)",
-      	"internal": false
-      },
-      "ip": {
-        "value": "0.0.0.0",
-        "required": true,
-        "desc": "What IP to bind the server to",
-        "display": "IP",
-        "internal": false
-      },
-      "port": {
-        "value": "25565",
-        "required": true,
-        "desc": "What port to bind the server to",
-        "display": "Port",
-        "internal": false
-      },
-      "eula": {
-        "value": "false",
-        "required": true,
-        "desc": "Do you (or the server owner) agree to the <a href='https://account.mojang.com/documents/minecraft_eula'>Minecraft EULA?</a>",
-        "display": "EULA Agreement (true/false)",
-        "internal": false
-      }
     }
   }
 }`
 
 const Vanilla = `{
   "pufferd": {
-    "type": "java",
+    "schemaVersion": 2,
+    "version": "1.0.0",
+    "extends": "minecraft-base",
     "display": "Vanilla - Minecraft",
     "install": {
       "commands": [
@@ -258,66 +214,19 @@ const Vanilla = `{
           "type": "move"
         },
         {
-          "type": "writefile",
-          "text": "server-ip=${ip}\nserver-port=${port}\n",
-          "target": "server.properties"
-        },
-        {
-          "type": "writefile",
-          "text": "eula=${eula}",
-          "target": "eula.txt"
+          "type": "include",
+          "fragment": "minecraft-config"
         }
       ]
     },
-    "run": {
-      "stop": "stop",
-      "pre": [],
-      "post": [],
-      "arguments": [
-      	"-Xmx${memory}M",
-      	"-jar",
-      	"server.jar"
-      ],
-      "program": "java"
-    },
-    "environment": {
-      "type": "standard"
-    },
     "data": {
       "version": {
       	"value": "1.11.2",
+      	"type": "string",
       	"required": true,
       	"desc": "Version of Minecraft to install",
       	"display": "Version",
       	"internal": false
-      },
-      "memory": {
-      	"value": "1024",
-      	"required": true,
-      	"desc": "How much memory in MB to allocate to the Java Heap",
-      	"display": "Memory (MB)",
-      	"internal": false
-      },
-      "ip": {
-        "value": "0.0.0.0",
-        "required": true,
-        "desc": "What IP to bind the server to",
-        "display": "IP",
-        "internal": false
-      },
-      "port": {
-        "value": "25565",
-        "required": true,
-        "desc": "What port to bind the server to",
-        "display": "Port",
-        "internal": false
-      },
-      "eula": {
-        "value": "false",
-        "required": true,
-        "desc": "Do you (or the server owner) agree to the <a href='https://account.mojang.com/documents/minecraft_eula'>Minecraft EULA?</a>",
-        "display": "EULA Agreement (true/false)",
-        "internal": false
       }
     }
   }
@@ -325,14 +234,12 @@ const Vanilla = `{
 
 const Forge = `{
   "pufferd": {
-    "type": "java",
+    "schemaVersion": 2,
+    "version": "1.0.0",
+    "extends": "minecraft-base",
     "display": "MinecraftForge - Minecraft",
-    "install": {
-      "commands": [
-        {
-          "type": "download",
-          "files": "http://files.minecraftforge.net/maven/net/minecraftforge/forge/${version}/forge-${version}-installer.jar"
-        },
+    "fragments": {
+      "forge-server": [
         {
           "source": "forge-*.jar",
           "target": "installer.jar",
@@ -345,14 +252,8 @@ const Forge = `{
           "type": "command"
         },
         {
-          "type": "writefile",
-          "text": "server-ip=${ip}\nserver-port=${port}\n",
-          "target": "server.properties"
-        },
-        {
-          "type": "writefile",
-          "text": "eula=${eula}",
-          "target": "eula.txt"
+          "type": "include",
+          "fragment": "minecraft-config"
         },
         {
           "source": "forge-*-universal.jar",
@@ -361,51 +262,22 @@ const Forge = `{
         }
       ]
     },
-    "run": {
-      "stop": "stop",
-      "pre": [],
-      "post": [],
-      "arguments": [
-      	"-Xmx${memory}M",
-      	"-jar",
-      	"server.jar"
-      ],
-      "program": "java"
-    },
-    "environment": {
-      "type": "standard"
+    "install": {
+      "commands": [
+        {
+          "type": "download",
+          "files": "http://files.minecraftforge.net/maven/net/minecraftforge/forge/${version}/forge-${version}-installer.jar"
+        },
+        {
+          "type": "include",
+          "fragment": "forge-server"
+        }
+      ]
     },
     "data": {
-      "memory": {
-      	"value": "1024",
-      	"required": true,
-      	"desc": "How much memory in MB to allocate to the Java Heap",
-      	"display": "Memory (MB)",
-      	"internal": false
-      },
-      "ip": {
-        "value": "0.0.0.0",
-        "required": true,
-        "desc": "What IP to bind the server to",
-        "display": "IP",
-        "internal": false
-      },
-      "port": {
-        "value": "25565",
-        "required": true,
-        "desc": "What port to bind the server to",
-        "display": "Port",
-        "internal": false
-      },
-      "eula": {
-        "value": "false",
-        "required": true,
-        "desc": "Do you (or the server owner) agree to the <a href='https://account.mojang.com/documents/minecraft_eula'>Minecraft EULA?</a>",
-        "display": "EULA Agreement (true/false)",
-        "internal": false
-      },
       "version": {
       	"value": "1.10.2 - 12.18.3.2202",
+      	"type": "string",
       	"required": true,
       	"desc": "Version of Forge to install (may be located <a href='http://files.minecraftforge.net/#Downloads'>here</a>",
       	"display": "Version",
@@ -417,7 +289,9 @@ const Forge = `{
 
 const Sponge = `{
   "pufferd": {
-    "type": "java",
+    "schemaVersion": 2,
+    "version": "1.0.0",
+    "extends": "forge",
     "display": "SpongeForge - Minecraft",
     "install": {
       "commands": [
@@ -428,11 +302,6 @@ const Sponge = `{
           	"http://files.minecraftforge.net/maven/org/spongepowered/spongeforge/${spongeversion}/spongeforge-${spongeversion}.jar"
           ]
         },
-        {
-          "source": "forge-*.jar",
-          "target": "installer.jar",
-          "type": "move"
-        },
         {
           "target": "mods",
           "type": "mkdir"
@@ -443,73 +312,16 @@ const Sponge = `{
           "type": "move"
         },
         {
-          "commands": [
-            "java -jar installer.jar --installServer"
-          ],
-          "type": "command"
-        },
-        {
-          "type": "writefile",
-          "text": "eula=${eula}",
-          "target": "eula.txt"
-        },
-        {
-          "type": "writefile",
-          "text": "server-ip=${ip}\nserver-port=${port}\n",
-          "target": "server.properties"
-        },
-        {
-          "source": "forge-*-universal.jar",
-          "target": "server.jar",
-          "type": "move"
+          "type": "include",
+          "fragment": "forge-server"
         }
       ]
     },
-    "run": {
-      "stop": "stop",
-      "pre": [],
-      "post": [],
-      "arguments": [
-      	"-Xmx${memory}M",
-      	"-jar",
-      	"server.jar"
-      ],
-      "program": "java"
-    },
-    "environment": {
-      "type": "standard"
-    },
     "data": {
-      "memory": {
-      	"value": "1024",
-      	"required": true,
-      	"desc": "How much memory in MB to allocate to the Java Heap",
-      	"display": "Memory (MB)",
-      	"internal": false
-      },
-      "ip": {
-        "value": "0.0.0.0",
-        "required": true,
-        "desc": "What IP to bind the server to",
-        "display": "IP",
-        "internal": false
-      },
-      "port": {
-        "value": "25565",
-        "required": true,
-        "desc": "What port to bind the server to",
-        "display": "Port",
-        "internal": false
-      },
-      "eula": {
-        "value": "false",
-        "required": true,
-        "desc": "Do you (or the server owner) agree to the <a href='https://account.mojang.com/documents/minecraft_eula'>Minecraft EULA?</a>",
-        "display": "EULA Agreement (true/false)",
-        "internal": false
-      },
+      "version": null,
       "spongeversion": {
       	"value": "1.10.2-2202-5.1.0-BETA-2014",
+      	"type": "string",
       	"required": true,
       	"desc": "Version of Sponge to install (may be located <a href='https://www.spongepowered.org/downloads/spongeforge/stable/'>here</a>",
       	"display": "Sponge Version",
@@ -517,6 +329,7 @@ const Sponge = `{
       },
       "forgeversion": {
       	"value": "1.10.2 - 12.18.3.2202",
+      	"type": "string",
       	"required": true,
       	"desc": "Version of Forge to install (use version specified by Sponge)",
       	"display": "Forge Version",
@@ -524,4 +337,4 @@ const Sponge = `{
       }
     }
   }
-}`
+}`
\ No newline at end of file
@@ -0,0 +1,482 @@
+/*
+ Copyright 2016 Padduck, LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+ 	http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package templates
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pufferpanel/pufferd/config"
+	"github.com/pufferpanel/pufferd/utils"
+)
+
+const (
+	RepositoryHttp  = "http"
+	RepositoryGit   = "git"
+	RepositoryLocal = "local"
+
+	//Source recorded for the templates shipped with pufferd.
+	SourceBuiltin = "builtin"
+
+	manifestFile = ".manifest.json"
+	indexFile    = "index.json"
+)
+
+//A place templates are synced from, configured as a list under templaterepos.
+//Http repositories point at an index.json, git repositories are checked out into the data folder
+//and local repositories are read from a directory. Path selects a subdirectory of a git checkout.
+type Repository struct {
+	Name   string `json:"name"`
+	Type   string `json:"type"`
+	Url    string `json:"url,omitempty"`
+	Branch string `json:"branch,omitempty"`
+	Path   string `json:"path,omitempty"`
+}
+
+//Lists the templates a repository provides. Paths are relative to the index.
+//Directory repositories without an index.json provide every .json file they contain.
+type Index struct {
+	Templates map[string]IndexEntry `json:"templates"`
+}
+
+type IndexEntry struct {
+	Version string `json:"version"`
+	Path    string `json:"path"`
+}
+
+//Records where an installed template came from.
+type InstalledTemplate struct {
+	Source  string    `json:"source"`
+	Version string    `json:"version"`
+	Synced  time.Time `json:"synced"`
+}
+
+type SyncResult struct {
+	Installed []SyncedTemplate `json:"installed"`
+	UpToDate  []SyncedTemplate `json:"upToDate"`
+	Failed    []SyncFailure    `json:"failed"`
+}
+
+type SyncedTemplate struct {
+	Name    string `json:"name"`
+	Source  string `json:"source"`
+	Version string `json:"version"`
+}
+
+type SyncFailure struct {
+	Name    string                 `json:"name,omitempty"`
+	Source  string                 `json:"source"`
+	Message string                 `json:"message"`
+	Errors  utils.ValidationErrors `json:"errors,omitempty"`
+}
+
+type repositorySource interface {
+	index() (map[string]IndexEntry, error)
+	read(entry IndexEntry) ([]byte, error)
+}
+
+type fetchedTemplate struct {
+	source  string
+	version string
+	data    []byte
+}
+
+var (
+	syncLock   sync.Mutex
+	httpClient = &http.Client{Timeout: 30 * time.Second}
+)
+
+//Returns the template repositories configured under templaterepos.
+func GetRepositories() ([]Repository, error) {
+	repos := make([]Repository, 0)
+	err := config.GetObject("templaterepos", &repos)
+	return repos, err
+}
+
+//Returns the source and version of every template installed by a sync or copied from pufferd.
+func GetInstalled() (map[string]InstalledTemplate, error) {
+	installed := make(map[string]InstalledTemplate)
+	data, err := ioutil.ReadFile(utils.JoinPath(Folder, manifestFile))
+	if os.IsNotExist(err) {
+		return installed, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(data, &installed)
+	return installed, err
+}
+
+func saveInstalled(installed map[string]InstalledTemplate) error {
+	data, err := json.MarshalIndent(installed, "", "  ")
+	if err != nil {
+		return err
+	}
+	return utils.WriteFileAtomic(utils.JoinPath(Folder, manifestFile), data, 0664)
+}
+
+//Syncs templates from the repositories in the config.
+func Sync() SyncResult {
+	repos, err := GetRepositories()
+	if err != nil {
+		return SyncResult{
+			Installed: []SyncedTemplate{},
+			UpToDate:  []SyncedTemplate{},
+			Failed:    []SyncFailure{{Source: "config", Message: "Invalid templaterepos: " + err.Error()}},
+		}
+	}
+	return SyncRepositories(repos)
+}
+
+//Fetches templates from the given repositories and installs those which are new or whose version changed.
+//Repositories are read in order, so the first repository providing a template wins.
+//A template is only installed once it, and everything it extends, passes validation.
+func SyncRepositories(repos []Repository) SyncResult {
+	syncLock.Lock()
+	defer syncLock.Unlock()
+
+	result := SyncResult{Installed: []SyncedTemplate{}, UpToDate: []SyncedTemplate{}, Failed: []SyncFailure{}}
+	fail := func(name, source, message string) {
+		result.Failed = append(result.Failed, SyncFailure{Name: name, Source: source, Message: message})
+	}
+
+	installed, err := GetInstalled()
+	if err != nil {
+		fail("", manifestFile, "Cannot read installed templates: "+err.Error())
+		return result
+	}
+
+	staged := make(map[string]*fetchedTemplate)
+	providers := make(map[string]string)
+	for _, repo := range repos {
+		source, err := openRepository(repo)
+		if err != nil {
+			fail("", repo.Name, err.Error())
+			continue
+		}
+		index, err := source.index()
+		if err != nil {
+			fail("", repo.Name, "Cannot read index: "+err.Error())
+			continue
+		}
+
+		names := make([]string, 0, len(index))
+		for name := range index {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			entry := index[name]
+			if !validName(name) {
+				fail(name, repo.Name, "Invalid template name")
+				continue
+			}
+			if owner, exists := providers[name]; exists {
+				fail(name, repo.Name, "Already provided by repository "+owner)
+				continue
+			}
+			providers[name] = repo.Name
+
+			current, exists := installed[name]
+			upToDate := func(version string) bool {
+				return exists && current.Source == repo.Name && current.Version == version && templateExists(name)
+			}
+			if entry.Version != "" && upToDate(entry.Version) {
+				result.UpToDate = append(result.UpToDate, SyncedTemplate{Name: name, Source: repo.Name, Version: entry.Version})
+				continue
+			}
+
+			data, err := source.read(entry)
+			if err != nil {
+				fail(name, repo.Name, "Cannot read template: "+err.Error())
+				continue
+			}
+			version, err := templateVersion(data)
+			if err != nil {
+				fail(name, repo.Name, "Invalid JSON: "+err.Error())
+				continue
+			}
+			if version == "" {
+				fail(name, repo.Name, "Template does not declare a version")
+				continue
+			}
+			if entry.Version != "" && entry.Version != version {
+				fail(name, repo.Name, "Index lists version "+entry.Version+" but the template declares "+version)
+				continue
+			}
+			if upToDate(version) {
+				result.UpToDate = append(result.UpToDate, SyncedTemplate{Name: name, Source: repo.Name, Version: version})
+				continue
+			}
+			staged[name] = &fetchedTemplate{source: repo.Name, version: version, data: data}
+		}
+	}
+
+	result.Failed = append(result.Failed, validateStaged(staged)...)
+
+	names := make([]string, 0, len(staged))
+	for name := range staged {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		template := staged[name]
+		err := installTemplate(name, template.data)
+		if err != nil {
+			fail(name, template.source, "Cannot install template: "+err.Error())
+			continue
+		}
+		installed[name] = InstalledTemplate{Source: template.source, Version: template.version, Synced: time.Now()}
+		result.Installed = append(result.Installed, SyncedTemplate{Name: name, Source: template.source, Version: template.version})
+	}
+
+	if len(result.Installed) > 0 {
+		if err := saveInstalled(installed); err != nil {
+			fail("", manifestFile, "Cannot record installed templates: "+err.Error())
+		}
+	}
+	return result
+}
+
+//Validates the staged templates against each other and the installed templates, removing any which fail.
+//Removing a template can break one extending it, so this repeats until every remaining template passes.
+func validateStaged(staged map[string]*fetchedTemplate) []SyncFailure {
+	failures := make([]SyncFailure, 0)
+	lookup := func(name string) ([]byte, error) {
+		if template, ok := staged[name]; ok {
+			return template.data, nil
+		}
+		return readFromFolder(name)
+	}
+
+	for rejected := true; rejected; {
+		rejected = false
+		names := make([]string, 0, len(staged))
+		for name := range staged {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			var errs utils.ValidationErrors
+			template, err := resolve(name, lookup)
+			if err == nil {
+				errs = Validate(template)
+			} else if validationErrs, ok := err.(utils.ValidationErrors); ok {
+				errs = validationErrs
+			} else {
+				errs = utils.ValidationErrors{{Field: "$", Message: err.Error()}}
+			}
+			if len(errs) > 0 {
+				failures = append(failures, SyncFailure{Name: name, Source: staged[name].source, Message: "Template is invalid", Errors: errs})
+				delete(staged, name)
+				rejected = true
+			}
+		}
+	}
+	return failures
+}
+
+func openRepository(repo Repository) (repositorySource, error) {
+	if !validName(repo.Name) {
+		return nil, errors.New("Invalid repository name " + repo.Name)
+	}
+
+	switch repo.Type {
+	case RepositoryHttp:
+		if repo.Url == "" {
+			return nil, errors.New("Repository url is not set")
+		}
+		return &httpRepository{url: repo.Url}, nil
+	case RepositoryGit:
+		if repo.Url == "" {
+			return nil, errors.New("Repository url is not set")
+		}
+		dir := utils.JoinPath(config.GetOrDefault("datafolder", "data"), "templaterepos", repo.Name)
+		err := checkoutRepository(repo, dir)
+		if err != nil {
+			return nil, err
+		}
+		return &localRepository{dir: filepath.Join(dir, filepath.FromSlash(repo.Path))}, nil
+	case RepositoryLocal:
+		if repo.Path == "" {
+			return nil, errors.New("Repository path is not set")
+		}
+		return &localRepository{dir: repo.Path}, nil
+	}
+	return nil, errors.New("Unknown repository type " + repo.Type)
+}
+
+type httpRepository struct {
+	url string
+}
+
+func (r *httpRepository) index() (map[string]IndexEntry, error) {
+	data, err := httpGet(r.url)
+	if err != nil {
+		return nil, err
+	}
+	var index Index
+	err = json.Unmarshal(data, &index)
+	if err != nil {
+		return nil, err
+	}
+	return withDefaultPaths(index.Templates), nil
+}
+
+func (r *httpRepository) read(entry IndexEntry) ([]byte, error) {
+	base, err := url.Parse(r.url)
+	if err != nil {
+		return nil, err
+	}
+	reference, err := url.Parse(entry.Path)
+	if err != nil {
+		return nil, err
+	}
+	return httpGet(base.ResolveReference(reference).String())
+}
+
+func httpGet(target string) ([]byte, error) {
+	response, err := httpClient.Get(target)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, errors.New("GET " + target + " returned " + response.Status)
+	}
+	return ioutil.ReadAll(response.Body)
+}
+
+type localRepository struct {
+	dir string
+}
+
+func (r *localRepository) index() (map[string]IndexEntry, error) {
+	data, err := ioutil.ReadFile(filepath.Join(r.dir, indexFile))
+	if err == nil {
+		var index Index
+		err = json.Unmarshal(data, &index)
+		if err != nil {
+			return nil, err
+		}
+		return withDefaultPaths(index.Templates), nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	files, err := ioutil.ReadDir(r.dir)
+	if err != nil {
+		return nil, err
+	}
+	entries := make(map[string]IndexEntry)
+	for _, file := range files {
+		if file.IsDir() || filepath.Ext(file.Name()) != ".json" || strings.HasPrefix(file.Name(), ".") {
+			continue
+		}
+		entry := IndexEntry{Path: file.Name()}
+		if data, err := ioutil.ReadFile(filepath.Join(r.dir, file.Name())); err == nil {
+			entry.Version, _ = templateVersion(data)
+		}
+		entries[strings.TrimSuffix(file.Name(), ".json")] = entry
+	}
+	return entries, nil
+}
+
+func (r *localRepository) read(entry IndexEntry) ([]byte, error) {
+	path := filepath.Join(r.dir, filepath.FromSlash(entry.Path))
+	relative, err := filepath.Rel(r.dir, path)
+	if err != nil || relative == ".." || strings.HasPrefix(relative, ".."+string(filepath.Separator)) {
+		return nil, errors.New("Template path " + entry.Path + " is outside the repository")
+	}
+	return ioutil.ReadFile(path)
+}
+
+//Clones the repository on first use and fetches the configured branch afterwards.
+func checkoutRepository(repo Repository, dir string) error {
+	if _, err := os.Stat(filepath.Join(dir, ".git")); os.IsNotExist(err) {
+		err = os.MkdirAll(filepath.Dir(dir), 0755)
+		if err != nil {
+			return err
+		}
+		args := []string{"clone", "--depth", "1"}
+		if repo.Branch != "" {
+			args = append(args, "--branch", repo.Branch)
+		}
+		return runGit("", append(args, "--", repo.Url, dir)...)
+	}
+
+	branch := repo.Branch
+	if branch == "" {
+		branch = "HEAD"
+	}
+	if err := runGit(dir, "remote", "set-url", "origin", repo.Url); err != nil {
+		return err
+	}
+	if err := runGit(dir, "fetch", "--depth", "1", "origin", branch); err != nil {
+		return err
+	}
+	return runGit(dir, "reset", "--hard", "FETCH_HEAD")
+}
+
+func runGit(dir string, args ...string) error {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return errors.New("git " + args[0] + " failed: " + strings.TrimSpace(string(output)) + " (" + err.Error() + ")")
+	}
+	return nil
+}
+
+func withDefaultPaths(entries map[string]IndexEntry) map[string]IndexEntry {
+	for name, entry := range entries {
+		if entry.Path == "" {
+			entry.Path = name + ".json"
+			entries[name] = entry
+		}
+	}
+	return entries
+}
+
+func templateVersion(data []byte) (string, error) {
+	var template struct {
+		Pufferd struct {
+			Version string `json:"version"`
+		} `json:"pufferd"`
+	}
+	err := json.Unmarshal(data, &template)
+	return template.Pufferd.Version, err
+}
+
+func templateExists(name string) bool {
+	_, err := os.Stat(utils.JoinPath(Folder, name+".json"))
+	return err == nil
+}
@@ -0,0 +1,152 @@
+/*
+ Copyright 2016 Padduck, LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+ 	http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package templates_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pufferpanel/pufferd/data/templates"
+)
+
+const baseTemplate = `{"pufferd": {"schemaVersion": 2, "version": "1.0.0", "type": "standard", "abstract": true,
+  "run": {"program": "./server", "arguments": ["--port", "${port}"]},
+  "data": {"port": {"value": "25565", "type": "port"}}}}`
+
+const childTemplate = `{"pufferd": {"schemaVersion": 2, "version": "%s", "extends": "base", "display": "Child",
+  "install": {"commands": [{"type": "mkdir", "target": "world"}]}}}`
+
+const brokenTemplate = `{"pufferd": {"schemaVersion": 2, "version": "1.0.0", "type": "standard",
+  "install": {"commands": [{"type": "move", "source": "a"}]}}}`
+
+func setupFolder(t *testing.T) func() {
+	dir, err := ioutil.TempDir("", "pufferd-templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	templates.Folder = dir
+	return func() {
+		os.RemoveAll(dir)
+	}
+}
+
+func newRepositoryServer(files map[string]string, requests map[string]int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests[r.URL.Path]++
+		data, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(data))
+	}))
+}
+
+func TestSyncRepositories_Http(t *testing.T) {
+	defer setupFolder(t)()
+
+	files := map[string]string{
+		"/repo/index.json": `{"templates": {
+		  "base": {"version": "1.0.0"},
+		  "child": {"version": "1.0.0", "path": "games/child.json"},
+		  "broken": {"version": "1.0.0"}}}`,
+		"/repo/base.json":        baseTemplate,
+		"/repo/games/child.json": fmt.Sprintf(childTemplate, "1.0.0"),
+		"/repo/broken.json":      brokenTemplate,
+	}
+	requests := make(map[string]int)
+	server := newRepositoryServer(files, requests)
+	defer server.Close()
+	repos := []templates.Repository{{Name: "remote", Type: templates.RepositoryHttp, Url: server.URL + "/repo/index.json"}}
+
+	result := templates.SyncRepositories(repos)
+	if len(result.Installed) != 2 || result.Installed[0].Name != "base" || result.Installed[1].Name != "child" {
+		t.Fatalf("expected base and child to be installed, got %+v", result.Installed)
+	}
+	if len(result.Failed) != 1 || result.Failed[0].Name != "broken" || len(result.Failed[0].Errors) == 0 {
+		t.Fatalf("expected broken to fail validation, got %+v", result.Failed)
+	}
+	if _, err := os.Stat(filepath.Join(templates.Folder, "broken.json")); !os.IsNotExist(err) {
+		t.Error("invalid template was installed")
+	}
+	if _, err := templates.Load("child"); err != nil {
+		t.Errorf("installed template does not load: %s", err)
+	}
+
+	installed, err := templates.GetInstalled()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if installed["child"].Source != "remote" || installed["child"].Version != "1.0.0" {
+		t.Errorf("unexpected manifest entry %+v", installed["child"])
+	}
+
+	result = templates.SyncRepositories(repos)
+	if len(result.Installed) != 0 || len(result.UpToDate) != 2 {
+		t.Errorf("expected templates to be up to date, got %+v", result)
+	}
+	if requests["/repo/games/child.json"] != 1 {
+		t.Errorf("up to date template was fetched again")
+	}
+
+	files["/repo/index.json"] = `{"templates": {"base": {"version": "1.0.0"}, "child": {"version": "1.1.0", "path": "games/child.json"}}}`
+	files["/repo/games/child.json"] = fmt.Sprintf(childTemplate, "1.1.0")
+	result = templates.SyncRepositories(repos)
+	if len(result.Installed) != 1 || result.Installed[0].Version != "1.1.0" {
+		t.Errorf("expected child 1.1.0 to be installed, got %+v", result)
+	}
+}
+
+func TestSyncRepositories_Local(t *testing.T) {
+	defer setupFolder(t)()
+
+	dir, err := ioutil.TempDir("", "pufferd-repository")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "base.json"), []byte(baseTemplate), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "unversioned.json"), []byte(`{"pufferd": {"schemaVersion": 2}}`), 0644)
+
+	files := map[string]string{
+		"/index.json": `{"templates": {"base": {"version": "9.0.0"}}}`,
+		"/base.json":  baseTemplate,
+	}
+	server := newRepositoryServer(files, make(map[string]int))
+	defer server.Close()
+
+	result := templates.SyncRepositories([]templates.Repository{
+		{Name: "local", Type: templates.RepositoryLocal, Path: dir},
+		{Name: "remote", Type: templates.RepositoryHttp, Url: server.URL + "/index.json"},
+	})
+
+	if len(result.Installed) != 1 || result.Installed[0].Name != "base" || result.Installed[0].Source != "local" {
+		t.Errorf("expected base from the local repository, got %+v", result.Installed)
+	}
+	failed := make(map[string]string)
+	for _, v := range result.Failed {
+		failed[v.Name] = v.Source
+	}
+	if failed["unversioned"] != "local" || failed["base"] != "remote" {
+		t.Errorf("expected the unversioned template and the duplicate to fail, got %+v", result.Failed)
+	}
+}
@@ -0,0 +1,178 @@
+/*
+ Copyright 2016 Padduck, LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+ 	http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package templates
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/pufferpanel/pufferd/utils"
+)
+
+//Reads the raw contents of a template by name.
+type templateSource func(name string) ([]byte, error)
+
+//Resolves a template against the templates it extends and expands any fragments its install section includes.
+//
+//Templates are merged parent first. Scalar fields such as type and display are replaced by the child.
+//The run, environment and fragments sections are merged key by key, so a child replaces individual
+//keys (including whole argument lists) while keeping the rest. Variables in data are merged field by field,
+//so a child can change just the default value of a variable it inherits. An install section in the child
+//replaces the parent's install commands entirely. Setting any key to null removes the inherited value.
+func resolve(name string, source templateSource) (map[string]interface{}, error) {
+	template, err := resolveChain(name, source, []string{})
+	if err != nil {
+		return nil, err
+	}
+
+	pufferd := template["pufferd"].(map[string]interface{})
+	fragments, _ := pufferd["fragments"].(map[string]interface{})
+	if install, ok := pufferd["install"].(map[string]interface{}); ok {
+		if commands, ok := install["commands"].([]interface{}); ok {
+			expanded, err := expandFragments(commands, fragments, "pufferd.install.commands", []string{})
+			if err != nil {
+				return nil, err
+			}
+			install["commands"] = expanded
+		}
+	}
+	delete(pufferd, "extends")
+	delete(pufferd, "fragments")
+	return template, nil
+}
+
+func resolveChain(name string, source templateSource, chain []string) (map[string]interface{}, error) {
+	if utils.ContainsValue(chain, name) {
+		return nil, utils.ValidationErrors{{Field: "pufferd.extends", Message: "Template inheritance cycle: " + strings.Join(append(chain, name), " -> ")}}
+	}
+
+	data, err := source(name)
+	if err != nil {
+		if len(chain) > 0 {
+			return nil, utils.ValidationErrors{{Field: "pufferd.extends", Message: "Cannot read template " + name + ": " + err.Error()}}
+		}
+		return nil, err
+	}
+
+	var template map[string]interface{}
+	err = json.Unmarshal(data, &template)
+	if err != nil {
+		return nil, err
+	}
+	if errs := Upgrade(template); len(errs) > 0 {
+		return nil, errs
+	}
+
+	pufferd := template["pufferd"].(map[string]interface{})
+	parentValue, hasParent := pufferd["extends"]
+	if !hasParent || parentValue == nil {
+		return template, nil
+	}
+
+	parentName, ok := parentValue.(string)
+	if !ok || !validName(parentName) {
+		return nil, utils.ValidationErrors{{Field: "pufferd.extends", Message: "Invalid template name " + utils.DescribeJsonType(parentValue)}}
+	}
+
+	parent, err := resolveChain(parentName, source, append(chain, name))
+	if err != nil {
+		return nil, err
+	}
+	template["pufferd"] = mergeTemplates(parent["pufferd"].(map[string]interface{}), pufferd)
+	return template, nil
+}
+
+func mergeTemplates(parent, child map[string]interface{}) map[string]interface{} {
+	result := make(map[string]interface{}, len(parent))
+	for k, v := range parent {
+		result[k] = v
+	}
+	delete(result, "abstract")
+
+	for key, value := range child {
+		switch key {
+		case "extends":
+			continue
+		case "run", "environment", "fragments":
+			result[key] = mergeSection(parent[key], value, false)
+		case "data":
+			result[key] = mergeSection(parent[key], value, true)
+		default:
+			result[key] = value
+		}
+		if value == nil {
+			delete(result, key)
+		}
+	}
+	return result
+}
+
+func mergeSection(parent, child interface{}, mergeEntries bool) interface{} {
+	parentMap, parentOk := parent.(map[string]interface{})
+	childMap, childOk := child.(map[string]interface{})
+	if !parentOk || !childOk {
+		return child
+	}
+
+	result := make(map[string]interface{}, len(parentMap))
+	for k, v := range parentMap {
+		result[k] = v
+	}
+	for k, v := range childMap {
+		if v == nil {
+			delete(result, k)
+		} else if mergeEntries {
+			result[k] = mergeSection(parentMap[k], v, false)
+		} else {
+			result[k] = v
+		}
+	}
+	return result
+}
+
+func expandFragments(commands []interface{}, fragments map[string]interface{}, path string, stack []string) ([]interface{}, error) {
+	result := make([]interface{}, 0, len(commands))
+	for i, element := range commands {
+		operation, ok := element.(map[string]interface{})
+		if !ok || operation["type"] != "include" {
+			result = append(result, element)
+			continue
+		}
+
+		elementPath := path + "[" + strconv.Itoa(i) + "].fragment"
+		name, _ := operation["fragment"].(string)
+		if utils.ContainsValue(stack, name) {
+			return nil, utils.ValidationErrors{{Field: elementPath, Message: "Fragment cycle: " + strings.Join(append(stack, name), " -> ")}}
+		}
+		fragment, ok := fragments[name].([]interface{})
+		if !ok {
+			return nil, utils.ValidationErrors{{Field: elementPath, Message: "Unknown fragment " + name}}
+		}
+
+		expanded, err := expandFragments(fragment, fragments, "pufferd.fragments."+name, append(stack, name))
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, expanded...)
+	}
+	return result, nil
+}
+
+func validName(name string) bool {
+	return name != "" && !strings.ContainsAny(name, "/\\") && !strings.HasPrefix(name, ".")
+}
@@ -0,0 +1,385 @@
+/*
+ Copyright 2016 Padduck, LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+ 	http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package templates
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pufferpanel/pufferd/utils"
+)
+
+//The version of the template and server format this build reads and writes.
+const SchemaVersion = 2
+
+//JSON schema describing the current template and server format.
+const Schema = `{
+  "$schema": "http://json-schema.org/draft-04/schema#",
+  "id": "https://pufferpanel.com/schemas/pufferd/2.json",
+  "title": "pufferd template",
+  "type": "object",
+  "required": ["pufferd"],
+  "properties": {
+    "pufferd": {
+      "type": "object",
+      "required": ["schemaVersion"],
+      "properties": {
+        "schemaVersion": {"type": "integer", "minimum": 1, "maximum": 2},
+        "version": {"type": "string", "minLength": 1},
+        "type": {"type": "string"},
+        "display": {"type": "string"},
+        "extends": {"type": "string", "minLength": 1},
+        "abstract": {"type": "boolean"},
+        "template": {
+          "type": "object",
+          "required": ["name"],
+          "properties": {
+            "name": {"type": "string", "minLength": 1},
+            "version": {"type": "string"}
+          }
+        },
+        "fragments": {
+          "type": "object",
+          "additionalProperties": {
+            "type": "array",
+            "items": {"$ref": "#/definitions/operation"}
+          }
+        },
+        "install": {"$ref": "#/definitions/install"},
+        "run": {"$ref": "#/definitions/run"},
+        "environment": {"$ref": "#/definitions/environment"},
+        "data": {
+          "type": "object",
+          "additionalProperties": {"$ref": "#/definitions/variable"}
+        },
+        "backup": {"$ref": "#/definitions/backup"},
+        "quota": {"$ref": "#/definitions/quota"},
+        "schedules": {
+          "type": "object",
+          "additionalProperties": {"$ref": "#/definitions/schedule"}
+        },
+        "triggers": {
+          "type": "array",
+          "items": {"$ref": "#/definitions/trigger"}
+        }
+      }
+    }
+  },
+  "definitions": {
+    "stringList": {
+      "type": ["string", "array"],
+      "items": {"type": "string"}
+    },
+    "install": {
+      "type": "object",
+      "properties": {
+        "commands": {
+          "type": "array",
+          "items": {"$ref": "#/definitions/operation"}
+        }
+      }
+    },
+    "operation": {
+      "type": "object",
+      "required": ["type"],
+      "properties": {
+        "type": {"enum": ["command", "download", "move", "mkdir", "writefile", "include"]},
+        "fragment": {"type": "string", "minLength": 1},
+        "commands": {"$ref": "#/definitions/stringList"},
+        "files": {"$ref": "#/definitions/stringList"},
+        "source": {"type": "string", "minLength": 1},
+        "target": {"type": "string", "minLength": 1},
+        "text": {"type": "string"}
+      }
+    },
+    "run": {
+      "type": "object",
+      "properties": {
+        "stop": {"type": "string"},
+        "pre": {"type": "array", "items": {"type": "string"}},
+        "post": {"type": "array", "items": {"type": "string"}},
+        "program": {"type": "string"},
+        "arguments": {"type": "array", "items": {"type": "string"}},
+        "enabled": {"type": "boolean"},
+        "autostart": {"type": "boolean"},
+        "ready": {
+          "type": "object",
+          "properties": {
+            "console": {"type": "string", "minLength": 1},
+            "port": {"type": "string", "minLength": 1},
+            "protocol": {"enum": ["tcp", "udp"]},
+            "timeout": {"type": "integer", "minimum": 0}
+          }
+        },
+        "query": {
+          "type": "object",
+          "required": ["protocol"],
+          "properties": {
+            "protocol": {"enum": ["minecraft", "minecraft-query", "source"]},
+            "port": {"type": "string", "minLength": 1}
+          }
+        },
+        "commandTransport": {"enum": ["stdin", "rcon", "both"]},
+        "rcon": {
+          "type": "object",
+          "required": ["protocol", "port", "password"],
+          "properties": {
+            "protocol": {"enum": ["source", "minecraft"]},
+            "port": {"type": "string", "minLength": 1},
+            "password": {"type": "string"}
+          }
+        }
+      }
+    },
+    "environment": {
+      "type": "object",
+      "properties": {
+        "type": {"enum": ["standard", "tty"]},
+        "root": {"type": "string"}
+      }
+    },
+    "backup": {
+      "type": "object",
+      "properties": {
+        "format": {"enum": ["archive", "snapshot"]},
+        "include": {"type": "array", "items": {"type": "string"}},
+        "exclude": {"type": "array", "items": {"type": "string"}},
+        "pre": {"type": "array", "items": {"type": "string"}},
+        "post": {"type": "array", "items": {"type": "string"}},
+        "delay": {"type": "integer", "minimum": 0},
+        "retention": {
+          "type": "object",
+          "properties": {
+            "count": {"type": "integer", "minimum": 0},
+            "days": {"type": "integer", "minimum": 0}
+          }
+        }
+      }
+    },
+    "quota": {
+      "type": "object",
+      "required": ["limit"],
+      "properties": {
+        "limit": {"type": "integer", "minimum": 1},
+        "action": {"enum": ["warn", "stop", "block"]}
+      }
+    },
+    "schedule": {
+      "type": "object",
+      "required": ["cron", "actions"],
+      "properties": {
+        "cron": {"type": "string", "minLength": 1},
+        "enabled": {"type": "boolean"},
+        "skipOffline": {"type": "boolean"},
+        "actions": {
+          "type": "array",
+          "items": {
+            "type": "object",
+            "required": ["type"],
+            "properties": {
+              "type": {"enum": ["start", "stop", "restart", "command", "install", "backup"]},
+              "command": {"type": "string"},
+              "delay": {"type": "integer", "minimum": 0}
+            }
+          }
+        }
+      }
+    },
+    "trigger": {
+      "type": "object",
+      "required": ["pattern", "action"],
+      "properties": {
+        "pattern": {"type": "string", "minLength": 1},
+        "action": {"enum": ["event", "state", "command", "kill", "restart"]},
+        "event": {"type": "string", "minLength": 1},
+        "state": {"type": "string", "minLength": 1},
+        "command": {"type": "string", "minLength": 1},
+        "cooldown": {"type": "integer", "minimum": 0}
+      }
+    },
+    "variable": {
+      "type": "object",
+      "required": ["value"],
+      "properties": {
+        "value": {"type": ["string", "number", "boolean", "null"]},
+        "required": {"type": "boolean"},
+        "desc": {"type": "string"},
+        "display": {"type": "string"},
+        "internal": {"type": "boolean"},
+        "type": {"enum": ["string", "integer", "boolean", "port", "enum", "memory"]},
+        "min": {"type": "number"},
+        "max": {"type": "number"},
+        "regex": {"type": "string"},
+        "options": {"type": "array", "items": {"type": "string"}}
+      }
+    }
+  }
+}`
+
+var (
+	parsedSchema map[string]interface{}
+
+	//Fields each install operation needs in addition to its type.
+	operationFields = map[string][]string{
+		"command":   {"commands"},
+		"download":  {"files"},
+		"move":      {"source", "target"},
+		"mkdir":     {"target"},
+		"writefile": {"target", "text"},
+		"include":   {"fragment"},
+	}
+)
+
+func init() {
+	err := json.Unmarshal([]byte(Schema), &parsedSchema)
+	if err != nil {
+		panic(err)
+	}
+}
+
+//Validates a template or server definition against the schema.
+//The definition should already be upgraded to the current schema version.
+func Validate(template map[string]interface{}) utils.ValidationErrors {
+	errs := utils.ValidateSchema(parsedSchema, template)
+
+	pufferd, _ := template["pufferd"].(map[string]interface{})
+	install, _ := pufferd["install"].(map[string]interface{})
+	commands, _ := install["commands"].([]interface{})
+	for i, element := range commands {
+		operation, ok := element.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		operationType, _ := operation["type"].(string)
+		path := "pufferd.install.commands[" + strconv.Itoa(i) + "]"
+		for _, field := range operationFields[operationType] {
+			if _, exists := operation[field]; !exists {
+				errs = append(errs, utils.ValidationError{Field: path + "." + field, Message: "Required property is missing"})
+			}
+		}
+	}
+
+	data, _ := pufferd["data"].(map[string]interface{})
+	for _, name := range sortedKeys(data) {
+		variable, ok := data[name].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		path := "pufferd.data." + name
+		if variable["type"] == "enum" {
+			if options, _ := variable["options"].([]interface{}); len(options) == 0 {
+				errs = append(errs, utils.ValidationError{Field: path + ".options", Message: "Enum variables must declare options"})
+			}
+		}
+		if regex, ok := variable["regex"].(string); ok {
+			if _, err := regexp.Compile(regex); err != nil {
+				errs = append(errs, utils.ValidationError{Field: path + ".regex", Message: "Invalid regex: " + err.Error()})
+			}
+		}
+		min, hasMin := variable["min"].(float64)
+		max, hasMax := variable["max"].(float64)
+		if hasMin && hasMax && min > max {
+			errs = append(errs, utils.ValidationError{Field: path + ".min", Message: fmt.Sprintf("Minimum %v is greater than maximum %v", min, max)})
+		}
+	}
+
+	schedules, _ := pufferd["schedules"].(map[string]interface{})
+	for _, name := range sortedKeys(schedules) {
+		schedule, ok := schedules[name].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		path := "pufferd.schedules." + name
+		if cron, ok := schedule["cron"].(string); ok {
+			if _, err := utils.ParseCron(cron); err != nil {
+				errs = append(errs, utils.ValidationError{Field: path + ".cron", Message: err.Error()})
+			}
+		}
+		actions, _ := schedule["actions"].([]interface{})
+		for i, action := range actions {
+			action, _ := action.(map[string]interface{})
+			if action["type"] == "command" && action["command"] == nil {
+				errs = append(errs, utils.ValidationError{Field: path + ".actions[" + strconv.Itoa(i) + "].command", Message: "Required property is missing"})
+			}
+		}
+	}
+
+	run, _ := pufferd["run"].(map[string]interface{})
+	ready, _ := run["ready"].(map[string]interface{})
+	if pattern, ok := ready["console"].(string); ok {
+		if _, err := regexp.Compile(pattern); err != nil {
+			errs = append(errs, utils.ValidationError{Field: "pufferd.run.ready.console", Message: "Invalid regex: " + err.Error()})
+		}
+	}
+	if transport := run["commandTransport"]; (transport == "rcon" || transport == "both") && run["rcon"] == nil {
+		errs = append(errs, utils.ValidationError{Field: "pufferd.run.rcon", Message: "Required property is missing"})
+	}
+
+	triggers, _ := pufferd["triggers"].([]interface{})
+	for i, trigger := range triggers {
+		trigger, _ := trigger.(map[string]interface{})
+		path := "pufferd.triggers[" + strconv.Itoa(i) + "]"
+		if pattern, ok := trigger["pattern"].(string); ok {
+			if _, err := regexp.Compile(pattern); err != nil {
+				errs = append(errs, utils.ValidationError{Field: path + ".pattern", Message: "Invalid regex: " + err.Error()})
+			}
+		}
+		if action, ok := trigger["action"].(string); ok && (action == "event" || action == "state" || action == "command") {
+			if trigger[action] == nil {
+				errs = append(errs, utils.ValidationError{Field: path + "." + action, Message: "Required property is missing"})
+			}
+		}
+	}
+	return errs
+}
+
+//Reads, resolves and validates a template file, reporting every problem found.
+//Templates it extends are looked up next to the file first, then in the template folder.
+func ValidateFile(path string) (utils.ValidationErrors, error) {
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	source := func(template string) ([]byte, error) {
+		if template == name {
+			return ioutil.ReadFile(path)
+		}
+		data, err := ioutil.ReadFile(filepath.Join(filepath.Dir(path), template+".json"))
+		if err != nil && os.IsNotExist(err) && Folder != "" {
+			data, err = readFromFolder(template)
+		}
+		return data, err
+	}
+
+	template, err := resolve(name, source)
+	if err != nil {
+		if errs, ok := err.(utils.ValidationErrors); ok {
+			return errs, nil
+		}
+		if jsonErr, ok := err.(*json.SyntaxError); ok {
+			return utils.ValidationErrors{{Field: "$", Message: "Invalid JSON: " + jsonErr.Error()}}, nil
+		}
+		if typeErr, ok := err.(*json.UnmarshalTypeError); ok {
+			return utils.ValidationErrors{{Field: "$", Message: "Invalid JSON: " + typeErr.Error()}}, nil
+		}
+		return nil, err
+	}
+	return Validate(template), nil
+}
@@ -18,9 +18,11 @@ package templates
 
 const SRCDS = `{
   "pufferd": {
+    "schemaVersion": 2,
+    "version": "1.0.1",
     "type": "srcds",
-    "install": {
-      "commands": [
+    "fragments": {
+      "steamcmd": [
         {
           "files": "https://steamcdn-a.akamaihd.net/client/installer/steamcmd_linux.tar.gz",
           "type": "download"
@@ -37,21 +39,41 @@ const SRCDS = `{
         }
       ]
     },
+    "install": {
+      "commands": [
+        {
+          "type": "include",
+          "fragment": "steamcmd"
+        }
+      ]
+    },
     "run": {
       "stop": "exit",
+      "ready": {
+        "port": "${port}",
+        "protocol": "udp"
+      },
+      "query": {
+        "protocol": "source"
+      },
       "pre": [],
       "post": [],
       "arguments": [
+      	"+ip",
+      	"${ip}",
+      	"+port",
+      	"${port}",
       	"-game ${gametype}",
       	"-console",
-        "+map ${map}",
+      	"${args \"+map\" map}",
       	"-norestart"
       ],
-      "program": "./srcds_run +ip ${ip} +port ${port}"
+      "program": "./srcds_run"
     },
     "data": {
       "appid": {
         "value": "232250",
+        "type": "integer",
         "required": true,
         "desc": "App ID",
         "display": "Application ID",
@@ -59,6 +81,7 @@ const SRCDS = `{
       },
       "gametype": {
         "value": "tf",
+        "type": "string",
         "required": true,
         "desc": "Game Type",
         "display": "tf, csgo, etc.",
@@ -66,6 +89,7 @@ const SRCDS = `{
       },
       "map": {
       	"value": "ctf_2fort",
+      	"type": "string",
       	"required": false,
       	"desc": "Map",
       	"display": "Map to load",
@@ -73,6 +97,7 @@ const SRCDS = `{
       },
       "ip": {
         "value": "0.0.0.0",
+        "type": "string",
         "required": true,
         "desc": "What IP to bind the server to",
         "display": "IP",
@@ -80,6 +105,7 @@ const SRCDS = `{
       },
       "port": {
         "value": "25565",
+        "type": "port",
         "required": true,
         "desc": "What port to bind the server to",
         "display": "Port",
@@ -91,67 +117,28 @@ const SRCDS = `{
 
 const TF2 = `{
   "pufferd": {
-    "type": "srcds",
+    "schemaVersion": 2,
+    "version": "1.0.1",
+    "extends": "srcds",
     "display": "Team Fortress 2",
-    "install": {
-      "commands": [
-        {
-          "files": "https://steamcdn-a.akamaihd.net/client/installer/steamcmd_linux.tar.gz",
-          "type": "download"
-        },
-        {
-          "commands": [
-            "mkdir steamcmd",
-            "tar --no-same-owner -xzvf steamcmd_linux.tar.gz -C steamcmd",
-            "steamcmd/steamcmd.sh +login anonymous +force_install_dir ${rootdir} +app_update 232250 +quit",
-            "mkdir -p .steam/sdk32",
-            "cp steamcmd/linux32/steamclient.so .steam/sdk32/steamclient.so"
-          ],
-          "type": "command"
-        }
-      ]
-    },
-    "run": {
-      "stop": "exit",
-      "pre": [],
-      "post": [],
-      "arguments": [
-      	"+ip",
-      	"${ip}",
-      	"+port",
-      	"${port}",
-      	"-game tf",
-      	"-console",
-        "+map ${map}",
-      	"-norestart"
-      ],
-      "program": "./srcds_run"
-    },
     "environment": {
       "type": "tty"
     },
     "data": {
+      "appid": {
+        "value": "232250",
+        "internal": true
+      },
+      "gametype": {
+        "value": "tf",
+        "internal": true
+      },
       "map": {
       	"value": "ctf_2fort",
       	"required": true,
       	"desc": "TF2 Map",
-      	"display": "Team Fortess 2 Map to load",
-      	"internal": false
-      },
-      "ip": {
-        "value": "0.0.0.0",
-        "required": true,
-        "desc": "What IP to bind the server to",
-        "display": "IP",
-        "internal": false
-      },
-      "port": {
-        "value": "25565",
-        "required": true,
-        "desc": "What port to bind the server to",
-        "display": "Port",
-        "internal": false
+      	"display": "Team Fortess 2 Map to load"
       }
     }
   }
-}`
+}`
\ No newline at end of file
@@ -0,0 +1,61 @@
+/*
+ Copyright 2016 Padduck, LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+ 	http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+
+package templates_test
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/pufferpanel/pufferd/data/templates"
+	"github.com/pufferpanel/pufferd/utils"
+)
+
+func TestSRCDS_EmptyMap(t *testing.T) {
+	var template struct {
+		Pufferd struct {
+			Run struct {
+				Arguments []string `json:"arguments"`
+			} `json:"run"`
+		} `json:"pufferd"`
+	}
+	err := json.Unmarshal([]byte(templates.SRCDS), &template)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mapping := map[string]interface{}{"ip": "0.0.0.0", "port": "27015", "gametype": "tf", "map": ""}
+	result, err := utils.ReplaceTokensInArr(template.Pufferd.Run.Arguments, mapping)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"+ip", "0.0.0.0", "+port", "27015", "-game tf", "-console", "-norestart"}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("expected %v, got %v", expected, result)
+	}
+
+	mapping["map"] = "ctf_2fort"
+	result, err = utils.ReplaceTokensInArr(template.Pufferd.Run.Arguments, mapping)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = []string{"+ip", "0.0.0.0", "+port", "27015", "-game tf", "-console", "+map", "ctf_2fort", "-norestart"}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("expected %v, got %v", expected, result)
+	}
+}
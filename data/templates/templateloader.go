@@ -19,11 +19,13 @@ package templates
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"github.com/pufferpanel/pufferd/logging"
 	"github.com/pufferpanel/pufferd/utils"
 	"io/ioutil"
 	"os"
 	"github.com/pufferpanel/pufferd/config"
+	"time"
 )
 
 var Folder string;
@@ -32,40 +34,72 @@ func Initialize() {
 	Folder = config.GetOrDefault("templatefolder", utils.JoinPath("data", "templates"))
 }
 
-func CopyTemplates() {
-	os.MkdirAll(Folder, 0755)
-
-	data := Spigot
-	writeFile("spigot", data)
-
-	data = Bungeecord
-	writeFile("bungeecord", data)
-
-	data = CraftbukkitBySpigot
-	writeFile("fakecraftbukkit", data)
+//Templates shipped with pufferd, by the name they are installed as.
+var builtins = map[string]string{
+	"minecraft-base":   MinecraftBase,
+	"spigot":           Spigot,
+	"bungeecord":       Bungeecord,
+	"fakecraftbukkit":  CraftbukkitBySpigot,
+	"vanillaminecraft": Vanilla,
+	"forge":            Forge,
+	"spongeforge":      Sponge,
+	"srcds":            SRCDS,
+	"tf2":              TF2,
+}
 
-	data = Vanilla
-	writeFile("vanillaminecraft", data)
+func CopyTemplates() {
+	syncLock.Lock()
+	defer syncLock.Unlock()
 
-	data = Forge
-	writeFile("forge", data)
+	os.MkdirAll(Folder, 0755)
 
-	data = Sponge
-	writeFile("spongeforge", data)
+	installed, err := GetInstalled()
+	if err != nil {
+		logging.Error("Error reading installed templates", err)
+		installed = make(map[string]InstalledTemplate)
+	}
 
-	data = SRCDS
-	writeFile("srcds", data)
+	for name, data := range builtins {
+		err := installTemplate(name, []byte(data))
+		if err != nil {
+			logging.Error("Error writing template "+name, err)
+			continue
+		}
+		version, _ := templateVersion([]byte(data))
+		installed[name] = InstalledTemplate{Source: SourceBuiltin, Version: version, Synced: time.Now()}
+	}
 
-	data = TF2
-	writeFile("tf2", data)
+	err = saveInstalled(installed)
+	if err != nil {
+		logging.Error("Error recording installed templates", err)
+	}
 }
 
-func writeFile(name string, data string) {
-	jsonData := []byte(data)
+func installTemplate(name string, data []byte) error {
 	var prettyJson bytes.Buffer
-	json.Indent(&prettyJson, jsonData, "", "  ")
-	err := ioutil.WriteFile(utils.JoinPath(Folder, name+".json"), prettyJson.Bytes(), 0664)
+	err := json.Indent(&prettyJson, data, "", "  ")
+	if err != nil {
+		return err
+	}
+	return utils.WriteFileAtomic(utils.JoinPath(Folder, name+".json"), prettyJson.Bytes(), 0664)
+}
+
+//Reads a template from the template folder, resolving what it extends and includes.
+//The result is upgraded to the current schema version and validated.
+func Load(name string) (map[string]interface{}, error) {
+	if !validName(name) {
+		return nil, errors.New("Invalid template name " + name)
+	}
+	template, err := resolve(name, readFromFolder)
 	if err != nil {
-		logging.Error("Error writing template "+name, err)
+		return nil, err
+	}
+	if errs := Validate(template); len(errs) > 0 {
+		return nil, errs
 	}
+	return template, nil
+}
+
+func readFromFolder(name string) ([]byte, error) {
+	return ioutil.ReadFile(utils.JoinPath(Folder, name+".json"))
 }
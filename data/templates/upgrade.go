@@ -0,0 +1,120 @@
+/*
+ Copyright 2016 Padduck, LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+ 	http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package templates
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/pufferpanel/pufferd/utils"
+)
+
+//Upgrades steps a definition from the version it is keyed by to the next version.
+var upgrades = map[int]func(pufferd map[string]interface{}){
+	1: upgradeFromVersion1,
+}
+
+//Upgrades a template or server definition in place to the current schema version.
+//Definitions without a schemaVersion are treated as version 1.
+func Upgrade(template map[string]interface{}) utils.ValidationErrors {
+	pufferd, ok := template["pufferd"].(map[string]interface{})
+	if !ok {
+		return utils.ValidationErrors{{Field: "pufferd", Message: "Expected object but found " + utils.DescribeJsonType(template["pufferd"])}}
+	}
+
+	version := 1
+	if raw, exists := pufferd["schemaVersion"]; exists {
+		number, ok := raw.(float64)
+		if !ok || number != float64(int(number)) {
+			return utils.ValidationErrors{{Field: "pufferd.schemaVersion", Message: "Expected integer but found " + utils.DescribeJsonType(raw)}}
+		}
+		version = int(number)
+	}
+
+	if version < 1 {
+		return utils.ValidationErrors{{Field: "pufferd.schemaVersion", Message: fmt.Sprintf("Version %d is not a valid version", version)}}
+	}
+	if version > SchemaVersion {
+		return utils.ValidationErrors{{Field: "pufferd.schemaVersion", Message: fmt.Sprintf("Version %d is newer than the supported version %d", version, SchemaVersion)}}
+	}
+
+	for ; version < SchemaVersion; version++ {
+		upgrades[version](pufferd)
+	}
+	pufferd["schemaVersion"] = float64(SchemaVersion)
+	return nil
+}
+
+//Version 1 definitions allowed run arguments as a single string and described
+//installs as lists of files and pre/post commands instead of operations.
+func upgradeFromVersion1(pufferd map[string]interface{}) {
+	if run, ok := pufferd["run"].(map[string]interface{}); ok {
+		if arguments, ok := run["arguments"].(string); ok {
+			run["arguments"] = toInterfaceArray(utils.SplitArguments(arguments))
+		}
+		for _, key := range []string{"pre", "post"} {
+			if value, ok := run[key].(string); ok {
+				run[key] = []interface{}{value}
+			}
+		}
+	}
+
+	if install, ok := pufferd["install"].(map[string]interface{}); ok {
+		if _, hasCommands := install["commands"]; !hasCommands {
+			commands := make([]interface{}, 0)
+			if pre := utils.ToStringArray(install["pre"]); len(pre) > 0 {
+				commands = append(commands, map[string]interface{}{"type": "command", "commands": toInterfaceArray(pre)})
+			}
+			if files := utils.ToStringArray(install["files"]); len(files) > 0 {
+				commands = append(commands, map[string]interface{}{"type": "download", "files": toInterfaceArray(files)})
+			}
+			if post := utils.ToStringArray(install["post"]); len(post) > 0 {
+				commands = append(commands, map[string]interface{}{"type": "command", "commands": toInterfaceArray(post)})
+			}
+			pufferd["install"] = map[string]interface{}{"commands": commands}
+		}
+	}
+
+	if data, ok := pufferd["data"].(map[string]interface{}); ok {
+		for _, name := range sortedKeys(data) {
+			variable, ok := data[name].(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if _, hasType := variable["type"]; !hasType {
+				variable["type"] = "string"
+			}
+		}
+	}
+}
+
+func toInterfaceArray(values []string) []interface{} {
+	result := make([]interface{}, len(values))
+	for i, v := range values {
+		result[i] = v
+	}
+	return result
+}
+
+func sortedKeys(mapping map[string]interface{}) []string {
+	keys := make([]string, 0, len(mapping))
+	for k := range mapping {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
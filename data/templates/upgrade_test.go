@@ -0,0 +1,42 @@
+/*
+ Copyright 2016 Padduck, LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+ 	http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package templates_test
+
+import (
+	"testing"
+
+	"github.com/pufferpanel/pufferd/data/templates"
+)
+
+func TestUpgrade(t *testing.T) {
+	template := map[string]interface{}{"pufferd": map[string]interface{}{"run": map[string]interface{}{"arguments": "-jar server.jar"}}}
+	if errs := templates.Upgrade(template); len(errs) != 0 {
+		t.Fatalf("Unexpected errors %v", errs)
+	}
+	arguments := template["pufferd"].(map[string]interface{})["run"].(map[string]interface{})["arguments"]
+	if len(arguments.([]interface{})) != 2 {
+		t.Errorf("Expected the arguments to be split but got %v", arguments)
+	}
+
+	for _, version := range []float64{0, -1, templates.SchemaVersion + 1} {
+		template = map[string]interface{}{"pufferd": map[string]interface{}{"schemaVersion": version}}
+		errs := templates.Upgrade(template)
+		if len(errs) != 1 || errs[0].Field != "pufferd.schemaVersion" {
+			t.Errorf("Expected version %v to be refused but got %v", version, errs)
+		}
+	}
+}
@@ -17,6 +17,8 @@
 package environments
 
 import (
+	"io"
+
 	"github.com/gorilla/websocket"
 )
 
@@ -58,4 +60,7 @@ type Environment interface {
 	GetStats() (map[string]interface{}, error)
 
 	DisplayToConsole(msg string)
+
+	//Sets a writer which also receives the output of processes started after this call.
+	SetConsoleListener(listener io.Writer)
 }
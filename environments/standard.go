@@ -40,6 +40,7 @@ type standard struct {
 	mainProcess   *exec.Cmd
 	stdInWriter   io.Writer
 	wait          sync.WaitGroup
+	listener      io.Writer
 }
 
 func (s *standard) Execute(cmd string, args []string) (stdOut []byte, err error) {
@@ -184,9 +185,17 @@ func (s *standard) DisplayToConsole(msg string) {
 	s.ConsoleBuffer.Write([]byte(msg))
 }
 
+func (s *standard) SetConsoleListener(listener io.Writer) {
+	s.listener = listener
+}
+
 func (s *standard) createWrapper() io.Writer {
+	writers := []io.Writer{s.ConsoleBuffer, s.WSManager}
 	if config.Get("forward") == "true" {
-		return io.MultiWriter(os.Stdout, s.ConsoleBuffer, s.WSManager)
+		writers = append([]io.Writer{os.Stdout}, writers...)
+	}
+	if s.listener != nil {
+		writers = append(writers, s.listener)
 	}
-	return io.MultiWriter(s.ConsoleBuffer, s.WSManager)
+	return io.MultiWriter(writers...)
 }
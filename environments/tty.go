@@ -43,6 +43,7 @@ type tty struct {
 	mainProcess   *exec.Cmd
 	stdInWriter   io.Writer
 	wait          sync.WaitGroup
+	listener      io.Writer
 }
 
 func (s *tty) Execute(cmd string, args []string) (stdOut []byte, err error) {
@@ -189,9 +190,17 @@ func (s *tty) DisplayToConsole(msg string) {
 	s.ConsoleBuffer.Write([]byte(msg))
 }
 
+func (s *tty) SetConsoleListener(listener io.Writer) {
+	s.listener = listener
+}
+
 func (s *tty) createWrapper() io.Writer {
+	writers := []io.Writer{s.ConsoleBuffer, s.WSManager}
 	if config.Get("forward") == "true" {
-		return io.MultiWriter(os.Stdout, s.ConsoleBuffer, s.WSManager)
+		writers = append([]io.Writer{os.Stdout}, writers...)
+	}
+	if s.listener != nil {
+		writers = append(writers, s.listener)
 	}
-	return io.MultiWriter(s.ConsoleBuffer, s.WSManager)
+	return io.MultiWriter(writers...)
 }
@@ -0,0 +1,98 @@
+/*
+ Copyright 2016 Padduck, LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+ 	http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+//Carries things which happen to servers, such as state changes and console triggers, to API clients.
+//The most recent events of each server are kept so clients which connect later can catch up.
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	TypeState   = "state"
+	TypeTrigger = "trigger"
+	TypeQuota   = "quota"
+)
+
+//How many events are kept for each server.
+const recentLimit = 100
+
+type Event struct {
+	Server string                 `json:"server"`
+	Type   string                 `json:"type"`
+	Name   string                 `json:"name,omitempty"`
+	Data   map[string]interface{} `json:"data,omitempty"`
+	Time   time.Time              `json:"time"`
+}
+
+var (
+	recent      = make(map[string][]Event)
+	subscribers = make(map[string]map[chan Event]bool)
+	lock        sync.Mutex
+)
+
+//Sends an event to the subscribers of its server. Subscribers which are not keeping up miss it.
+func Publish(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	events := append(recent[event.Server], event)
+	if len(events) > recentLimit {
+		events = events[len(events)-recentLimit:]
+	}
+	recent[event.Server] = events
+
+	for subscriber := range subscribers[event.Server] {
+		select {
+		case subscriber <- event:
+		default:
+		}
+	}
+}
+
+//Returns the recent events of a server, oldest first.
+func Recent(server string) []Event {
+	lock.Lock()
+	defer lock.Unlock()
+	result := make([]Event, len(recent[server]))
+	copy(result, recent[server])
+	return result
+}
+
+//Returns a channel receiving the events of a server, and a function which stops them.
+func Subscribe(server string) (<-chan Event, func()) {
+	channel := make(chan Event, 16)
+	lock.Lock()
+	if subscribers[server] == nil {
+		subscribers[server] = make(map[chan Event]bool)
+	}
+	subscribers[server][channel] = true
+	lock.Unlock()
+
+	return channel, func() {
+		lock.Lock()
+		defer lock.Unlock()
+		delete(subscribers[server], channel)
+		if len(subscribers[server]) == 0 {
+			delete(subscribers, server)
+		}
+	}
+}
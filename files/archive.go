@@ -0,0 +1,181 @@
+/*
+ Copyright 2016 Padduck, LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+ 	http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package files
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pufferpanel/pufferd/confined"
+)
+
+const (
+	FormatZip   = "zip"
+	FormatTarGz = "tar.gz"
+)
+
+//Writes a zip or gzip compressed tar of the given paths straight to the writer, for streaming a download.
+//Directories are included with everything in them, and symlinks are stored as symlinks rather than followed.
+//Entries are named by their path relative to the root.
+func WriteArchive(writer io.Writer, root string, paths []string, format string) error {
+	resolved := make([]string, len(paths))
+	for i, file := range paths {
+		var err error
+		resolved[i], err = Resolve(root, file)
+		if err != nil {
+			return err
+		}
+		if _, err := os.Lstat(resolved[i]); err != nil {
+			return toError(file, err)
+		}
+	}
+
+	var archive archiveWriter
+	switch format {
+	case FormatZip:
+		archive = &zipWriter{writer: zip.NewWriter(writer)}
+	case FormatTarGz:
+		compressed := gzip.NewWriter(writer)
+		archive = &tarWriter{writer: tar.NewWriter(compressed), compressed: compressed}
+	default:
+		return &Error{Code: CodeInvalid, Message: "Format must be zip or tar.gz"}
+	}
+
+	fs := confined.New(root)
+	base := fs.Root()
+	for _, file := range resolved {
+		err := filepath.Walk(file, func(current string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			name, err := filepath.Rel(base, current)
+			if err != nil {
+				return err
+			}
+			name = filepath.ToSlash(name)
+			switch {
+			case info.IsDir():
+				if name == "." {
+					return nil
+				}
+				return archive.add(name+"/", info, "", nil)
+			case info.Mode()&os.ModeSymlink != 0:
+				link, err := os.Readlink(current)
+				if err != nil {
+					return err
+				}
+				return archive.add(name, info, link, nil)
+			case info.Mode().IsRegular():
+				//opened through the root, so a file swapped for a symlink during the walk cannot lead out of it
+				contents, err := fs.Open(name)
+				if err != nil {
+					return err
+				}
+				defer contents.Close()
+				return archive.add(name, info, "", contents)
+			}
+			//sockets, devices and pipes are left out
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return archive.Close()
+}
+
+type archiveWriter interface {
+	//Adds an entry. Directory names end with a slash, and link is the target of symlinks.
+	add(name string, info os.FileInfo, link string, contents io.Reader) error
+	Close() error
+}
+
+type zipWriter struct {
+	writer *zip.Writer
+}
+
+func (z *zipWriter) add(name string, info os.FileInfo, link string, contents io.Reader) error {
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	if info.Mode().IsRegular() {
+		header.Method = zip.Deflate
+	}
+	entry, err := z.writer.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	if link != "" {
+		_, err = io.WriteString(entry, link)
+	} else if contents != nil {
+		_, err = io.Copy(entry, contents)
+	}
+	return err
+}
+
+func (z *zipWriter) Close() error {
+	return z.writer.Close()
+}
+
+type tarWriter struct {
+	writer     *tar.Writer
+	compressed *gzip.Writer
+}
+
+func (t *tarWriter) add(name string, info os.FileInfo, link string, contents io.Reader) error {
+	header, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	//the owner on this machine means nothing where the archive is extracted
+	header.Uid, header.Gid, header.Uname, header.Gname = 0, 0, "", ""
+	err = t.writer.WriteHeader(header)
+	if err == nil && contents != nil {
+		_, err = io.Copy(t.writer, contents)
+	}
+	return err
+}
+
+func (t *tarWriter) Close() error {
+	err := t.writer.Close()
+	if closeErr := t.compressed.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+//Converts the name of an entry in an uploaded archive to a clean relative path, rejecting any which escape
+//the directory it is extracted to. An empty path is returned for the directory itself.
+func entryPath(name string) (string, error) {
+	relative := path.Clean(strings.Replace(name, "\\", "/", -1))
+	if relative == "." || relative == "/" {
+		return "", nil
+	}
+	if path.IsAbs(relative) || relative == ".." || strings.HasPrefix(relative, "../") {
+		return "", &Error{Path: name, Code: CodeInvalid, Message: "Archive entry is outside of the target directory"}
+	}
+	return relative, nil
+}
@@ -0,0 +1,169 @@
+/*
+ Copyright 2016 Padduck, LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+ 	http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package files_test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pufferpanel/pufferd/files"
+	"github.com/pufferpanel/pufferd/quota"
+)
+
+func TestArchive_RoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pufferd-archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	root := filepath.Join(dir, "server")
+	os.MkdirAll(filepath.Join(root, "world", "region"), 0755)
+	ioutil.WriteFile(filepath.Join(root, "world", "level.dat"), []byte("level"), 0644)
+	ioutil.WriteFile(filepath.Join(root, "world", "region", "r.0.0.mca"), []byte("region"), 0600)
+	os.Symlink("level.dat", filepath.Join(root, "world", "current"))
+	ioutil.WriteFile(filepath.Join(root, "server.properties"), []byte("motd=A server"), 0644)
+
+	for _, format := range []string{files.FormatZip, files.FormatTarGz} {
+		archive := &bytes.Buffer{}
+		err = files.WriteArchive(archive, root, []string{"world", "server.properties"}, format)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		result, err := files.Extract(root, bytes.NewReader(archive.Bytes()), "copy-"+format, "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Extracted != 4 {
+			t.Errorf("Expected 4 entries extracted from %s but got %d", format, result.Extracted)
+		}
+		copied := filepath.Join(root, "copy-"+format)
+		if data, _ := ioutil.ReadFile(filepath.Join(copied, "world", "region", "r.0.0.mca")); string(data) != "region" {
+			t.Errorf("Unexpected contents %q extracted from %s", data, format)
+		}
+		if link, _ := os.Readlink(filepath.Join(copied, "world", "current")); link != "level.dat" {
+			t.Errorf("Expected symlink to level.dat extracted from %s but got %q", format, link)
+		}
+
+		_, err = files.Extract(root, bytes.NewReader(archive.Bytes()), "copy-"+format, files.OverwriteFail, nil)
+		if fileErr, ok := err.(*files.Error); !ok || fileErr.Code != files.CodeExists {
+			t.Errorf("Expected extracting over existing files to fail but got %v", err)
+		}
+		ioutil.WriteFile(filepath.Join(copied, "server.properties"), []byte("changed"), 0644)
+		result, err = files.Extract(root, bytes.NewReader(archive.Bytes()), "copy-"+format, files.OverwriteSkip, nil)
+		if err != nil || result.Skipped != 4 {
+			t.Errorf("Expected 4 entries skipped but got %+v, %v", result, err)
+		}
+		result, err = files.Extract(root, bytes.NewReader(archive.Bytes()), "copy-"+format, files.OverwriteReplace, nil)
+		if err != nil || result.Extracted != 4 {
+			t.Errorf("Expected 4 entries replaced but got %+v, %v", result, err)
+		}
+		if data, _ := ioutil.ReadFile(filepath.Join(copied, "server.properties")); string(data) != "motd=A server" {
+			t.Errorf("Expected the file to be replaced but got %q", data)
+		}
+	}
+
+	//an uploaded zip is staged in the root, so it must fit in the quota
+	archive := &bytes.Buffer{}
+	files.WriteArchive(archive, root, []string{"world"}, files.FormatZip)
+	_, err = files.Extract(root, archive, "limited", "", quota.New(root, 1, true))
+	if fileErr, ok := err.(*files.Error); !ok || fileErr.Code != files.CodeQuotaExceeded {
+		t.Errorf("Expected a zip larger than the quota to be refused but got %v", err)
+	}
+	if staged, _ := filepath.Glob(filepath.Join(root, ".pufferd-extract*")); len(staged) != 0 {
+		t.Errorf("Expected the staged zip to be removed but found %v", staged)
+	}
+
+	_, err = files.ExtractFile(root, "world/level.dat", "", "", nil)
+	if fileErr, ok := err.(*files.Error); !ok || fileErr.Code != files.CodeInvalid {
+		t.Errorf("Expected a file which is not an archive to be rejected but got %v", err)
+	}
+}
+
+func TestExtract_Escapes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pufferd-extract")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	root := filepath.Join(dir, "server")
+	outside := filepath.Join(dir, "outside")
+	os.MkdirAll(root, 0755)
+	os.MkdirAll(outside, 0755)
+	os.Symlink(outside, filepath.Join(root, "escape"))
+
+	archives := map[string]func(*tar.Writer){
+		"zip slip": func(archive *tar.Writer) {
+			writeTarFile(archive, "../outside/evil", "evil")
+		},
+		"absolute symlink": func(archive *tar.Writer) {
+			archive.WriteHeader(&tar.Header{Name: "link", Typeflag: tar.TypeSymlink, Linkname: outside})
+		},
+		"relative symlink": func(archive *tar.Writer) {
+			archive.WriteHeader(&tar.Header{Name: "dir/link", Typeflag: tar.TypeSymlink, Linkname: "../../outside"})
+		},
+		"symlink then write": func(archive *tar.Writer) {
+			archive.WriteHeader(&tar.Header{Name: "inside", Typeflag: tar.TypeDir, Mode: 0755})
+			archive.WriteHeader(&tar.Header{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "inside"})
+			writeTarFile(archive, "link/evil", "evil")
+		},
+		"existing symlink": func(archive *tar.Writer) {
+			writeTarFile(archive, "escape/evil", "evil")
+		},
+		"hardlink": func(archive *tar.Writer) {
+			archive.WriteHeader(&tar.Header{Name: "link", Typeflag: tar.TypeLink, Linkname: "../outside/secret"})
+		},
+	}
+	for name, write := range archives {
+		buffer := &bytes.Buffer{}
+		compressed := gzip.NewWriter(buffer)
+		archive := tar.NewWriter(compressed)
+		write(archive)
+		archive.Close()
+		compressed.Close()
+
+		_, err := files.Extract(root, buffer, "", files.OverwriteReplace, nil)
+		if fileErr, ok := err.(*files.Error); !ok || fileErr.Code != files.CodeInvalid {
+			t.Errorf("Expected %s to be rejected but got %v", name, err)
+		}
+	}
+
+	buffer := &bytes.Buffer{}
+	archive := zip.NewWriter(buffer)
+	entry, _ := archive.Create("..\\outside\\evil")
+	entry.Write([]byte("evil"))
+	archive.Close()
+	_, err = files.Extract(root, buffer, "", files.OverwriteReplace, nil)
+	if fileErr, ok := err.(*files.Error); !ok || fileErr.Code != files.CodeInvalid {
+		t.Errorf("Expected zip slip to be rejected but got %v", err)
+	}
+
+	if entries, _ := ioutil.ReadDir(outside); len(entries) != 0 {
+		t.Errorf("Expected nothing written outside the root but found %d files", len(entries))
+	}
+}
+
+func writeTarFile(archive *tar.Writer, name string, contents string) {
+	archive.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(contents))})
+	archive.Write([]byte(contents))
+}
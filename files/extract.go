@@ -0,0 +1,357 @@
+/*
+ Copyright 2016 Padduck, LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+ 	http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package files
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pufferpanel/pufferd/confined"
+	"github.com/pufferpanel/pufferd/quota"
+)
+
+const (
+	OverwriteFail    = "fail"
+	OverwriteSkip    = "skip"
+	OverwriteReplace = "overwrite"
+)
+
+//Symlink targets are short, larger entries are not a symlink made by an archiver.
+const maxLinkLength = 4096
+
+var zipMagic = []byte("PK\x03\x04")
+
+type ExtractResult struct {
+	Extracted int `json:"extracted"`
+	Skipped   int `json:"skipped"`
+}
+
+//Extracts a zip or gzip compressed tar under the root into the target directory, creating it if needed.
+//The policy decides what happens to files which already exist: fail stops the extraction, skip keeps
+//the existing file and overwrite replaces it. Entries are checked as they are extracted, so when one fails
+//the entries before it are left in place. Extracted files count towards the quota, if there is one.
+func ExtractFile(root string, archive string, target string, policy string, limit *quota.Quota) (ExtractResult, error) {
+	resolved, err := Resolve(root, archive)
+	if err != nil {
+		return ExtractResult{}, err
+	}
+	file, err := os.Open(resolved)
+	if err != nil {
+		return ExtractResult{}, toError(archive, err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return ExtractResult{}, toError(archive, err)
+	}
+
+	magic := make([]byte, len(zipMagic))
+	file.ReadAt(magic, 0)
+	if bytes.Equal(magic, zipMagic) {
+		return extractZip(root, file, info.Size(), target, policy, limit)
+	}
+	return extractTarGz(root, file, target, policy, limit)
+}
+
+//Extracts an uploaded archive as ExtractFile does. Zip archives are read from their end, so they are
+//written to a temporary file in the root first, which the quota limits, while tars are extracted as they are read.
+func Extract(root string, reader io.Reader, target string, policy string, limit *quota.Quota) (ExtractResult, error) {
+	buffered := bufio.NewReader(reader)
+	magic, _ := buffered.Peek(len(zipMagic))
+	if !bytes.Equal(magic, zipMagic) {
+		return extractTarGz(root, buffered, target, policy, limit)
+	}
+
+	temp, err := ioutil.TempFile(confined.New(root).Root(), ".pufferd-extract")
+	if err != nil {
+		return ExtractResult{}, toError("", err)
+	}
+	defer os.Remove(temp.Name())
+	defer temp.Close()
+	size, err := io.Copy(limit.Writer(temp, 0), buffered)
+	if err != nil {
+		return ExtractResult{}, toError("", err)
+	}
+	return extractZip(root, temp, size, target, policy, limit)
+}
+
+func extractZip(root string, reader io.ReaderAt, size int64, target string, policy string, limit *quota.Quota) (ExtractResult, error) {
+	extractor, err := newExtractor(root, target, policy, limit)
+	if err != nil {
+		return ExtractResult{}, err
+	}
+	archive, err := zip.NewReader(reader, size)
+	if err != nil {
+		return ExtractResult{}, &Error{Code: CodeInvalid, Message: "Invalid archive: " + err.Error()}
+	}
+
+	for _, file := range archive.File {
+		err = extractor.extractZipEntry(file)
+		if err != nil {
+			return extractor.result, err
+		}
+	}
+	return extractor.result, nil
+}
+
+func (e *extractor) extractZipEntry(file *zip.File) error {
+	mode := file.Mode()
+	if strings.HasSuffix(file.Name, "/") {
+		mode |= os.ModeDir
+	}
+	if mode.IsDir() {
+		return e.extract(file.Name, tar.TypeDir, mode, "", file.ModTime(), nil)
+	}
+
+	contents, err := file.Open()
+	if err != nil {
+		return &Error{Path: file.Name, Code: CodeInvalid, Message: "Invalid archive: " + err.Error()}
+	}
+	defer contents.Close()
+	if mode&os.ModeSymlink != 0 {
+		link, err := ioutil.ReadAll(io.LimitReader(contents, maxLinkLength))
+		if err != nil {
+			return &Error{Path: file.Name, Code: CodeInvalid, Message: "Invalid archive: " + err.Error()}
+		}
+		return e.extract(file.Name, tar.TypeSymlink, mode, string(link), file.ModTime(), nil)
+	}
+	if !mode.IsRegular() {
+		return nil
+	}
+	return e.extract(file.Name, tar.TypeReg, mode, "", file.ModTime(), contents)
+}
+
+func extractTarGz(root string, reader io.Reader, target string, policy string, limit *quota.Quota) (ExtractResult, error) {
+	extractor, err := newExtractor(root, target, policy, limit)
+	if err != nil {
+		return ExtractResult{}, err
+	}
+	compressed, err := gzip.NewReader(reader)
+	if err != nil {
+		return ExtractResult{}, &Error{Code: CodeInvalid, Message: "Archive must be a zip or tar.gz"}
+	}
+	defer compressed.Close()
+	archive := tar.NewReader(compressed)
+
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			return extractor.result, nil
+		}
+		if err != nil {
+			return extractor.result, &Error{Code: CodeInvalid, Message: "Invalid archive: " + err.Error()}
+		}
+		kind := header.Typeflag
+		if kind == tar.TypeRegA {
+			kind = tar.TypeReg
+		}
+		switch kind {
+		case tar.TypeDir, tar.TypeReg, tar.TypeSymlink, tar.TypeLink:
+			err = extractor.extract(header.Name, kind, os.FileMode(header.Mode), header.Linkname, header.ModTime, archive)
+		}
+		//devices, fifos and other special files are not extracted
+		if err != nil {
+			return extractor.result, err
+		}
+	}
+}
+
+type extractor struct {
+	directory string
+	policy    string
+	quota     *quota.Quota
+	result    ExtractResult
+}
+
+func newExtractor(root string, target string, policy string, limit *quota.Quota) (*extractor, error) {
+	switch policy {
+	case "":
+		policy = OverwriteFail
+	case OverwriteFail, OverwriteSkip, OverwriteReplace:
+	default:
+		return nil, &Error{Code: CodeInvalid, Message: "Overwrite policy must be one of fail, skip, overwrite"}
+	}
+	directory, err := Resolve(root, target)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Lstat(directory)
+	if os.IsNotExist(err) {
+		err = os.MkdirAll(directory, 0755)
+		if err != nil {
+			return nil, toError(target, err)
+		}
+	} else if err != nil {
+		return nil, toError(target, err)
+	} else if !info.IsDir() {
+		return nil, &Error{Path: target, Code: CodeNotDirectory, Message: "Target is not a directory"}
+	}
+	return &extractor{directory: directory, policy: policy, quota: limit}, nil
+}
+
+//Extracts an entry, given by its tar type flag, into the target directory. Link is the target of symlinks,
+//or the entry a hardlink links to. Nothing is written through a symlink, and symlinks and hardlinks
+//cannot point outside of the target directory.
+func (e *extractor) extract(name string, kind byte, mode os.FileMode, link string, modified time.Time, contents io.Reader) error {
+	relative, err := entryPath(name)
+	if err != nil || relative == "" {
+		return err
+	}
+	err = e.checkParents(name, relative)
+	if err != nil {
+		return err
+	}
+	destination := filepath.Join(e.directory, filepath.FromSlash(relative))
+
+	existing, err := os.Lstat(destination)
+	if err == nil {
+		if kind == tar.TypeDir && existing.IsDir() {
+			return nil
+		}
+		switch {
+		case e.policy == OverwriteSkip:
+			e.result.Skipped++
+			return nil
+		case e.policy == OverwriteFail:
+			return &Error{Path: name, Code: CodeExists, Message: "File already exists"}
+		case existing.IsDir():
+			//replacing a directory would delete everything in it
+			return &Error{Path: name, Code: CodeExists, Message: "A directory is in the way"}
+		}
+	} else if !os.IsNotExist(err) {
+		return toError(name, err)
+	}
+
+	err = os.MkdirAll(filepath.Dir(destination), 0755)
+	if err != nil {
+		return toError(name, err)
+	}
+
+	switch kind {
+	case tar.TypeDir:
+		if existing != nil {
+			os.Remove(destination)
+		}
+		err = os.Mkdir(destination, mode.Perm()|0700)
+	case tar.TypeSymlink:
+		resolved := path.Join(path.Dir(relative), filepath.ToSlash(link))
+		if link == "" || path.IsAbs(link) || resolved == ".." || strings.HasPrefix(resolved, "../") {
+			return &Error{Path: name, Code: CodeInvalid, Message: "Symlink points outside of the target directory"}
+		}
+		err = replace(destination, func(temp string) error {
+			return os.Symlink(link, temp)
+		})
+	case tar.TypeLink:
+		source, linkErr := entryPath(link)
+		if linkErr != nil || source == "" {
+			return &Error{Path: name, Code: CodeInvalid, Message: "Hardlink points outside of the target directory"}
+		}
+		if linkErr = e.checkParents(link, source); linkErr != nil {
+			return linkErr
+		}
+		source = filepath.Join(e.directory, filepath.FromSlash(source))
+		if info, err := os.Lstat(source); err != nil || !info.Mode().IsRegular() {
+			return &Error{Path: name, Code: CodeInvalid, Message: "Hardlink does not point to a file in the archive"}
+		}
+		err = replace(destination, func(temp string) error {
+			return os.Link(source, temp)
+		})
+	case tar.TypeReg:
+		//a file being replaced frees its space
+		var written, replaced int64
+		if existing != nil && existing.Mode().IsRegular() {
+			replaced = existing.Size()
+		}
+		err = replace(destination, func(temp string) error {
+			file, err := os.OpenFile(temp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode.Perm()|0600)
+			if err != nil {
+				return err
+			}
+			written, err = io.Copy(e.quota.Writer(file, replaced), contents)
+			if closeErr := file.Close(); err == nil {
+				err = closeErr
+			}
+			if err == nil {
+				err = os.Chtimes(temp, modified, modified)
+			}
+			return err
+		})
+		if err == nil {
+			e.quota.Add(written - replaced)
+		}
+	}
+	if err != nil {
+		return toError(name, err)
+	}
+	if kind != tar.TypeDir {
+		e.result.Extracted++
+	}
+	return nil
+}
+
+//Ensures none of the directories leading to an entry are symlinks, so writing it cannot leave the target directory.
+func (e *extractor) checkParents(name string, relative string) error {
+	current := e.directory
+	parts := strings.Split(relative, "/")
+	for _, part := range parts[:len(parts)-1] {
+		current = filepath.Join(current, part)
+		info, err := os.Lstat(current)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return toError(name, err)
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return &Error{Path: name, Code: CodeInvalid, Message: "Archive entry is inside a symlinked directory"}
+		}
+		if !info.IsDir() {
+			return &Error{Path: name, Code: CodeNotDirectory, Message: "A file is in the way of the archive entry"}
+		}
+	}
+	return nil
+}
+
+//Creates a file at a temporary path next to the destination and renames it over the destination,
+//so an existing file, or symlink, is replaced rather than written through.
+func replace(destination string, create func(temp string) error) error {
+	temp, err := ioutil.TempFile(filepath.Dir(destination), "."+filepath.Base(destination)+".tmp")
+	if err != nil {
+		return err
+	}
+	temp.Close()
+	os.Remove(temp.Name())
+
+	err = create(temp.Name())
+	if err == nil {
+		err = os.Rename(temp.Name(), destination)
+	}
+	if err != nil {
+		os.Remove(temp.Name())
+	}
+	return err
+}
@@ -0,0 +1,283 @@
+/*
+ Copyright 2016 Padduck, LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+ 	http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+//Works on the files of a server for the HTTP API. Paths are relative to the server root and confined to it.
+package files
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pufferpanel/pufferd/confined"
+	"github.com/pufferpanel/pufferd/quota"
+	"github.com/pufferpanel/pufferd/utils"
+)
+
+const (
+	CodeNotFound         = "not_found"
+	CodeExists           = "exists"
+	CodeOutsideRoot      = "outside_root"
+	CodeNotDirectory     = "not_directory"
+	CodePermissionDenied = "permission_denied"
+	CodeInvalid          = "invalid"
+	CodeQuotaExceeded    = "quota_exceeded"
+	CodeFailed           = "failed"
+)
+
+//The failure of an operation on one path, with a code clients can act on.
+type Error struct {
+	Path    string `json:"path"`
+	Code    string `json:"code"`
+	Message string `json:"error"`
+}
+
+func (e *Error) Error() string {
+	return e.Path + ": " + e.Message
+}
+
+//Returns the absolute path of a path relative to the root with symlinks followed,
+//failing if it, or any symlink along it, leads outside the root.
+func Resolve(root string, path string) (string, error) {
+	resolved, err := confined.New(root).Resolve(path)
+	return resolved, resolveError(path, err)
+}
+
+//Resolves a path which is changed by an operation, which the root itself cannot be.
+//A symlink at the end of the path is not followed, so the operation applies to the link.
+func resolveEntry(root string, path string) (string, error) {
+	fs := confined.New(root)
+	resolved, err := fs.Lresolve(path)
+	if err != nil {
+		return "", resolveError(path, err)
+	}
+	if resolved == fs.Root() {
+		return "", &Error{Path: path, Code: CodeInvalid, Message: "The server root cannot be changed"}
+	}
+	return resolved, nil
+}
+
+func resolveError(path string, err error) error {
+	if err == confined.ErrOutside {
+		return &Error{Path: path, Code: CodeOutsideRoot, Message: "Path is outside the server root"}
+	}
+	return toError(path, err)
+}
+
+//Opens a file or directory under the root for reading. The open itself is confined to the root,
+//so a symlink swapped in after the path is resolved cannot lead outside of it.
+func Open(root string, path string) (*os.File, error) {
+	file, err := confined.New(root).Open(path)
+	if err != nil {
+		return nil, resolveError(path, err)
+	}
+	return file, nil
+}
+
+//Deletes a file, or a directory with everything in it. Symlinks are removed rather than followed.
+func Delete(root string, path string) error {
+	resolved, err := resolveEntry(root, path)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Lstat(resolved); err != nil {
+		return toError(path, err)
+	}
+	return toError(path, os.RemoveAll(resolved))
+}
+
+//Moves or renames a file or directory. As with mv, a target which is a directory receives the source inside it.
+func Move(root string, source string, target string) error {
+	from, to, err := resolvePair(root, source, target)
+	if err != nil {
+		return err
+	}
+	return toError(source, os.Rename(from, to))
+}
+
+//Copies a file or directory tree. As with cp, a target which is a directory receives the copy inside it.
+//The copy must fit in the quota, if there is one.
+func Copy(root string, source string, target string, limit *quota.Quota) error {
+	from, to, err := resolvePair(root, source, target)
+	if err != nil {
+		return err
+	}
+	size, err := utils.DirectorySize(from)
+	if err == nil {
+		err = limit.Check(size)
+	}
+	if err == nil {
+		err = utils.CopyDirectory(from, to, nil)
+	}
+	if err != nil {
+		return toError(source, err)
+	}
+	limit.Add(size)
+	return nil
+}
+
+//Resolves the source and destination of a move or copy, which must not already exist
+//and cannot be inside the source.
+func resolvePair(root string, source string, target string) (string, string, error) {
+	from, err := resolveEntry(root, source)
+	if err != nil {
+		return "", "", err
+	}
+	to, err := resolveEntry(root, target)
+	if err != nil {
+		return "", "", err
+	}
+	directory, err := Resolve(root, target)
+	if err != nil {
+		return "", "", err
+	}
+	if info, err := os.Stat(directory); err == nil && info.IsDir() {
+		to = filepath.Join(directory, filepath.Base(from))
+	}
+	if _, err := os.Lstat(from); err != nil {
+		return "", "", toError(source, err)
+	}
+	if to == from || strings.HasPrefix(to, from+string(filepath.Separator)) {
+		return "", "", &Error{Path: source, Code: CodeInvalid, Message: "Target is inside the source"}
+	}
+	if _, err := os.Lstat(to); err == nil {
+		return "", "", &Error{Path: target, Code: CodeExists, Message: "Target already exists"}
+	}
+	if info, err := os.Stat(filepath.Dir(to)); err != nil || !info.IsDir() {
+		return "", "", &Error{Path: target, Code: CodeNotDirectory, Message: "Parent of the target is not a directory"}
+	}
+	return from, to, nil
+}
+
+//Creates a directory along with any missing parents.
+func Mkdir(root string, path string) error {
+	resolved, err := resolveEntry(root, path)
+	if err != nil {
+		return err
+	}
+	if info, err := os.Stat(resolved); err == nil && !info.IsDir() {
+		return &Error{Path: path, Code: CodeExists, Message: "A file with that name already exists"}
+	}
+	return toError(path, os.MkdirAll(resolved, 0755))
+}
+
+//Changes the permissions of a file, or with recursive set of a directory and everything in it.
+//Only permission bits can be set, and symlinks are skipped since changing them would change their target.
+func Chmod(root string, path string, mode os.FileMode, recursive bool) error {
+	if mode&^os.ModePerm != 0 {
+		return &Error{Path: path, Code: CodeInvalid, Message: "Only permission bits can be set"}
+	}
+	resolved, err := resolveEntry(root, path)
+	if err != nil {
+		return err
+	}
+	info, err := os.Lstat(resolved)
+	if err != nil {
+		return toError(path, err)
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		return &Error{Path: path, Code: CodeInvalid, Message: "Cannot change the mode of a symlink"}
+	}
+	if !recursive || !info.IsDir() {
+		return toError(path, os.Chmod(resolved, mode))
+	}
+	return toError(path, chmodTree(resolved, mode))
+}
+
+//Changes the mode of a directory and everything in it, leaving symlinks alone. Directories get the mode
+//after what is in them, as a mode without the execute bit stops them being read when not running as root.
+func chmodTree(file string, mode os.FileMode) error {
+	info, err := os.Lstat(file)
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		return nil
+	}
+	if !info.IsDir() {
+		return os.Chmod(file, mode)
+	}
+
+	//a directory left unreadable by an earlier change is opened up while it is walked
+	err = os.Chmod(file, info.Mode().Perm()|0700)
+	if err != nil {
+		return err
+	}
+	directory, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	names, err := directory.Readdirnames(-1)
+	directory.Close()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		err = chmodTree(filepath.Join(file, name), mode)
+		if err != nil {
+			return err
+		}
+	}
+	return os.Chmod(file, mode)
+}
+
+//Checks a path is an existing directory, as the target of several paths being moved or copied must be.
+func CheckDirectory(root string, path string) error {
+	resolved, err := Resolve(root, path)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(resolved)
+	if err != nil {
+		return toError(path, err)
+	}
+	if !info.IsDir() {
+		return &Error{Path: path, Code: CodeNotDirectory, Message: "Target is not a directory"}
+	}
+	return nil
+}
+
+//Applies an operation to each path in turn, returning the failures.
+func Each(paths []string, operation func(path string) error) []*Error {
+	errs := make([]*Error, 0)
+	for _, path := range paths {
+		if err := operation(path); err != nil {
+			errs = append(errs, toError(path, err).(*Error))
+		}
+	}
+	return errs
+}
+
+//Converts an error from the filesystem to an Error for the path, leaving nil and Errors as they are.
+func toError(path string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(*Error); ok {
+		return err
+	}
+	switch {
+	case os.IsNotExist(err):
+		return &Error{Path: path, Code: CodeNotFound, Message: "File does not exist"}
+	case os.IsExist(err):
+		return &Error{Path: path, Code: CodeExists, Message: "File already exists"}
+	case os.IsPermission(err):
+		return &Error{Path: path, Code: CodePermissionDenied, Message: "Permission denied"}
+	case err == quota.ErrExceeded:
+		return &Error{Path: path, Code: CodeQuotaExceeded, Message: "Disk quota exceeded"}
+	}
+	return &Error{Path: path, Code: CodeFailed, Message: err.Error()}
+}
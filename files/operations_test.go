@@ -0,0 +1,111 @@
+/*
+ Copyright 2016 Padduck, LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+ 	http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package files_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pufferpanel/pufferd/files"
+)
+
+func TestOperations(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pufferd-files")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	root := filepath.Join(dir, "server")
+	os.MkdirAll(filepath.Join(root, "world", "region"), 0755)
+	ioutil.WriteFile(filepath.Join(root, "world", "level.dat"), []byte("level"), 0644)
+	ioutil.WriteFile(filepath.Join(root, "server.properties"), []byte("motd=A server"), 0644)
+	os.Mkdir(filepath.Join(dir, "other"), 0755)
+
+	expectCode(t, files.Mkdir(root, "plugins/config"), "")
+	expectCode(t, files.Mkdir(root, "server.properties"), files.CodeExists)
+
+	expectCode(t, files.Copy(root, "world", "backup", nil), "")
+	if data, _ := ioutil.ReadFile(filepath.Join(root, "backup", "level.dat")); string(data) != "level" {
+		t.Errorf("Unexpected copied contents %q", data)
+	}
+	expectCode(t, files.Copy(root, "server.properties", "backup", nil), "")
+	if data, _ := ioutil.ReadFile(filepath.Join(root, "backup", "server.properties")); string(data) != "motd=A server" {
+		t.Errorf("Unexpected copied contents %q", data)
+	}
+	expectCode(t, files.Copy(root, "server.properties", "backup", nil), files.CodeExists)
+	expectCode(t, files.Copy(root, "world", "world/region", nil), files.CodeInvalid)
+
+	expectCode(t, files.Move(root, "backup", "plugins"), "")
+	if _, err := os.Stat(filepath.Join(root, "plugins", "backup", "level.dat")); err != nil {
+		t.Error("Expected the directory to be moved into the target directory")
+	}
+	expectCode(t, files.Move(root, "missing", "other"), files.CodeNotFound)
+	expectCode(t, files.Move(root, "server.properties", "../other/server.properties"), files.CodeOutsideRoot)
+
+	expectCode(t, files.Chmod(root, "world", 0700, true), "")
+	if info, _ := os.Stat(filepath.Join(root, "world", "level.dat")); info.Mode().Perm() != 0700 {
+		t.Errorf("Expected mode 0700 but got %v", info.Mode().Perm())
+	}
+	expectCode(t, files.Chmod(root, "world", os.ModeSetuid|0755, false), files.CodeInvalid)
+	//without the execute bit on directories, they must still be walked
+	expectCode(t, files.Chmod(root, "world", 0644, true), "")
+	expectCode(t, files.Chmod(root, "world", 0755, false), "")
+	for _, name := range []string{"world/region", "world/level.dat"} {
+		if info, err := os.Lstat(filepath.Join(root, filepath.FromSlash(name))); err != nil || info.Mode().Perm() != 0644 {
+			t.Errorf("Expected mode 0644 on %s but got %v, %v", name, info, err)
+		}
+	}
+	expectCode(t, files.Chmod(root, "world", 0755, true), "")
+
+	errs := files.Each([]string{"plugins", "missing", "/"}, func(path string) error {
+		return files.Delete(root, path)
+	})
+	if len(errs) != 2 || errs[0].Code != files.CodeNotFound || errs[1].Code != files.CodeInvalid {
+		t.Errorf("Unexpected errors %+v", errs)
+	}
+	if _, err := os.Stat(filepath.Join(root, "plugins")); !os.IsNotExist(err) {
+		t.Error("Expected the directory to be deleted")
+	}
+	expectCode(t, files.Delete(root, "../other"), files.CodeOutsideRoot)
+
+	os.Mkdir(filepath.Join(dir, "server2"), 0755)
+	os.Symlink("../other", filepath.Join(root, "escape"))
+	expectCode(t, files.Mkdir(root, "../server2/plugins"), files.CodeOutsideRoot)
+	expectCode(t, files.Copy(root, "server.properties", "escape", nil), files.CodeOutsideRoot)
+	expectCode(t, files.Delete(root, "escape"), "")
+	if _, err := os.Stat(filepath.Join(dir, "other")); err != nil {
+		t.Error("Expected deleting a symlink to leave its target")
+	}
+}
+
+func expectCode(t *testing.T, err error, code string) {
+	t.Helper()
+	actual := ""
+	if err != nil {
+		fileErr, ok := err.(*files.Error)
+		if !ok {
+			t.Errorf("Expected a files.Error but got %v", err)
+			return
+		}
+		actual = fileErr.Code
+	}
+	if actual != code {
+		t.Errorf("Expected code %q but got %q (%v)", code, actual, err)
+	}
+}
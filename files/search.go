@@ -0,0 +1,251 @@
+/*
+ Copyright 2016 Padduck, LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+ 	http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package files
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pufferpanel/pufferd/confined"
+	"github.com/pufferpanel/pufferd/utils"
+)
+
+const (
+	defaultSearchFileSize = 1 << 20
+	maxSearchFileSize     = 16 << 20
+	defaultSearchResults  = 100
+	maxSearchResults      = 1000
+	defaultSearchTimeout  = 10
+	maxSearchTimeout      = 60
+	defaultSearchContext  = 2
+	maxSearchContext      = 10
+	//matched lines longer than this are cut short in the results
+	maxSearchLineLength = 1000
+	//files with a zero byte near the start are taken to be binary
+	binaryCheckLength = 8000
+)
+
+var errSearchStopped = errors.New("Search stopped")
+
+//What to search for under the directory given by Path, which defaults to the server root.
+//Name is a glob matched against file names, or against paths relative to the directory if it contains a slash,
+//and Content a regex matched against each line. Context is how many lines either side of a match are returned,
+//with a negative value using the default. Limits which are zero or too large use the defaults and maximums.
+type SearchOptions struct {
+	Path        string
+	Name        string
+	Content     string
+	Context     int
+	MaxFileSize int64
+	MaxResults  int
+	Timeout     int
+}
+
+//The files found, with the lines matching the content regex if one was given.
+//Truncated is set when the result limit was reached and TimedOut when the time limit was,
+//and Skipped counts files whose contents were not searched for being too large or binary.
+type SearchResult struct {
+	Files     []*FileMatch `json:"files"`
+	Truncated bool         `json:"truncated"`
+	TimedOut  bool         `json:"timedOut"`
+	Skipped   int          `json:"skipped"`
+}
+
+type FileMatch struct {
+	Path    string      `json:"path"`
+	Size    int64       `json:"size"`
+	Matches []LineMatch `json:"matches,omitempty"`
+}
+
+//A matching line, numbered from 1, with the lines around it.
+type LineMatch struct {
+	Line   int      `json:"line"`
+	Text   string   `json:"text"`
+	Before []string `json:"before,omitempty"`
+	After  []string `json:"after,omitempty"`
+}
+
+//Searches the files under a directory of the root by name and content. Symlinks are never followed,
+//and only regular files are searched. Results count files, or matched lines with a content regex.
+func Search(root string, options SearchOptions) (*SearchResult, error) {
+	if options.Name == "" && options.Content == "" {
+		return nil, &Error{Code: CodeInvalid, Message: "A name or content to search for is required"}
+	}
+	if !utils.ValidGlob(options.Name) {
+		return nil, &Error{Code: CodeInvalid, Message: "Invalid name pattern " + options.Name}
+	}
+	var content *regexp.Regexp
+	if options.Content != "" {
+		var err error
+		content, err = regexp.Compile(options.Content)
+		if err != nil {
+			return nil, &Error{Code: CodeInvalid, Message: "Invalid content regex: " + err.Error()}
+		}
+	}
+	options.MaxFileSize = limit(options.MaxFileSize, defaultSearchFileSize, maxSearchFileSize)
+	options.MaxResults = int(limit(int64(options.MaxResults), defaultSearchResults, maxSearchResults))
+	options.Timeout = int(limit(int64(options.Timeout), defaultSearchTimeout, maxSearchTimeout))
+	if options.Context < 0 {
+		options.Context = defaultSearchContext
+	} else if options.Context > maxSearchContext {
+		options.Context = maxSearchContext
+	}
+
+	directory, err := Resolve(root, options.Path)
+	if err != nil {
+		return nil, err
+	}
+	if info, err := os.Stat(directory); err != nil {
+		return nil, toError(options.Path, err)
+	} else if !info.IsDir() {
+		return nil, &Error{Path: options.Path, Code: CodeNotDirectory, Message: "Path is not a directory"}
+	}
+	fs := confined.New(root)
+	base := fs.Root()
+
+	result := &SearchResult{Files: make([]*FileMatch, 0)}
+	deadline := time.Now().Add(time.Duration(options.Timeout) * time.Second)
+	count := 0
+	err = filepath.Walk(directory, func(file string, info os.FileInfo, err error) error {
+		if time.Now().After(deadline) {
+			result.TimedOut = true
+			return errSearchStopped
+		}
+		if err != nil || !info.Mode().IsRegular() {
+			//unreadable directories are left out rather than failing the search
+			return nil
+		}
+		relative, _ := filepath.Rel(directory, file)
+		relative = filepath.ToSlash(relative)
+		if options.Name != "" {
+			name := info.Name()
+			if strings.Contains(options.Name, "/") {
+				name = relative
+			}
+			if !utils.MatchGlob(options.Name, name) {
+				return nil
+			}
+		}
+
+		rootRelative, _ := filepath.Rel(base, file)
+		match := &FileMatch{Path: filepath.ToSlash(rootRelative), Size: info.Size()}
+		if content == nil {
+			result.Files = append(result.Files, match)
+			count++
+		} else {
+			if info.Size() > options.MaxFileSize {
+				result.Skipped++
+				return nil
+			}
+			//read through the root, so a file swapped for a symlink during the walk cannot lead out of it
+			data, err := fs.ReadFile(rootRelative)
+			if err != nil {
+				return nil
+			}
+			if bytes.IndexByte(data[:minInt(len(data), binaryCheckLength)], 0) >= 0 {
+				result.Skipped++
+				return nil
+			}
+			match.Matches = searchLines(data, content, options.Context, options.MaxResults-count)
+			if len(match.Matches) == 0 {
+				return nil
+			}
+			result.Files = append(result.Files, match)
+			count += len(match.Matches)
+		}
+		if count >= options.MaxResults {
+			result.Truncated = true
+			return errSearchStopped
+		}
+		return nil
+	})
+	if err != nil && err != errSearchStopped {
+		return nil, toError(options.Path, err)
+	}
+	return result, nil
+}
+
+//Returns up to max lines matching the regex, with context lines either side.
+func searchLines(data []byte, content *regexp.Regexp, context int, max int) []LineMatch {
+	//a final newline ends the last line rather than starting another
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSuffix(line, "\r")
+	}
+
+	matches := make([]LineMatch, 0)
+	for i, line := range lines {
+		if len(matches) >= max {
+			break
+		}
+		if !content.MatchString(line) {
+			continue
+		}
+		matches = append(matches, LineMatch{
+			Line:   i + 1,
+			Text:   shorten(line),
+			Before: shortenAll(lines[maxInt(0, i-context):i]),
+			After:  shortenAll(lines[i+1 : minInt(len(lines), i+1+context)]),
+		})
+	}
+	return matches
+}
+
+func shorten(line string) string {
+	if len(line) > maxSearchLineLength {
+		return line[:maxSearchLineLength]
+	}
+	return line
+}
+
+func shortenAll(lines []string) []string {
+	result := make([]string, len(lines))
+	for i, line := range lines {
+		result[i] = shorten(line)
+	}
+	return result
+}
+
+//Returns the value, or the default if it is not positive, capped at the maximum.
+func limit(value int64, defaultValue int64, max int64) int64 {
+	if value <= 0 {
+		return defaultValue
+	}
+	if value > max {
+		return max
+	}
+	return value
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,77 @@
+/*
+ Copyright 2016 Padduck, LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+ 	http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package files_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/pufferpanel/pufferd/files"
+)
+
+func TestSearch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pufferd-search")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	root := filepath.Join(dir, "server")
+	os.MkdirAll(filepath.Join(root, "plugins", "Essentials"), 0755)
+	ioutil.WriteFile(filepath.Join(root, "server.properties"), []byte("motd=A server\r\nmax-players=20\r\npvp=true\r\n"), 0644)
+	ioutil.WriteFile(filepath.Join(root, "plugins", "Essentials", "config.yml"), []byte("spawn: world\nmax-players: 50\nkits: {}\n"), 0644)
+	ioutil.WriteFile(filepath.Join(root, "plugins", "Essentials.jar"), []byte("PK\x03\x04\x00max-players"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "secret.yml"), []byte("max-players: 1"), 0644)
+	os.Symlink(filepath.Join(dir, "secret.yml"), filepath.Join(root, "plugins", "secret.yml"))
+
+	result, err := files.Search(root, files.SearchOptions{Content: "max-players", Context: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []*files.FileMatch{
+		{Path: "plugins/Essentials/config.yml", Size: 38, Matches: []files.LineMatch{{Line: 2, Text: "max-players: 50", Before: []string{"spawn: world"}, After: []string{"kits: {}"}}}},
+		{Path: "server.properties", Size: 41, Matches: []files.LineMatch{{Line: 2, Text: "max-players=20", Before: []string{"motd=A server"}, After: []string{"pvp=true"}}}},
+	}
+	if !reflect.DeepEqual(result.Files, expected) || result.Skipped != 1 {
+		t.Errorf("Unexpected result %+v", result)
+	}
+
+	result, err = files.Search(root, files.SearchOptions{Path: "plugins", Name: "*.yml", Context: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Files) != 1 || result.Files[0].Path != "plugins/Essentials/config.yml" {
+		t.Errorf("Unexpected files found by name %+v", result.Files)
+	}
+
+	result, err = files.Search(root, files.SearchOptions{Content: "=", MaxResults: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Truncated || len(result.Files) != 1 || len(result.Files[0].Matches) != 2 {
+		t.Errorf("Expected the search to stop after 2 results but got %+v", result)
+	}
+
+	if _, err = files.Search(root, files.SearchOptions{Content: "("}); err == nil {
+		t.Error("Expected an invalid regex to fail")
+	}
+	if _, err = files.Search(root, files.SearchOptions{Path: "../", Name: "*"}); err == nil {
+		t.Error("Expected a search outside the root to fail")
+	}
+}
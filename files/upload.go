@@ -0,0 +1,280 @@
+/*
+ Copyright 2016 Padduck, LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+ 	http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package files
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pufferpanel/pufferd/quota"
+)
+
+//How long an upload can go without a chunk before it is abandoned.
+var UploadExpiry = 24 * time.Hour
+
+//An upload of a file in chunks, which can be resumed from the bytes received after a connection drops.
+//The data is kept in a hidden file next to the target, so committing it is a rename.
+type Upload struct {
+	Id       string    `json:"id"`
+	Server   string    `json:"server"`
+	Path     string    `json:"path"`
+	Size     int64     `json:"size,omitempty"`
+	Received int64     `json:"received"`
+	Updated  time.Time `json:"updated"`
+
+	target string
+	data   string
+	quota  *quota.Quota
+	lock   sync.Mutex
+}
+
+var (
+	uploads     = make(map[string]*Upload)
+	uploadsLock sync.Mutex
+)
+
+//Starts an upload to a path under the root. Size is the expected size of the file, or 0 if it is not known.
+//The received bytes count towards the quota, if there is one, so an upload of a known size which
+//does not fit is refused straight away.
+func CreateUpload(server string, root string, path string, size int64, limit *quota.Quota) (*Upload, error) {
+	if size < 0 {
+		return nil, &Error{Path: path, Code: CodeInvalid, Message: "Size must be at least 0"}
+	}
+	if err := limit.Check(size); err != nil {
+		return nil, toError(path, err)
+	}
+	target, err := resolveEntry(root, path)
+	if err != nil {
+		return nil, err
+	}
+	if info, err := os.Lstat(target); err == nil && info.IsDir() {
+		return nil, &Error{Path: path, Code: CodeExists, Message: "A directory is in the way"}
+	}
+	if info, err := os.Stat(filepath.Dir(target)); err != nil || !info.IsDir() {
+		return nil, &Error{Path: path, Code: CodeNotDirectory, Message: "Parent of the target is not a directory"}
+	}
+	expireUploads()
+
+	bytes := make([]byte, 16)
+	rand.Read(bytes)
+	id := hex.EncodeToString(bytes)
+	data := filepath.Join(filepath.Dir(target), "."+filepath.Base(target)+".upload-"+id)
+	file, err := os.OpenFile(data, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return nil, toError(path, err)
+	}
+	file.Close()
+
+	upload := &Upload{Id: id, Server: server, Path: path, Size: size, Updated: time.Now(), target: target, data: data, quota: limit}
+	uploadsLock.Lock()
+	uploads[id] = upload
+	uploadsLock.Unlock()
+	return upload, nil
+}
+
+//Returns an upload to a server, or nil if there is none with the id.
+func GetUpload(server string, id string) *Upload {
+	uploadsLock.Lock()
+	defer uploadsLock.Unlock()
+	upload := uploads[id]
+	if upload == nil || upload.Server != server {
+		return nil
+	}
+	return upload
+}
+
+//Returns a copy of the upload which can be read while chunks are written.
+func (u *Upload) Progress() Upload {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	return Upload{Id: u.Id, Server: u.Server, Path: u.Path, Size: u.Size, Received: u.Received, Updated: u.Updated}
+}
+
+//Writes a chunk at an offset, which cannot be past the bytes received so far.
+//If the chunk is cut short, the bytes which arrived are kept so the upload can resume from them.
+func (u *Upload) Write(offset int64, chunk io.Reader) (int64, error) {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	if u.data == "" {
+		return 0, &Error{Path: u.Path, Code: CodeNotFound, Message: "Upload has finished"}
+	}
+	if offset < 0 || offset > u.Received {
+		return u.Received, &Error{Path: u.Path, Code: CodeInvalid, Message: "Offset must be between 0 and " + strconv.FormatInt(u.Received, 10)}
+	}
+
+	file, err := os.OpenFile(u.data, os.O_WRONLY, 0)
+	if err != nil {
+		return u.Received, toError(u.Path, err)
+	}
+	defer file.Close()
+	_, err = file.Seek(offset, io.SeekStart)
+	if err != nil {
+		return u.Received, toError(u.Path, err)
+	}
+	if u.Size > 0 {
+		//one byte over the limit is read, so a chunk which is too large is noticed
+		chunk = io.LimitReader(chunk, u.Size-offset+1)
+	}
+	//bytes rewritten before those received so far are already counted
+	written, err := io.Copy(u.quota.Writer(file, u.Received-offset), chunk)
+	if u.Size > 0 && offset+written > u.Size {
+		written = u.Size - offset
+		file.Truncate(u.Size)
+		err = &Error{Path: u.Path, Code: CodeInvalid, Message: "Chunk goes past the size of the upload"}
+	}
+	if offset+written > u.Received {
+		u.quota.Add(offset + written - u.Received)
+		u.Received = offset + written
+	}
+	u.Updated = time.Now()
+	if err != nil {
+		return u.Received, toError(u.Path, err)
+	}
+	return u.Received, nil
+}
+
+//Replaces the target with the uploaded file and ends the upload. If a sha256 checksum is given the file
+//must match it, and if the upload was given a size the file must be complete.
+func (u *Upload) Commit(checksum string) error {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	if u.data == "" {
+		return &Error{Path: u.Path, Code: CodeNotFound, Message: "Upload has finished"}
+	}
+	if u.Size > 0 && u.Received != u.Size {
+		return &Error{Path: u.Path, Code: CodeInvalid, Message: "Upload is incomplete, " + strconv.FormatInt(u.Received, 10) + " of " + strconv.FormatInt(u.Size, 10) + " bytes received"}
+	}
+
+	file, err := os.OpenFile(u.data, os.O_RDWR, 0)
+	if err != nil {
+		return toError(u.Path, err)
+	}
+	//a chunk rewritten with fewer bytes leaves nothing past the bytes received
+	err = file.Truncate(u.Received)
+	if err == nil && checksum != "" {
+		hash := sha256.New()
+		_, err = io.Copy(hash, file)
+		if err == nil && !strings.EqualFold(hex.EncodeToString(hash.Sum(nil)), checksum) {
+			err = &Error{Path: u.Path, Code: CodeInvalid, Message: "Checksum does not match"}
+		}
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return toError(u.Path, err)
+	}
+
+	//the file being replaced keeps its permissions, and frees its space
+	var replaced int64
+	if info, err := os.Lstat(u.target); err == nil && info.Mode().IsRegular() {
+		os.Chmod(u.data, info.Mode().Perm())
+		replaced = info.Size()
+	}
+	err = os.Rename(u.data, u.target)
+	if err != nil {
+		return toError(u.Path, err)
+	}
+	u.quota.Add(-replaced)
+	u.finish()
+	return nil
+}
+
+//Ends the upload, deleting what was received.
+func (u *Upload) Abort() error {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	if u.data == "" {
+		return &Error{Path: u.Path, Code: CodeNotFound, Message: "Upload has finished"}
+	}
+	os.Remove(u.data)
+	u.quota.Add(-u.Received)
+	u.finish()
+	return nil
+}
+
+//Forgets the upload. The caller must hold its lock.
+func (u *Upload) finish() {
+	u.data = ""
+	uploadsLock.Lock()
+	delete(uploads, u.Id)
+	uploadsLock.Unlock()
+}
+
+func expireUploads() {
+	uploadsLock.Lock()
+	expired := make([]*Upload, 0)
+	for _, upload := range uploads {
+		expired = append(expired, upload)
+	}
+	uploadsLock.Unlock()
+
+	for _, upload := range expired {
+		if time.Since(upload.Progress().Updated) > UploadExpiry {
+			upload.Abort()
+		}
+	}
+}
+
+//Writes a file under the root from a reader, replacing the file only once everything has been read,
+//so a failed write leaves the existing file as it was. The new file must fit in the quota, if there is one.
+func WriteFile(root string, path string, contents io.Reader, limit *quota.Quota) error {
+	target, err := resolveEntry(root, path)
+	if err != nil {
+		return err
+	}
+	mode := os.FileMode(0644)
+	var replaced int64
+	if info, err := os.Lstat(target); err == nil && info.IsDir() {
+		return &Error{Path: path, Code: CodeExists, Message: "A directory is in the way"}
+	} else if err == nil && info.Mode().IsRegular() {
+		mode = info.Mode().Perm()
+		replaced = info.Size()
+	}
+	file, err := ioutil.TempFile(filepath.Dir(target), "."+filepath.Base(target)+".tmp")
+	if err != nil {
+		return toError(path, err)
+	}
+	written, err := io.Copy(limit.Writer(file, replaced), contents)
+	if err == nil {
+		err = file.Chmod(mode)
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), target)
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return toError(path, err)
+	}
+	limit.Add(written - replaced)
+	return nil
+}
@@ -0,0 +1,113 @@
+/*
+ Copyright 2016 Padduck, LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+ 	http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package files_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pufferpanel/pufferd/files"
+	"github.com/pufferpanel/pufferd/quota"
+)
+
+func TestUpload(t *testing.T) {
+	root, err := ioutil.TempDir("", "pufferd-upload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	target := filepath.Join(root, "world.zip")
+	ioutil.WriteFile(target, []byte("old world"), 0600)
+	contents := "the new world, uploaded in chunks"
+
+	upload, err := files.CreateUpload("server", root, "world.zip", int64(len(contents)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files.GetUpload("other", upload.Id) != nil {
+		t.Error("Expected the upload to belong to its server only")
+	}
+
+	//the connection drops after 10 bytes
+	received, err := upload.Write(0, io.MultiReader(strings.NewReader(contents[:10]), &failingReader{}))
+	if err == nil || received != 10 {
+		t.Fatalf("Expected a failed chunk with 10 bytes received but got %d, %v", received, err)
+	}
+	if data, _ := ioutil.ReadFile(target); string(data) != "old world" {
+		t.Errorf("Expected the target to be untouched but found %q", data)
+	}
+	if _, err = upload.Write(20, strings.NewReader(contents[20:])); err == nil {
+		t.Error("Expected a chunk past the bytes received to be rejected")
+	}
+	if err = upload.Commit(""); err == nil {
+		t.Error("Expected an incomplete upload to fail to commit")
+	}
+
+	received, err = upload.Write(10, strings.NewReader(contents[10:]+"extra"))
+	if err == nil || received != int64(len(contents)) {
+		t.Errorf("Expected a chunk going past the size to be cut short but got %d, %v", received, err)
+	}
+	if err = upload.Commit(strings.Repeat("0", 64)); err == nil {
+		t.Error("Expected a wrong checksum to fail")
+	}
+	sum := sha256.Sum256([]byte(contents))
+	if err = upload.Commit(hex.EncodeToString(sum[:])); err != nil {
+		t.Fatal(err)
+	}
+
+	data, _ := ioutil.ReadFile(target)
+	if string(data) != contents {
+		t.Errorf("Unexpected contents %q", data)
+	}
+	if info, _ := os.Stat(target); info.Mode().Perm() != 0600 {
+		t.Errorf("Expected the mode of the replaced file to be kept but got %v", info.Mode().Perm())
+	}
+	if entries, _ := ioutil.ReadDir(root); len(entries) != 1 {
+		t.Errorf("Expected only the target to be left but found %d files", len(entries))
+	}
+	if files.GetUpload("server", upload.Id) != nil {
+		t.Error("Expected the upload to be gone after it was committed")
+	}
+
+	limit := quota.New(root, int64(len(contents))+10, true)
+	err = files.WriteFile(root, "big.dat", strings.NewReader(strings.Repeat("a", 11)), limit)
+	if fileErr, ok := err.(*files.Error); !ok || fileErr.Code != files.CodeQuotaExceeded {
+		t.Errorf("Expected a write past the quota to fail but got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "big.dat")); !os.IsNotExist(err) {
+		t.Error("Expected nothing to be written past the quota")
+	}
+	if err = files.WriteFile(root, "world.zip", strings.NewReader(contents+"0123456789"), limit); err != nil {
+		t.Errorf("Expected replacing a file to count only the growth but got %v", err)
+	}
+	if _, err = files.CreateUpload("server", root, "other.zip", 1, limit); err == nil {
+		t.Error("Expected an upload which does not fit to be refused")
+	}
+}
+
+type failingReader struct{}
+
+func (f *failingReader) Read(data []byte) (int, error) {
+	return 0, errors.New("connection reset")
+}
@@ -63,7 +63,13 @@ func InstallService(configPath string) {
 	updates := make(map[string]interface{})
 	updates["configpath"] = configPath
 
-	err = ioutil.WriteFile("/etc/systemd/system/pufferd.service", []byte(utils.ReplaceTokens(SYSTEMD, updates)), 0664)
+	service, err := utils.ReplaceTokens(SYSTEMD, updates)
+	if err != nil {
+		logging.Error("Error generating systemd file, will not install service", err)
+		return
+	}
+
+	err = ioutil.WriteFile("/etc/systemd/system/pufferd.service", []byte(service), 0664)
 	if err != nil {
 		logging.Error("Cannot write systemd file, will not install service", err)
 		return
@@ -0,0 +1,178 @@
+/*
+ Copyright 2016 Padduck, LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+ 	http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+//Tracks long running operations, such as cloning a server, so their progress can be followed over the API.
+package jobs
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+const (
+	StatusRunning   = "running"
+	StatusCompleted = "completed"
+	StatusFailed    = "failed"
+)
+
+//How long a finished job can still be looked up.
+var Retention = time.Hour
+
+type Job struct {
+	id       string
+	jobType  string
+	serverId string
+	status   string
+	message  string
+	err      string
+	done     int64
+	total    int64
+	started  time.Time
+	finished time.Time
+	updated  chan struct{}
+	lock     sync.Mutex
+}
+
+//The state of a job at a point in time, as returned by the API.
+type Status struct {
+	Id       string     `json:"id"`
+	Type     string     `json:"type"`
+	Server   string     `json:"server"`
+	Status   string     `json:"status"`
+	Message  string     `json:"message,omitempty"`
+	Error    string     `json:"error,omitempty"`
+	Done     int64      `json:"done"`
+	Total    int64      `json:"total"`
+	Started  time.Time  `json:"started"`
+	Finished *time.Time `json:"finished,omitempty"`
+}
+
+var (
+	jobs     = make(map[string]*Job)
+	jobsLock sync.RWMutex
+)
+
+//Creates a running job of the given type for a server.
+func Create(jobType string, serverId string) *Job {
+	bytes := make([]byte, 16)
+	rand.Read(bytes)
+	job := &Job{
+		id:       hex.EncodeToString(bytes),
+		jobType:  jobType,
+		serverId: serverId,
+		status:   StatusRunning,
+		started:  time.Now(),
+		updated:  make(chan struct{}),
+	}
+
+	jobsLock.Lock()
+	jobs[job.id] = job
+	jobsLock.Unlock()
+	return job
+}
+
+func Get(id string) *Job {
+	jobsLock.RLock()
+	defer jobsLock.RUnlock()
+	return jobs[id]
+}
+
+func (j *Job) Id() string {
+	return j.id
+}
+
+func (j *Job) ServerId() string {
+	return j.serverId
+}
+
+//Records how much of the work is done. Total can be 0 if the amount of work is not known.
+func (j *Job) SetProgress(done, total int64) {
+	j.update(func() {
+		j.done = done
+		j.total = total
+	})
+}
+
+func (j *Job) SetMessage(message string) {
+	j.update(func() {
+		j.message = message
+	})
+}
+
+func (j *Job) Complete() {
+	j.finish(StatusCompleted, nil)
+}
+
+func (j *Job) Fail(err error) {
+	j.finish(StatusFailed, err)
+}
+
+func (j *Job) Status() Status {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	status := Status{
+		Id:      j.id,
+		Type:    j.jobType,
+		Server:  j.serverId,
+		Status:  j.status,
+		Message: j.message,
+		Error:   j.err,
+		Done:    j.done,
+		Total:   j.total,
+		Started: j.started,
+	}
+	if !j.finished.IsZero() {
+		finished := j.finished
+		status.Finished = &finished
+	}
+	return status
+}
+
+//Returns a channel which is closed the next time the job changes.
+func (j *Job) Updated() <-chan struct{} {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	return j.updated
+}
+
+func (j *Job) finish(status string, err error) {
+	j.update(func() {
+		j.status = status
+		j.finished = time.Now()
+		if err != nil {
+			j.err = err.Error()
+		}
+	})
+
+	time.AfterFunc(Retention, func() {
+		jobsLock.Lock()
+		delete(jobs, j.id)
+		jobsLock.Unlock()
+	})
+}
+
+func (j *Job) update(change func()) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	if j.status != StatusRunning {
+		return
+	}
+	change()
+	close(j.updated)
+	j.updated = make(chan struct{})
+}
@@ -68,7 +68,10 @@ func MigrateFromScales() {
 			serverData["gametype"] = scales.Startup.Variables.Game
 			serverData["map"] = scales.Startup.Variables.Map
 		}
-		programs.Create(scales.Name, scales.Plugin, serverData)
+		err = programs.Create(scales.Name, scales.Plugin, serverData)
+		if err != nil {
+			logging.Error("Error creating server "+scales.Name, err)
+		}
 	}
 	logging.Info("Migration complete, please restart pufferd to have it recognize the changes");
 }
@@ -0,0 +1,204 @@
+/*
+ Copyright 2016 Padduck, LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+ 	http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package programs
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pufferpanel/pufferd/jobs"
+	"github.com/pufferpanel/pufferd/logging"
+	"github.com/pufferpanel/pufferd/utils"
+)
+
+//Creates a copy of a server under a new id, with the given variable values replacing those of the source.
+//Port variables which are not overridden are moved to free ports so the copy can run next to the source.
+//The new definition is checked before this returns, then the files are copied in the background
+//and the server is registered once the copy completes. Progress is reported through the returned job.
+func Clone(sourceId string, id string, overrides map[string]interface{}) (*jobs.Job, error) {
+	if !validId(id) {
+		return nil, utils.ValidationErrors{{Field: "id", Message: "Invalid server id"}}
+	}
+	existing := GetFromCache(sourceId)
+	if existing == nil {
+		return nil, errors.New("No server with given id")
+	}
+	source := existing.(*programData)
+
+	if !servers.reserve(id) {
+		return nil, utils.ValidationErrors{{Field: "id", Message: "Server already exists"}}
+	}
+
+	program, err := prepareClone(source, id, overrides)
+	if err != nil {
+		servers.release(id)
+		return nil, err
+	}
+
+	target := program.Environment.GetRootDirectory()
+	if _, err := os.Lstat(target); !os.IsNotExist(err) {
+		servers.release(id)
+		return nil, errors.New("Server directory " + target + " already exists")
+	}
+
+	job := jobs.Create("clone", sourceId)
+	job.SetMessage("Cloning " + sourceId + " to " + id)
+	go func() {
+		defer servers.release(id)
+		err := program.copyFrom(source.Environment.GetRootDirectory(), job)
+		if err != nil {
+			logging.Error("Error cloning server "+sourceId+" to "+id, err)
+			os.RemoveAll(target)
+			os.Remove(utils.JoinPath(ServerFolder, id+".json"))
+			job.Fail(err)
+			return
+		}
+		servers.add(program)
+		logging.Infof("Cloned server %s to %s", sourceId, id)
+		job.Complete()
+	}()
+	return job, nil
+}
+
+func prepareClone(source *programData, id string, overrides map[string]interface{}) (*programData, error) {
+	source.lock.Lock()
+	data, err := json.Marshal(source.definition())
+	source.lock.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	var definition Definition
+	err = json.Unmarshal(data, &definition)
+	if err != nil {
+		return nil, err
+	}
+
+	//a custom root belongs to the source, the clone gets its own folder
+	delete(definition.Environment, "root")
+
+	variables, errs := applyVariableValues(definition.Data, overrides)
+	if len(errs) == 0 {
+		errs = allocatePorts(variables, overrides)
+	}
+	errs = append(errs, ValidateVariables(variables)...)
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	definition.Data = variables
+
+	return newProgram(id, &definition), nil
+}
+
+func (p *programData) copyFrom(sourceRoot string, job *jobs.Job) error {
+	total, err := utils.DirectorySize(sourceRoot)
+	if err != nil {
+		return err
+	}
+	job.SetProgress(0, total)
+
+	err = utils.CopyDirectory(sourceRoot, p.Environment.GetRootDirectory(), func(copied int64) {
+		job.SetProgress(copied, total)
+	})
+	if err != nil {
+		return err
+	}
+	return p.Save(utils.JoinPath(ServerFolder, p.Id()+".json"))
+}
+
+//Moves port variables which were not given a value to the first port at or above their current value
+//which no other server uses and nothing on this machine is bound to.
+func allocatePorts(variables map[string]*Variable, keep map[string]interface{}) utils.ValidationErrors {
+	used := usedPorts()
+	names := make([]string, 0, len(variables))
+	for name, v := range variables {
+		if v.Type == VariablePort {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	errs := utils.ValidationErrors{}
+	for _, name := range names {
+		variable := variables[name]
+		value, err := variable.Coerce()
+		port, ok := value.(int64)
+		if err != nil || !ok {
+			//left for validation to report
+			continue
+		}
+		if _, overridden := keep[name]; overridden {
+			used[port] = true
+			continue
+		}
+
+		allocated := false
+		for candidate := port; candidate <= 65535; candidate++ {
+			if !used[candidate] && portAvailable(candidate) {
+				variable.Value = strconv.FormatInt(candidate, 10)
+				used[candidate] = true
+				allocated = true
+				break
+			}
+		}
+		if !allocated {
+			errs = append(errs, utils.ValidationError{Field: name, Message: "No free port available"})
+		}
+	}
+	return errs
+}
+
+func usedPorts() map[int64]bool {
+	used := make(map[int64]bool)
+	for _, program := range GetAll() {
+		for _, v := range program.GetData() {
+			if v.Type != VariablePort {
+				continue
+			}
+			if value, err := v.Coerce(); err == nil {
+				if port, ok := value.(int64); ok {
+					used[port] = true
+				}
+			}
+		}
+	}
+	return used
+}
+
+func portAvailable(port int64) bool {
+	address := ":" + strconv.FormatInt(port, 10)
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return false
+	}
+	listener.Close()
+	connection, err := net.ListenPacket("udp", address)
+	if err != nil {
+		return false
+	}
+	connection.Close()
+	return true
+}
+
+func validId(id string) bool {
+	return id != "" && !strings.ContainsAny(id, "/\\") && !strings.HasPrefix(id, ".")
+}
@@ -0,0 +1,83 @@
+/*
+ Copyright 2016 Padduck, LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+ 	http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package programs
+
+import (
+	"errors"
+
+	"github.com/pufferpanel/pufferd/logging"
+	"github.com/pufferpanel/pufferd/rcon"
+	"github.com/pufferpanel/pufferd/utils"
+)
+
+const (
+	TransportStdin = "stdin"
+	TransportRcon  = "rcon"
+	//commands go over RCON, falling back to stdin when RCON cannot be reached, such as while the server starts
+	TransportBoth = "both"
+)
+
+//How to reach the RCON server of a game. Each field can use variables, such as ${rconport}.
+type RconSettings struct {
+	Protocol string `json:"protocol"`
+	Port     string `json:"port"`
+	Password string `json:"password"`
+}
+
+//Sends a command over the command transport of the server.
+//The response is only known for commands sent over RCON, and is also shown on the console.
+func (p *programData) SendCommand(command string) (response string, err error) {
+	transport := p.RunData.CommandTransport
+	if p.RunData.Rcon == nil || (transport != TransportRcon && transport != TransportBoth) {
+		err = p.Environment.ExecuteInMainProcess(command)
+		return
+	}
+
+	client, err := p.dialRcon()
+	if err != nil {
+		if transport == TransportBoth {
+			logging.Debugf("Sending command to server %s over stdin as RCON failed: %s", p.Id(), err.Error())
+			return "", p.Environment.ExecuteInMainProcess(command)
+		}
+		return
+	}
+	defer client.Close()
+	//once the command has been written it may have run, so it is never sent again over stdin
+	response, err = client.Execute(command)
+	if response != "" {
+		p.Environment.DisplayToConsole(response + "\n")
+	}
+	return
+}
+
+//Opens a new RCON connection for a command, as a kept connection would not survive the server restarting.
+func (p *programData) dialRcon() (*rcon.Client, error) {
+	if !p.IsRunning() {
+		return nil, errors.New("Server is not running")
+	}
+	settings := p.RunData.Rcon
+	values := p.getVariableValues()
+	port, err := utils.ReplaceTokens(settings.Port, values)
+	if err != nil {
+		return nil, err
+	}
+	password, err := utils.ReplaceTokens(settings.Password, values)
+	if err != nil {
+		return nil, err
+	}
+	return rcon.Dial(settings.Protocol, p.probeAddress(port), password)
+}
@@ -0,0 +1,219 @@
+/*
+ Copyright 2016 Padduck, LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+ 	http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package programs
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pufferpanel/pufferd/programs/install"
+	"github.com/pufferpanel/pufferd/utils"
+)
+
+//The on-disk form of a server definition.
+type ServerDefinition struct {
+	Pufferd Definition `json:"pufferd"`
+}
+
+type Definition struct {
+	SchemaVersion int                    `json:"schemaVersion"`
+	Type          string                 `json:"type,omitempty"`
+	Display       string                 `json:"display,omitempty"`
+	Template      *TemplateOrigin        `json:"template,omitempty"`
+	Install       install.InstallSection `json:"install"`
+	Run           Runtime                `json:"run"`
+	Environment   map[string]interface{} `json:"environment,omitempty"`
+	Data          map[string]*Variable   `json:"data"`
+	Backup        *BackupSettings        `json:"backup,omitempty"`
+	Quota         *QuotaSettings         `json:"quota,omitempty"`
+	Schedules     map[string]Schedule    `json:"schedules,omitempty"`
+	Triggers      []Trigger              `json:"triggers,omitempty"`
+}
+
+const (
+	BackupFormatArchive  = "archive"
+	BackupFormatSnapshot = "snapshot"
+)
+
+//How backups of a server are taken and how many are kept.
+//Archives are a full tarball per backup, snapshots only store file chunks which no earlier snapshot stored.
+//Include and exclude are globs relative to the server root; with no includes everything is backed up.
+//Pre commands are sent to a running server before the backup starts, followed by a wait of Delay seconds,
+//and post commands are sent once it finishes.
+type BackupSettings struct {
+	Format    string          `json:"format,omitempty"`
+	Include   []string        `json:"include,omitempty"`
+	Exclude   []string        `json:"exclude,omitempty"`
+	Pre       []string        `json:"pre,omitempty"`
+	Post      []string        `json:"post,omitempty"`
+	Delay     int             `json:"delay,omitempty"`
+	Retention BackupRetention `json:"retention"`
+}
+
+//Backups beyond the newest Count, or older than Days, are deleted. Zero disables either limit.
+type BackupRetention struct {
+	Count int `json:"count,omitempty"`
+	Days  int `json:"days,omitempty"`
+}
+
+func (s *BackupSettings) Validate() utils.ValidationErrors {
+	errs := utils.ValidationErrors{}
+	if s.Format != "" && s.Format != BackupFormatArchive && s.Format != BackupFormatSnapshot {
+		errs = append(errs, utils.ValidationError{Field: "format", Message: "Value " + s.Format + " must be one of archive, snapshot"})
+	}
+	for field, patterns := range map[string][]string{"include": s.Include, "exclude": s.Exclude} {
+		for i, pattern := range patterns {
+			if !utils.ValidGlob(pattern) {
+				errs = append(errs, utils.ValidationError{Field: field + "[" + strconv.Itoa(i) + "]", Message: "Invalid pattern " + pattern})
+			}
+		}
+	}
+	if s.Delay < 0 {
+		errs = append(errs, utils.ValidationError{Field: "delay", Message: "Value must be at least 0"})
+	}
+	if s.Retention.Count < 0 {
+		errs = append(errs, utils.ValidationError{Field: "retention.count", Message: "Value must be at least 0"})
+	}
+	if s.Retention.Days < 0 {
+		errs = append(errs, utils.ValidationError{Field: "retention.days", Message: "Value must be at least 0"})
+	}
+	sort.Slice(errs, func(i, j int) bool {
+		return errs[i].Field < errs[j].Field
+	})
+	return errs
+}
+
+const (
+	ActionStart   = "start"
+	ActionStop    = "stop"
+	ActionRestart = "restart"
+	ActionCommand = "command"
+	ActionInstall = "install"
+	ActionBackup  = "backup"
+)
+
+//Actions run in order when the cron expression matches. With SkipOffline set, runs are skipped while the server is stopped.
+type Schedule struct {
+	Cron        string           `json:"cron"`
+	Enabled     bool             `json:"enabled"`
+	SkipOffline bool             `json:"skipOffline,omitempty"`
+	Actions     []ScheduleAction `json:"actions"`
+}
+
+//A step of a schedule, run after waiting Delay seconds.
+type ScheduleAction struct {
+	Type    string `json:"type"`
+	Command string `json:"command,omitempty"`
+	Delay   int    `json:"delay,omitempty"`
+}
+
+func (s *Schedule) Validate() utils.ValidationErrors {
+	errs := utils.ValidationErrors{}
+	if _, err := utils.ParseCron(s.Cron); err != nil {
+		errs = append(errs, utils.ValidationError{Field: "cron", Message: err.Error()})
+	}
+	if len(s.Actions) == 0 {
+		errs = append(errs, utils.ValidationError{Field: "actions", Message: "At least one action is required"})
+	}
+	for i, action := range s.Actions {
+		field := "actions[" + strconv.Itoa(i) + "]"
+		switch action.Type {
+		case ActionStart, ActionStop, ActionRestart, ActionInstall, ActionBackup:
+		case ActionCommand:
+			if action.Command == "" {
+				errs = append(errs, utils.ValidationError{Field: field + ".command", Message: "Value is required"})
+			}
+		default:
+			errs = append(errs, utils.ValidationError{Field: field + ".type", Message: "Value " + action.Type + " must be one of start, stop, restart, command, install, backup"})
+		}
+		if action.Delay < 0 {
+			errs = append(errs, utils.ValidationError{Field: field + ".delay", Message: "Value must be at least 0"})
+		}
+	}
+	return errs
+}
+
+//Decodes a schedule, treating a missing enabled as true.
+func (s *Schedule) UnmarshalJSON(data []byte) error {
+	type schedule Schedule
+	decoded := schedule{Enabled: true}
+	err := json.Unmarshal(data, &decoded)
+	*s = Schedule(decoded)
+	return err
+}
+
+//Checks a schedule name can be used in the API and definition.
+func ValidScheduleName(name string) bool {
+	if name == "" || len(name) > 64 {
+		return false
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+//Decodes a runtime section, treating a missing enabled or autostart as true.
+func (r *Runtime) UnmarshalJSON(data []byte) error {
+	type runtime Runtime
+	decoded := runtime{Enabled: true, AutoStart: true}
+	err := json.Unmarshal(data, &decoded)
+	*r = Runtime(decoded)
+	return err
+}
+
+//Decodes an upgraded and validated definition into its typed form.
+//Type mismatches are reported with the path of the field.
+func decodeDefinition(source map[string]interface{}) (*ServerDefinition, error) {
+	data, err := json.Marshal(source)
+	if err != nil {
+		return nil, err
+	}
+
+	definition := &ServerDefinition{}
+	err = json.Unmarshal(data, definition)
+	if typeErr, ok := err.(*json.UnmarshalTypeError); ok {
+		return nil, utils.ValidationErrors{{Field: toJsonPath(typeErr.Field), Message: "Expected " + typeErr.Type.String() + " but found " + typeErr.Value}}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if definition.Pufferd.Data == nil {
+		definition.Pufferd.Data = make(map[string]*Variable)
+	}
+	return definition, nil
+}
+
+//Converts a field path from encoding/json, which separates array indexes with dots, to the form used in validation errors.
+func toJsonPath(field string) string {
+	path := ""
+	for _, part := range strings.Split(field, ".") {
+		if _, err := strconv.Atoi(part); err == nil && path != "" {
+			path += "[" + part + "]"
+		} else {
+			path = utils.JoinJsonPath(path, part)
+		}
+	}
+	if path == "" {
+		return "$"
+	}
+	return path
+}
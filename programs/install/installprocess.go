@@ -22,11 +22,11 @@ import (
 	"github.com/pufferpanel/pufferd/utils"
 )
 
-func GenerateInstallProcess(data *InstallSection, environment environments.Environment, dataMapping map[string]interface{}) InstallProcess {
+func GenerateInstallProcess(data *InstallSection, environment environments.Environment, variables map[string]interface{}) (InstallProcess, error) {
 	var directions = data.Commands
 	datamap := make(map[string]interface{})
-	for k, v := range dataMapping {
-		datamap[k] = v.(map[string]interface{})["value"]
+	for k, v := range variables {
+		datamap[k] = v
 	}
 	datamap["rootdir"] = environment.GetRootDirectory()
 	ops := make([]operations.Operation, 0)
@@ -34,27 +34,50 @@ func GenerateInstallProcess(data *InstallSection, environment environments.Envir
 		var mapping = element.(map[string]interface{})
 		switch mapping["type"] {
 		case "command":
-			for _, element := range utils.ToStringArray(mapping["commands"]) {
-				ops = append(ops, &operations.Command{Command: utils.ReplaceTokens(element, datamap), Environment: environment})
+			commands, err := utils.ReplaceTokensInArr(utils.ToStringArray(mapping["commands"]), datamap)
+			if err != nil {
+				return InstallProcess{}, err
+			}
+			for _, element := range commands {
+				ops = append(ops, &operations.Command{Command: element, Environment: environment})
 			}
 		case "download":
-			for _, element := range utils.ToStringArray(mapping["files"]) {
-				ops = append(ops, &operations.Download{File: utils.ReplaceTokens(element, datamap), Environment: environment})
+			files, err := utils.ReplaceTokensInArr(utils.ToStringArray(mapping["files"]), datamap)
+			if err != nil {
+				return InstallProcess{}, err
+			}
+			for _, element := range files {
+				ops = append(ops, &operations.Download{File: element, Environment: environment})
 			}
 		case "move":
-			source := mapping["source"].(string)
-			target := mapping["target"].(string)
+			source, err := utils.ReplaceTokens(mapping["source"].(string), datamap)
+			if err != nil {
+				return InstallProcess{}, err
+			}
+			target, err := utils.ReplaceTokens(mapping["target"].(string), datamap)
+			if err != nil {
+				return InstallProcess{}, err
+			}
 			ops = append(ops, &operations.Move{SourceFile: source, TargetFile: target, Environment: environment})
 		case "mkdir":
-			target := mapping["target"].(string)
+			target, err := utils.ReplaceTokens(mapping["target"].(string), datamap)
+			if err != nil {
+				return InstallProcess{}, err
+			}
 			ops = append(ops, &operations.Mkdir{TargetFile: target, Environment: environment})
 		case "writefile":
-			text := mapping["text"].(string)
-			target := mapping["target"].(string)
-			ops = append(ops, &operations.WriteFile{TargetFile:target, Environment: environment, Text: utils.ReplaceTokens(text, datamap)})
+			text, err := utils.ReplaceTokens(mapping["text"].(string), datamap)
+			if err != nil {
+				return InstallProcess{}, err
+			}
+			target, err := utils.ReplaceTokens(mapping["target"].(string), datamap)
+			if err != nil {
+				return InstallProcess{}, err
+			}
+			ops = append(ops, &operations.WriteFile{TargetFile: target, Environment: environment, Text: text})
 		}
 	}
-	return InstallProcess{processInstructions: ops}
+	return InstallProcess{processInstructions: ops}, nil
 }
 
 type InstallProcess struct {
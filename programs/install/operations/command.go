@@ -18,9 +18,9 @@ package operations
 
 import (
 	"fmt"
-	"strings"
 
 	"github.com/pufferpanel/pufferd/environments"
+	"github.com/pufferpanel/pufferd/utils"
 )
 
 type Command struct {
@@ -30,7 +30,10 @@ type Command struct {
 
 func (c *Command) Run() error {
 	fmt.Println("Running command: " + c.Command)
-	parts := strings.Split(c.Command, " ")
+	parts := utils.SplitArguments(c.Command)
+	if len(parts) == 0 {
+		return nil
+	}
 	cmd := parts[0]
 	args := parts[1:]
 	_, err := c.Environment.Execute(cmd, args)
@@ -17,7 +17,12 @@
 package operations
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
 	"github.com/cavaliercoder/grab"
+	"github.com/pufferpanel/pufferd/confined"
 	"github.com/pufferpanel/pufferd/environments"
 	"github.com/pufferpanel/pufferd/logging"
 )
@@ -27,8 +32,24 @@ type Download struct {
 	Environment environments.Environment
 }
 
+//Downloads into an empty directory first, then moves the file into the root.
+//An existing file of the same name is replaced, so a symlink in its place is not written through.
 func (d *Download) Run() error {
 	logging.Debugf("Download file from %s to %s", d.File, d.Environment.GetRootDirectory())
-	_, err := grab.Get(d.Environment.GetRootDirectory(), d.File)
-	return err
+	root := confined.New(d.Environment.GetRootDirectory())
+	err := os.MkdirAll(root.Root(), 0755)
+	if err != nil {
+		return err
+	}
+	temp, err := ioutil.TempDir(root.Root(), ".download")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(temp)
+
+	response, err := grab.Get(temp, d.File)
+	if err != nil {
+		return err
+	}
+	return os.Rename(response.Filename, filepath.Join(root.Root(), filepath.Base(response.Filename)))
 }
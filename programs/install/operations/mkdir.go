@@ -17,10 +17,8 @@
 package operations
 
 import (
-	"os"
-
+	"github.com/pufferpanel/pufferd/confined"
 	"github.com/pufferpanel/pufferd/environments"
-	"github.com/pufferpanel/pufferd/utils"
 )
 
 type Mkdir struct {
@@ -29,6 +27,5 @@ type Mkdir struct {
 }
 
 func (m *Mkdir) Run() error {
-	target := utils.JoinPath(m.Environment.GetRootDirectory(), m.TargetFile)
-	return os.MkdirAll(target, 0755)
+	return confined.New(m.Environment.GetRootDirectory()).MkdirAll(m.TargetFile, 0755)
 }
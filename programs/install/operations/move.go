@@ -20,9 +20,9 @@ import (
 	"os"
 	"path/filepath"
 
+	"github.com/pufferpanel/pufferd/confined"
 	"github.com/pufferpanel/pufferd/environments"
 	"github.com/pufferpanel/pufferd/logging"
-	"github.com/pufferpanel/pufferd/utils"
 )
 
 type Move struct {
@@ -32,9 +32,16 @@ type Move struct {
 }
 
 func (m *Move) Run() error {
-	source := utils.JoinPath(m.Environment.GetRootDirectory(), m.SourceFile)
-	target := utils.JoinPath(m.Environment.GetRootDirectory(), m.TargetFile)
-	result, valid := validateMove(source, target)
+	root := confined.New(m.Environment.GetRootDirectory())
+	source, err := root.Lresolve(m.SourceFile)
+	if err != nil {
+		return err
+	}
+	target, err := root.Resolve(m.TargetFile)
+	if err != nil {
+		return err
+	}
+	result, valid := validateMove(root, source, target)
 	if !valid {
 		return nil
 	}
@@ -48,9 +55,22 @@ func (m *Move) Run() error {
 	return nil
 }
 
-func validateMove(source string, target string) (result map[string]string, valid bool) {
+func validateMove(root *confined.FS, source string, target string) (result map[string]string, valid bool) {
 	result = make(map[string]string)
-	sourceFiles, _ := filepath.Glob(source)
+	matches, _ := filepath.Glob(source)
+	//a match can be reached through a symlinked directory, so each is resolved again to keep it inside the root
+	sourceFiles := make([]string, 0, len(matches))
+	for _, match := range matches {
+		relative, err := root.Rel(match)
+		if err == nil {
+			match, err = root.Lresolve(relative)
+		}
+		if err != nil {
+			logging.Error("Cannot move "+match, err)
+			continue
+		}
+		sourceFiles = append(sourceFiles, match)
+	}
 	info, err := os.Stat(target)
 
 	if err != nil {
@@ -17,19 +17,26 @@
 package operations
 
 import (
+	"os"
+
+	"github.com/pufferpanel/pufferd/confined"
 	"github.com/pufferpanel/pufferd/environments"
-	"io/ioutil"
-	"github.com/pufferpanel/pufferd/utils"
 )
 
 type WriteFile struct {
 	TargetFile  string
 	Environment environments.Environment
-	Text string
+	Text        string
 }
 
 func (c *WriteFile) Run() error {
-	target := utils.JoinPath(c.Environment.GetRootDirectory(), c.TargetFile)
-	ioutil.WriteFile(target, []byte(c.Text), 0644)
-	return nil
+	file, err := confined.New(c.Environment.GetRootDirectory()).OpenFile(c.TargetFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = file.WriteString(c.Text)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
 }
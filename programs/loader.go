@@ -17,6 +17,7 @@
 package programs
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -27,16 +28,12 @@ import (
 
 	"github.com/pufferpanel/pufferd/data/templates"
 	"github.com/pufferpanel/pufferd/environments"
-	"github.com/pufferpanel/pufferd/programs/install"
 	"github.com/pufferpanel/pufferd/logging"
 	"github.com/pufferpanel/pufferd/utils"
 	"github.com/pufferpanel/pufferd/config"
 )
 
-var (
-	programs     []Program = make([]Program, 0)
-	ServerFolder string
-)
+var ServerFolder string
 
 func Initialize() {
 	ServerFolder = config.GetOrDefault("serverfolder", utils.JoinPath("data", "servers"))
@@ -50,17 +47,18 @@ func LoadFromFolder() {
 	}
 	var program Program
 	for _, element := range programFiles {
-		if element.IsDir() {
+		if element.IsDir() || filepath.Ext(element.Name()) != ".json" {
 			continue
 		}
 		id := strings.TrimSuffix(element.Name(), filepath.Ext(element.Name()))
-		program, err = Load(id)
+		program, err = loadSafely(id)
 		if err != nil {
 			logging.Error(fmt.Sprintf("Error loading server details from json (%s)", element.Name()), err)
+			quarantine(id, err)
 			continue
 		}
 		logging.Infof("Loaded server %s", program.Id())
-		programs = append(programs, program)
+		servers.add(program)
 	}
 }
 
@@ -73,17 +71,45 @@ func Get(id string) (program Program, err error) {
 }
 
 func GetAll() []Program {
-	return programs
+	return servers.all()
 }
 
 func Load(id string) (program Program, err error) {
+	file := utils.JoinPath(ServerFolder, id+".json")
 	var data []byte
-	data, err = ioutil.ReadFile(utils.JoinPath(ServerFolder, id+".json"))
-	if len(data) == 0 || err != nil {
+	data, err = ioutil.ReadFile(file)
+	if err != nil {
+		return
+	}
+	if len(data) == 0 {
+		err = errors.New("Server file is empty")
+		return
+	}
+
+	var source map[string]interface{}
+	err = json.Unmarshal(data, &source)
+	if err != nil {
+		err = utils.ValidationErrors{{Field: "$", Message: "Invalid JSON: " + err.Error()}}
 		return
 	}
 
-	program, err = LoadFromData(id, data)
+	version := schemaVersionOf(source)
+	program, err = LoadFromMapping(id, source)
+	if err != nil || version >= templates.SchemaVersion {
+		return
+	}
+
+	//keep the definition as it was before migrating, in case the migration needs to be undone
+	backup := utils.JoinPath(ServerFolder, fmt.Sprintf("%s.v%d.bak", id, version))
+	if backupErr := ioutil.WriteFile(backup, data, 0664); backupErr != nil {
+		logging.Error("Error backing up server file before migration", backupErr)
+		return
+	}
+	if saveErr := program.Save(file); saveErr != nil {
+		logging.Error("Error saving migrated server file", saveErr)
+		return
+	}
+	logging.Infof("Migrated server %s from schema version %d to %d", id, version, templates.SchemaVersion)
 	return
 }
 
@@ -98,119 +124,106 @@ func LoadFromData(id string, source []byte) (program Program, err error) {
 }
 
 func LoadFromMapping(id string, source map[string]interface{}) (program Program, err error) {
-	var pufferdData = utils.GetMapOrNull(source, "pufferd")
-	var installSection = getInstallSection(utils.GetMapOrNull(pufferdData, "install"))
-	var runSection = utils.GetMapOrNull(pufferdData, "run")
-	var environmentSection = utils.GetMapOrNull(pufferdData, "environment")
-	var environment environments.Environment
-	var dataSection = utils.GetMapOrNull(pufferdData, "data")
-	dataCasted := make(map[string]interface{}, len(dataSection))
-	for key, value := range dataSection {
-		dataCasted[key] = value
+	if errs := templates.Upgrade(source); len(errs) > 0 {
+		err = errs
+		return
+	}
+	if errs := templates.Validate(source); len(errs) > 0 {
+		err = errs
+		return
 	}
 
-	var environmentType string
-	if environmentSection == nil {
-		environmentType = "standard"
-	} else {
-		environmentType = utils.GetStringOrDefault(environmentSection, "type", "standard")
+	definition, err := decodeDefinition(source)
+	if err != nil {
+		return
 	}
+	program = newProgram(id, &definition.Pufferd)
+	return
+}
 
+func newProgram(id string, definition *Definition) *programData {
+	environmentType := utils.GetStringOrDefault(definition.Environment, "type", "standard")
 	logging.Debugf("Loading server as %s", environmentType)
-
-	environment = environments.LoadEnvironment(environmentType, ServerFolder, id, environmentSection)
-
-	var runBlock Runtime
-	if pufferdData["run"] == nil {
-		runBlock = Runtime{}
-	} else {
-		var stop = utils.GetStringOrDefault(runSection, "stop", "")
-		var pre = utils.GetStringArrayOrNull(runSection, "pre")
-		var post = utils.GetStringArrayOrNull(runSection, "post")
-		var arguments = utils.GetStringArrayOrNull(runSection, "arguments")
-		var enabled = utils.GetBooleanOrDefault(runSection, "enabled", true)
-		var autostart = utils.GetBooleanOrDefault(runSection, "autostart", true)
-		var program = utils.GetStringOrDefault(runSection, "program", "")
-		runBlock = Runtime{Stop: stop, Pre: pre, Post: post, Arguments: arguments, Enabled: enabled, AutoStart: autostart, Program: program}
+	environment := environments.LoadEnvironment(environmentType, ServerFolder, id, definition.Environment)
+
+	program := &programData{
+		Data:            definition.Data,
+		Identifier:      id,
+		Type:            definition.Type,
+		Display:         definition.Display,
+		RunData:         definition.Run,
+		InstallData:     definition.Install,
+		EnvironmentData: definition.Environment,
+		Template:        definition.Template,
+		Backup:          definition.Backup,
+		Quota:           definition.Quota,
+		Schedules:       definition.Schedules,
+		Triggers:        definition.Triggers,
 	}
-	program = &programData{Data: dataCasted, Identifier: id, RunData: runBlock, InstallData: installSection, Environment: environment}
-	return
+	program.SetEnvironment(environment)
+	program.compileTriggers()
+	program.updateQuota()
+	return program
 }
 
-func Create(id string, serverType string, data map[string]interface{}) bool {
-	if GetFromCache(id) != nil {
-		return false
+func Create(id string, serverType string, data map[string]interface{}) error {
+	if !servers.reserve(id) {
+		return errors.New("Server already exists")
 	}
+	defer servers.release(id)
 
-	templateData, err := ioutil.ReadFile(utils.JoinPath(templates.Folder, serverType+".json"))
+	templateJson, err := templates.Load(serverType)
 	if err != nil {
 		logging.Error("Error reading template file for type "+serverType, err)
-		return false
+		return err
 	}
-
-	var templateJson map[string]interface{}
-	err = json.Unmarshal(templateData, &templateJson)
 	segment := utils.GetMapOrNull(templateJson, "pufferd")
+	if abstract, _ := segment["abstract"].(bool); abstract {
+		return errors.New("Template " + serverType + " is abstract and cannot be used to create a server")
+	}
+	delete(segment, "abstract")
+	segment["template"] = &TemplateOrigin{Name: serverType, Version: utils.GetStringOrDefault(segment, "version", "")}
+	delete(segment, "version")
 
+	variables, err := decodeVariables(utils.GetMapOrNull(segment, "data"))
 	if err != nil {
 		logging.Error("Error reading template file for type "+serverType, err)
-		return false
-	}
-
-	if data != nil {
-		var mapper map[string]interface{}
-		mapper = segment["data"].(map[string]interface{})
-		for k, v := range data {
-			if mapper[k] == nil {
-				newMap := make(map[string]interface{})
-				newMap["value"] = v
-				newMap["desc"] = "No description"
-				newMap["display"] = k
-				newMap["required"] = false
-				newMap["internal"] = true
-				mapper[k] = newMap
-			} else {
-				mapper[k].(map[string]interface{})["value"] = v;
-			}
-		}
-		segment["data"] = mapper
+		return err
 	}
 
-	f, err := os.Create(utils.JoinPath(ServerFolder, id+".json"))
-
-	if err != nil {
-		logging.Error("Error writing server file", err)
-		return false
+	variables, errs := applyVariableValues(variables, data)
+	errs = append(errs, ValidateVariables(variables)...)
+	if len(errs) > 0 {
+		return errs
 	}
+	segment["data"] = variables
 
-	defer f.Close()
-
-	encoder := json.NewEncoder(f)
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
 	encoder.SetEscapeHTML(false)
 	encoder.SetIndent("", "  ")
 	err = encoder.Encode(templateJson)
+	if err == nil {
+		err = utils.WriteFileAtomic(utils.JoinPath(ServerFolder, id+".json"), buffer.Bytes(), 0664)
+	}
 
 	if err != nil {
 		logging.Error("Error writing server file", err)
-		return false
+		return err
 	}
 
-	program, _ := LoadFromMapping(id, templateJson)
-	programs = append(programs, program)
+	program, err := Load(id)
+	if err != nil {
+		logging.Error("Error loading server file", err)
+		return err
+	}
+	servers.add(program)
 	program.Create()
-	return true
+	return nil
 }
 
 func Delete(id string) (err error) {
-	var index int
-	var program Program
-	for i, element := range programs {
-		if element.Id() == id {
-			program = element
-			index = i
-			break
-		}
-	}
+	program := servers.remove(id)
 	if program == nil {
 		return
 	}
@@ -218,26 +231,22 @@ func Delete(id string) (err error) {
 	if program.IsRunning() {
 		err = program.Stop()
 		if err != nil {
+			servers.add(program)
 			return err
 		}
 	}
 
 	err = program.Destroy()
 	if err != nil {
+		servers.add(program)
 		return err
 	}
 	os.Remove(utils.JoinPath(ServerFolder, program.Id()+".json"))
-	programs = append(programs[:index], programs[index+1:]...)
 	return
 }
 
 func GetFromCache(id string) Program {
-	for _, element := range programs {
-		if element.Id() == id {
-			return element
-		}
-	}
-	return nil
+	return servers.get(id)
 }
 
 func Save(id string) (err error) {
@@ -274,30 +283,25 @@ func GetPlugins() map[string]interface{} {
 	mapping := make(map[string]interface{})
 
 	for _, element := range temps {
-		if element.IsDir() {
+		if element.IsDir() || filepath.Ext(element.Name()) != ".json" || strings.HasPrefix(element.Name(), ".") {
 			continue
 		}
 		name := strings.TrimSuffix(element.Name(), filepath.Ext(element.Name()))
-		templateData, _ := ioutil.ReadFile(utils.JoinPath(templates.Folder, name+".json"))
-
-		var templateJson map[string]interface{}
-		err := json.Unmarshal(templateData, &templateJson)
-		if (err != nil) {
-			logging.Error("Malformed json for program " + element.Name(), err)
+		templateJson, err := templates.Load(name)
+		if err != nil {
+			logging.Error("Invalid template "+element.Name(), err)
 			continue
 		}
 		segment := utils.GetMapOrNull(templateJson, "pufferd")
+		if abstract, _ := segment["abstract"].(bool); abstract {
+			continue
+		}
 		dataSec := make(map[string]interface{})
-		dataSec["variables"] = segment["data"].(map[string]interface{})
+		dataSec["variables"] = segment["data"]
 		dataSec["display"] = segment["display"]
+		dataSec["version"] = segment["version"]
 		mapping[name] = dataSec
 	}
 
 	return mapping
 }
-
-func getInstallSection(mapping map[string]interface{}) install.InstallSection {
-	return install.InstallSection{
-		Commands:  utils.GetObjectArrayOrNull(mapping, "commands"),
-	}
-}
@@ -18,6 +18,7 @@ package programs_test
 
 import (
 	"github.com/pufferpanel/pufferd/programs"
+	"github.com/pufferpanel/pufferd/utils"
 	"testing"
 )
 
@@ -34,13 +35,33 @@ func TestLoadProgram_Java(t *testing.T) {
 }
 
 func TestLoadProgram_Unknown(t *testing.T) {
-	data := []byte("{\"pufferd\": {\"type\": \"badserver\"}}")
+	data := []byte("{\"pufferd\": {\"type\": \"badserver\", \"schemaVersion\": 99}}")
 	var program, err = programs.LoadFromData("asdfasdf", data)
-	if err != nil || program != nil {
-		if err != nil {
-			t.Error(err)
-		} else {
-			t.Error("Program return was not nil")
+	if program != nil {
+		t.Error("Program return was not nil")
+	}
+	if _, ok := err.(utils.ValidationErrors); !ok {
+		t.Errorf("Expected validation errors, got %v", err)
+	}
+}
+
+func TestLoadProgram_InvalidTypes(t *testing.T) {
+	data := []byte(`{"pufferd":{"schemaVersion":2,"run":{"stop":5,"arguments":["-jar",3]},"data":{"memory":{"value":"1024","required":"yes"}}}}`)
+	var program, err = programs.LoadFromData("asdfasdf", data)
+	if program != nil {
+		t.Error("Program return was not nil")
+	}
+	errs, ok := err.(utils.ValidationErrors)
+	if !ok {
+		t.Fatalf("Expected validation errors, got %v", err)
+	}
+	expected := []string{"pufferd.data.memory.required", "pufferd.run.arguments[1]", "pufferd.run.stop"}
+	if len(errs) != len(expected) {
+		t.Fatalf("Expected %d errors, got %v", len(expected), errs)
+	}
+	for i, v := range expected {
+		if errs[i].Field != v {
+			t.Errorf("Expected error for %s, got %s", v, errs[i].Field)
 		}
 	}
 }
@@ -0,0 +1,64 @@
+// +build linux
+
+/*
+ Copyright 2016 Padduck, LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+ 	http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package programs
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+//Checks whether anything has bound a udp port, by reading the sockets the kernel lists rather than
+//binding the port, which would stop the server binding it while the check holds it.
+func udpBound(address string) bool {
+	_, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	number, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return false
+	}
+	//local addresses are listed as the address and port in hex, such as 0100007F:6989
+	suffix := fmt.Sprintf(":%04X", number)
+
+	for _, file := range []string{"/proc/net/udp", "/proc/net/udp6"} {
+		sockets, err := os.Open(file)
+		if err != nil {
+			continue
+		}
+		scanner := bufio.NewScanner(sockets)
+		found := false
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) > 1 && strings.HasSuffix(fields[1], suffix) {
+				found = true
+				break
+			}
+		}
+		sockets.Close()
+		if found {
+			return true
+		}
+	}
+	return false
+}
@@ -18,12 +18,20 @@ package programs
 
 import (
 	"encoding/json"
-	"io/ioutil"
+	"errors"
+	"fmt"
 	"os"
+	"sync"
+	"time"
 
+	"github.com/pufferpanel/pufferd/data/templates"
 	"github.com/pufferpanel/pufferd/environments"
+	"github.com/pufferpanel/pufferd/events"
 	"github.com/pufferpanel/pufferd/programs/install"
 	"github.com/pufferpanel/pufferd/logging"
+	"github.com/pufferpanel/pufferd/query"
+	"github.com/pufferpanel/pufferd/quota"
+	"github.com/pufferpanel/pufferd/rcon"
 	"github.com/pufferpanel/pufferd/utils"
 )
 
@@ -59,6 +67,9 @@ type Program interface {
 	//If the program supports input, this will send the arguments to that.
 	Execute(command string) (err error)
 
+	//Sends a command over the command transport, returning the response if it was sent over RCON.
+	SendCommand(command string) (response string, err error)
+
 	SetEnabled(isEnabled bool) (err error)
 
 	IsEnabled() (isEnabled bool)
@@ -79,17 +90,70 @@ type Program interface {
 
 	Reload(data Program)
 
-	GetData() map[string]interface{}
+	GetData() map[string]*Variable
 
 	GetNetwork() string
+
+	GetBackupSettings() BackupSettings
+
+	SetBackupSettings(settings BackupSettings) (err error)
+
+	GetSchedules() map[string]Schedule
+
+	//Creates or replaces the named schedule.
+	SetSchedule(name string, schedule Schedule) (err error)
+
+	DeleteSchedule(name string) (err error)
+
+	GetTriggers() []Trigger
+
+	SetTriggers(triggers []Trigger) (err error)
+
+	//Returns the state set by a trigger, such as crashed, whether the server is starting or ready,
+	//or else whether it is running or stopped.
+	GetState() string
+
+	//Waits until the server is ready after starting, failing with a NotReadyError if it stops or times out first.
+	WaitUntilReady() error
+
+	GetQueryStatus() *query.Status
+
+	GetQuota() *quota.Quota
 }
 
+const (
+	StateRunning = "running"
+	StateStopped = "stopped"
+)
+
 type programData struct {
-	RunData     Runtime
-	InstallData install.InstallSection
-	Environment environments.Environment
-	Identifier  string
-	Data        map[string]interface{}
+	RunData         Runtime
+	InstallData     install.InstallSection
+	Environment     environments.Environment
+	EnvironmentData map[string]interface{}
+	Identifier      string
+	Type            string
+	Display         string
+	Data            map[string]*Variable
+	Template        *TemplateOrigin
+	Backup          *BackupSettings
+	Quota           *QuotaSettings
+	Schedules       map[string]Schedule
+	Triggers        []Trigger
+
+	//Serializes changes to the definition and writes of the server file.
+	lock sync.Mutex
+
+	//Guards the state and triggers in use, which change with console output.
+	stateLock       sync.Mutex
+	state           string
+	triggers        []*compiledTrigger
+	fired           []time.Time
+	triggersLimited bool
+	readiness       *readinessCheck
+	query           *queryPoller
+	quota           *quota.Quota
+	quotaWarned     bool
 }
 
 //Starts the program.
@@ -97,15 +161,31 @@ type programData struct {
 func (p *programData) Start() (err error) {
 	logging.Debugf("Starting server %s", p.Id())
 	p.Environment.DisplayToConsole("Starting server")
-	data := make(map[string]interface{})
-	for k, v := range p.Data {
-		data[k] = v.(map[string]interface{})["value"]
+	err = p.checkQuotaForStart()
+	if err != nil {
+		p.Environment.DisplayToConsole("Failed to start server: " + err.Error() + "\n")
+		return
+	}
+	data := p.getVariableValues()
+	program, err := utils.ReplaceTokens(p.RunData.Program, data)
+	if err != nil {
+		logging.Error("Error parsing program for server "+p.Id(), err)
+		p.Environment.DisplayToConsole("Failed to start server: " + err.Error() + "\n")
+		return
+	}
+	arguments, err := utils.ReplaceTokensInArr(p.RunData.Arguments, data)
+	if err != nil {
+		logging.Error("Error parsing arguments for server "+p.Id(), err)
+		p.Environment.DisplayToConsole("Failed to start server: " + err.Error() + "\n")
+		return
 	}
-	err = p.Environment.ExecuteAsync(p.RunData.Program, utils.ReplaceTokensInArr(p.RunData.Arguments, data))
+	err = p.Environment.ExecuteAsync(program, arguments)
 	if err != nil {
 		p.Environment.DisplayToConsole("Failed to start server\n")
 	} else {
 		//p.Environment.DisplayToConsole("Server started\n")
+		p.startReadiness()
+		p.startQuery()
 	}
 	return
 }
@@ -113,7 +193,17 @@ func (p *programData) Start() (err error) {
 //Stops the program.
 //This will also stop the environment it is ran in.
 func (p *programData) Stop() (err error) {
-	err = p.Environment.ExecuteInMainProcess(p.RunData.Stop)
+	stop, err := utils.ReplaceTokens(p.RunData.Stop, p.getVariableValues())
+	if err != nil {
+		p.Environment.DisplayToConsole("Failed to stop server: " + err.Error() + "\n")
+		return
+	}
+	_, err = p.SendCommand(stop)
+	//servers close the RCON connection as they stop
+	if err == rcon.ErrClosed {
+		err = nil
+	}
+	p.setState("")
 	if err != nil {
 		p.Environment.DisplayToConsole("Failed to stop server\n")
 	} else {
@@ -126,6 +216,7 @@ func (p *programData) Stop() (err error) {
 //This will also stop the environment it is ran in.
 func (p *programData) Kill() (err error) {
 	err = p.Environment.Kill()
+	p.setState("")
 	if err != nil {
 		p.Environment.DisplayToConsole("Failed to kill server\n")
 	} else {
@@ -170,7 +261,12 @@ func (p *programData) Install() (err error) {
 
 	os.MkdirAll(p.Environment.GetRootDirectory(), 0755)
 
-	process := install.GenerateInstallProcess(&p.InstallData, p.Environment, p.Data)
+	process, err := install.GenerateInstallProcess(&p.InstallData, p.Environment, p.getVariableValues())
+	if err != nil {
+		logging.Error("Error generating installer: ", err)
+		p.Environment.DisplayToConsole("Error installing server: " + err.Error() + "\n")
+		return
+	}
 	for process.HasNext() {
 		err = process.RunNext()
 		if err != nil {
@@ -192,7 +288,7 @@ func (p *programData) IsRunning() (isRunning bool) {
 //Sends a command to the process
 //If the program supports input, this will send the arguments to that.
 func (p *programData) Execute(command string) (err error) {
-	err = p.Environment.ExecuteInMainProcess(command)
+	_, err = p.SendCommand(command)
 	return
 }
 
@@ -207,6 +303,7 @@ func (p *programData) IsEnabled() (isEnabled bool) {
 }
 
 func (p *programData) SetEnvironment(environment environments.Environment) (err error) {
+	environment.SetConsoleListener(&consoleListener{program: p})
 	p.Environment = environment
 	return
 }
@@ -230,42 +327,74 @@ func (p *programData) IsAutoStart() (isAutoStart bool) {
 }
 
 func (p *programData) Save(file string) (err error) {
-	result := make(map[string]interface{})
-	result["data"] = p.Data
-	result["install"] = p.InstallData
-	result["run"] = p.RunData
-
-	endResult := make(map[string]interface{})
-	endResult["pufferd"] = result
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.save(file)
+}
 
-	data, err := json.MarshalIndent(endResult, "", "  ")
+//Writes the definition to the file. The caller must hold the lock.
+func (p *programData) save(file string) (err error) {
+	data, err := json.MarshalIndent(ServerDefinition{Pufferd: p.definition()}, "", "  ")
 	if err != nil {
 		return
 	}
 
-	err = ioutil.WriteFile(file, data, 0664)
+	err = utils.WriteFileAtomic(file, data, 0664)
 	return
 }
 
+func (p *programData) definition() Definition {
+	return Definition{
+		SchemaVersion: templates.SchemaVersion,
+		Type:          p.Type,
+		Display:       p.Display,
+		Template:      p.Template,
+		Install:       p.InstallData,
+		Run:           p.RunData,
+		Environment:   p.EnvironmentData,
+		Data:          p.Data,
+		Backup:        p.Backup,
+		Quota:         p.Quota,
+		Schedules:     p.Schedules,
+		Triggers:      p.Triggers,
+	}
+}
+
 func (p *programData) Edit(data map[string]interface{}) (err error) {
-	for k, v := range data {
-		if v == nil || v == "" {
-			delete(p.Data, k)
-		}
-		p.Data[k] = v
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	updated, errs := applyVariableValues(p.Data, data)
+	errs = append(errs, ValidateVariables(updated)...)
+	if len(errs) > 0 {
+		err = errs
+		return
 	}
-	err = Save(p.Id())
+	p.Data = updated
+	err = p.save(utils.JoinPath(ServerFolder, p.Id()+".json"))
 	return
 }
 
 func (p *programData) Reload(data Program) {
 	replacement := data.(*programData)
+	p.lock.Lock()
+	defer p.lock.Unlock()
 	p.Data = replacement.Data
 	p.InstallData = replacement.InstallData
 	p.RunData = replacement.RunData
+	p.EnvironmentData = replacement.EnvironmentData
+	p.Type = replacement.Type
+	p.Display = replacement.Display
+	p.Template = replacement.Template
+	p.Backup = replacement.Backup
+	p.Quota = replacement.Quota
+	p.Schedules = replacement.Schedules
+	p.Triggers = replacement.Triggers
+	p.compileTriggers()
+	p.updateQuota()
 }
 
-func (p *programData) GetData() map[string]interface{} {
+func (p *programData) GetData() map[string]*Variable {
 	return p.Data
 }
 
@@ -275,24 +404,166 @@ func (p *programData) GetNetwork() string {
 	port := "0"
 
 	ipData := data["ip"]
-	if ipData != nil {
-		ip = ipData.(map[string]interface{})["value"].(string)
+	if ipData != nil && ipData.Value != nil {
+		ip = fmt.Sprint(ipData.Value)
 	}
 
 	portData := data["port"]
-	if portData != nil {
-		port = portData.(map[string]interface{})["value"].(string)
+	if portData != nil && portData.Value != nil {
+		port = fmt.Sprint(portData.Value)
 	}
 
 	return ip + ":" + port
 }
 
+func (p *programData) GetBackupSettings() BackupSettings {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.Backup == nil {
+		return BackupSettings{}
+	}
+	return *p.Backup
+}
+
+func (p *programData) SetBackupSettings(settings BackupSettings) (err error) {
+	if errs := settings.Validate(); len(errs) > 0 {
+		err = errs
+		return
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.Backup = &settings
+	err = p.save(utils.JoinPath(ServerFolder, p.Id()+".json"))
+	return
+}
+
+func (p *programData) GetSchedules() map[string]Schedule {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	result := make(map[string]Schedule, len(p.Schedules))
+	for k, v := range p.Schedules {
+		result[k] = v
+	}
+	return result
+}
+
+func (p *programData) SetSchedule(name string, schedule Schedule) (err error) {
+	errs := schedule.Validate()
+	if !ValidScheduleName(name) {
+		errs = append(errs, utils.ValidationError{Field: "name", Message: "Name may only contain letters, numbers, - and _"})
+	}
+	if len(errs) > 0 {
+		err = errs
+		return
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	schedules := make(map[string]Schedule, len(p.Schedules)+1)
+	for k, v := range p.Schedules {
+		schedules[k] = v
+	}
+	schedules[name] = schedule
+	p.Schedules = schedules
+	err = p.save(utils.JoinPath(ServerFolder, p.Id()+".json"))
+	return
+}
+
+func (p *programData) DeleteSchedule(name string) (err error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if _, exists := p.Schedules[name]; !exists {
+		err = errors.New("No schedule with given name")
+		return
+	}
+	schedules := make(map[string]Schedule, len(p.Schedules))
+	for k, v := range p.Schedules {
+		if k != name {
+			schedules[k] = v
+		}
+	}
+	p.Schedules = schedules
+	err = p.save(utils.JoinPath(ServerFolder, p.Id()+".json"))
+	return
+}
+
+func (p *programData) GetTriggers() []Trigger {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	result := make([]Trigger, len(p.Triggers))
+	copy(result, p.Triggers)
+	return result
+}
+
+func (p *programData) SetTriggers(triggers []Trigger) (err error) {
+	if errs := validateTriggers(triggers); len(errs) > 0 {
+		err = errs
+		return
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.Triggers = triggers
+	p.compileTriggers()
+	err = p.save(utils.JoinPath(ServerFolder, p.Id()+".json"))
+	return
+}
+
+func (p *programData) GetState() string {
+	p.stateLock.Lock()
+	state := p.state
+	p.stateLock.Unlock()
+	running := p.IsRunning()
+	if state == StateStarting || state == StateReady {
+		if !running {
+			return StateStopped
+		}
+		return state
+	}
+	if state != "" {
+		return state
+	}
+	if running {
+		return StateRunning
+	}
+	return StateStopped
+}
+
+//Sets the state of the server, with an empty state meaning it is running or stopped.
+func (p *programData) setState(state string) {
+	p.stateLock.Lock()
+	changed := p.state != state
+	p.state = state
+	p.stateLock.Unlock()
+	if changed {
+		events.Publish(events.Event{Server: p.Id(), Type: events.TypeState, Name: p.GetState()})
+	}
+}
+
+//Gets the value of each variable, coerced to the type the variable declares.
+func (p *programData) getVariableValues() map[string]interface{} {
+	data := make(map[string]interface{})
+	for k, v := range p.Data {
+		value, err := v.Coerce()
+		if err != nil {
+			logging.Debugf("Variable %s on server %s could not be coerced: %s", k, p.Id(), err.Error())
+			value = v.Value
+		}
+		data[k] = value
+	}
+	return data
+}
+
+
 type Runtime struct {
-	Stop      string   `json:"stop"`
-	Pre       []string `json:"pre,omitempty"`
-	Post      []string `json:"post,omitempty"`
-	Program   string   `json:"program"`
-	Arguments []string `json:"arguments"`
-	Enabled   bool     `json:"enabled"`
-	AutoStart bool     `json:"autostart"`
+	Stop      string         `json:"stop"`
+	Pre       []string       `json:"pre,omitempty"`
+	Post      []string       `json:"post,omitempty"`
+	Program   string         `json:"program"`
+	Arguments []string       `json:"arguments"`
+	Enabled   bool           `json:"enabled"`
+	AutoStart bool           `json:"autostart"`
+	Ready     *Readiness     `json:"ready,omitempty"`
+	Query     *QuerySettings `json:"query,omitempty"`
+	//how commands are sent, one of stdin, rcon or both, with stdin the default
+	CommandTransport string        `json:"commandTransport,omitempty"`
+	Rcon             *RconSettings `json:"rcon,omitempty"`
 }
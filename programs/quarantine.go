@@ -0,0 +1,139 @@
+/*
+ Copyright 2016 Padduck, LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+ 	http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package programs
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/pufferpanel/pufferd/logging"
+	"github.com/pufferpanel/pufferd/utils"
+)
+
+//A server whose definition could not be loaded.
+//The file is left where it is so it can be fixed and loaded again.
+type QuarantinedServer struct {
+	Id     string                 `json:"id"`
+	Error  string                 `json:"error"`
+	Errors utils.ValidationErrors `json:"errors,omitempty"`
+	Time   time.Time              `json:"time"`
+}
+
+//Returns the quarantined servers ordered by id.
+func GetQuarantined() []QuarantinedServer {
+	servers.lock.RLock()
+	result := make([]QuarantinedServer, 0, len(servers.quarantined))
+	for _, v := range servers.quarantined {
+		result = append(result, v)
+	}
+	servers.lock.RUnlock()
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Id < result[j].Id
+	})
+	return result
+}
+
+func IsQuarantined(id string) bool {
+	servers.lock.RLock()
+	defer servers.lock.RUnlock()
+	_, exists := servers.quarantined[id]
+	return exists
+}
+
+//Tries to load a quarantined server again, releasing it from quarantine if it now loads.
+func RetryQuarantined(id string) error {
+	if !IsQuarantined(id) {
+		return errors.New("Server is not quarantined")
+	}
+
+	program, err := loadSafely(id)
+	if err != nil {
+		quarantine(id, err)
+		return err
+	}
+	servers.add(program)
+	logging.Infof("Loaded server %s", id)
+	return nil
+}
+
+//Deletes the definition of a quarantined server, along with the backups made when migrating it,
+//so the id can be used again. The files of the server are left in place.
+func DeleteQuarantined(id string) error {
+	servers.lock.Lock()
+	entry, exists := servers.quarantined[id]
+	if !exists {
+		servers.lock.Unlock()
+		return errors.New("Server is not quarantined")
+	}
+	//the id stays claimed until the files are gone, so it cannot be created again in between
+	delete(servers.quarantined, id)
+	servers.reserved[id] = true
+	servers.lock.Unlock()
+	defer servers.release(id)
+
+	file := utils.JoinPath(ServerFolder, id+".json")
+	if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+		servers.lock.Lock()
+		servers.quarantined[id] = entry
+		servers.lock.Unlock()
+		return err
+	}
+	backups, _ := filepath.Glob(utils.JoinPath(ServerFolder, id+".v*.bak"))
+	for _, backup := range backups {
+		if err := os.Remove(backup); err != nil {
+			logging.Error("Error deleting server file backup "+backup, err)
+		}
+	}
+	logging.Infof("Deleted quarantined server %s", id)
+	return nil
+}
+
+func quarantine(id string, err error) {
+	entry := QuarantinedServer{Id: id, Error: err.Error(), Time: time.Now()}
+	if errs, ok := err.(utils.ValidationErrors); ok {
+		entry.Error = "Server definition is invalid"
+		entry.Errors = errs
+	}
+	servers.lock.Lock()
+	servers.quarantined[id] = entry
+	servers.lock.Unlock()
+	logging.Warnf("Server %s has been quarantined", id)
+}
+
+//Loads a server, turning any panic while decoding it into an error.
+func loadSafely(id string) (program Program, err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			program = nil
+			err = fmt.Errorf("Panic while loading server: %v", recovered)
+		}
+	}()
+	return Load(id)
+}
+
+func schemaVersionOf(source map[string]interface{}) int {
+	pufferd, _ := source["pufferd"].(map[string]interface{})
+	if version, ok := pufferd["schemaVersion"].(float64); ok {
+		return int(version)
+	}
+	return 1
+}
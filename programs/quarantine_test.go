@@ -0,0 +1,56 @@
+/*
+ Copyright 2016 Padduck, LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+ 	http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package programs_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pufferpanel/pufferd/programs"
+)
+
+func TestDeleteQuarantined(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pufferd-servers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	programs.ServerFolder = dir
+	ioutil.WriteFile(filepath.Join(dir, "broken.json"), []byte(`{"pufferd":{"schemaVersion":99}}`), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "broken.v1.bak"), []byte(`{}`), 0644)
+
+	programs.LoadFromFolder()
+	if !programs.IsQuarantined("broken") {
+		t.Fatal("Expected the server to be quarantined")
+	}
+	if err := programs.DeleteQuarantined("broken"); err != nil {
+		t.Fatal(err)
+	}
+	if programs.IsQuarantined("broken") {
+		t.Error("Expected the server to be released from quarantine")
+	}
+	for _, name := range []string{"broken.json", "broken.v1.bak"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be deleted", name)
+		}
+	}
+	if err := programs.DeleteQuarantined("broken"); err == nil {
+		t.Error("Expected deleting a server which is not quarantined to fail")
+	}
+}
@@ -0,0 +1,107 @@
+/*
+ Copyright 2016 Padduck, LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+ 	http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package programs
+
+import (
+	"net"
+	"time"
+
+	"github.com/pufferpanel/pufferd/logging"
+	"github.com/pufferpanel/pufferd/query"
+	"github.com/pufferpanel/pufferd/utils"
+)
+
+//How often a running server is queried.
+const queryInterval = 15 * time.Second
+
+//The game query protocol a server answers, see the query package for those supported.
+//Port defaults to the port of the server and can use variables, such as ${queryport}.
+type QuerySettings struct {
+	Protocol string `json:"protocol"`
+	Port     string `json:"port,omitempty"`
+}
+
+//Polls one start of a server until it stops. A later start replaces it.
+type queryPoller struct {
+	status *query.Status
+}
+
+//Begins polling a server which has just been started, if its template declares a query protocol.
+func (p *programData) startQuery() {
+	settings := p.RunData.Query
+	var poller *queryPoller
+	if settings != nil {
+		poller = &queryPoller{}
+	}
+	p.stateLock.Lock()
+	p.query = poller
+	p.stateLock.Unlock()
+	if poller != nil {
+		go p.pollQuery(poller, *settings)
+	}
+}
+
+func (p *programData) pollQuery(poller *queryPoller, settings QuerySettings) {
+	_, port, _ := net.SplitHostPort(p.GetNetwork())
+	if settings.Port != "" {
+		var err error
+		port, err = utils.ReplaceTokens(settings.Port, p.getVariableValues())
+		if err != nil {
+			logging.Error("Invalid query port on server "+p.Id(), err)
+			return
+		}
+	}
+	address := p.probeAddress(port)
+
+	ticker := time.NewTicker(queryInterval)
+	defer ticker.Stop()
+	for {
+		<-ticker.C
+		p.stateLock.Lock()
+		current := p.query == poller
+		p.stateLock.Unlock()
+		if !current || !p.IsRunning() {
+			return
+		}
+
+		status, err := query.Query(settings.Protocol, address)
+		if err != nil {
+			logging.Debugf("Error querying server %s: %s", p.Id(), err.Error())
+		}
+		p.stateLock.Lock()
+		poller.status = status
+		p.stateLock.Unlock()
+	}
+}
+
+//Returns the result of the last query of the server, or nil if its template declares no query protocol.
+//Servers which are stopped, not yet queried or did not answer are reported offline.
+func (p *programData) GetQueryStatus() *query.Status {
+	if p.RunData.Query == nil {
+		return nil
+	}
+	p.stateLock.Lock()
+	var status *query.Status
+	if p.query != nil {
+		status = p.query.status
+	}
+	p.stateLock.Unlock()
+	if status == nil || !p.IsRunning() {
+		return &query.Status{PlayerNames: []string{}, Time: time.Now()}
+	}
+	return status
+}
@@ -0,0 +1,144 @@
+/*
+ Copyright 2016 Padduck, LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+ 	http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package programs
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/pufferpanel/pufferd/events"
+	"github.com/pufferpanel/pufferd/logging"
+	"github.com/pufferpanel/pufferd/quota"
+)
+
+const (
+	QuotaWarn  = "warn"
+	QuotaStop  = "stop"
+	QuotaBlock = "block"
+)
+
+//How often the disk usage of servers is measured.
+const quotaInterval = time.Minute
+
+var ErrQuotaExceeded = errors.New("Server is over its disk quota")
+
+//Limits the disk a server can use, in megabytes. Writes made through pufferd which do not fit are refused,
+//unless the action is warn. Growth from the server itself is found by a check every minute, which warns
+//on the console and, with stop, stops the server and keeps it from starting until usage is back under.
+//Block, the default, refuses writes through pufferd until then.
+type QuotaSettings struct {
+	Limit  int64  `json:"limit"`
+	Action string `json:"action,omitempty"`
+}
+
+func (s *QuotaSettings) action() string {
+	if s.Action == "" {
+		return QuotaBlock
+	}
+	return s.Action
+}
+
+//Creates the quota for the current settings. Servers without one still get a quota, which only measures usage.
+func (p *programData) updateQuota() {
+	var limit int64
+	block := false
+	if p.Quota != nil {
+		limit = p.Quota.Limit * 1024 * 1024
+		block = p.Quota.action() != QuotaWarn
+	}
+	updated := quota.New(p.Environment.GetRootDirectory(), limit, block)
+	p.stateLock.Lock()
+	//the usage reported in stats is kept until the next check measures the server again
+	if p.quota != nil {
+		updated.Add(p.quota.Cached())
+	}
+	p.quota = updated
+	p.quotaWarned = false
+	p.stateLock.Unlock()
+}
+
+//Returns the quota of the server, which file writes made through pufferd are checked against.
+func (p *programData) GetQuota() *quota.Quota {
+	p.stateLock.Lock()
+	defer p.stateLock.Unlock()
+	return p.quota
+}
+
+//Measures the disk usage of every server every minute, starting now, so the usage reported in stats
+//is never more than a minute old without a request having to walk the server.
+func StartQuotaChecks() {
+	go func() {
+		for {
+			for _, program := range GetAll() {
+				program.(*programData).checkQuota()
+			}
+			time.Sleep(quotaInterval)
+		}
+	}()
+}
+
+//Measures the disk usage of the server, acting on it once each time it goes over its quota, if it has one.
+func (p *programData) checkQuota() {
+	limit := p.GetQuota()
+	used, err := limit.Measure()
+	if err != nil {
+		logging.Error("Error measuring disk usage of server "+p.Id(), err)
+		return
+	}
+	p.lock.Lock()
+	settings := p.Quota
+	p.lock.Unlock()
+	if settings == nil {
+		return
+	}
+
+	over := used > limit.Limit()
+	p.stateLock.Lock()
+	warned := p.quotaWarned
+	p.quotaWarned = over
+	p.stateLock.Unlock()
+	if !over || warned {
+		return
+	}
+
+	action := settings.action()
+	logging.Warnf("Server %s is using %d of its %d MB disk quota", p.Id(), used/1024/1024, settings.Limit)
+	p.Environment.DisplayToConsole("Disk quota of " + strconv.FormatInt(settings.Limit, 10) + " MB exceeded\n")
+	events.Publish(events.Event{Server: p.Id(), Type: events.TypeQuota, Name: "exceeded", Data: map[string]interface{}{
+		"used":   used,
+		"limit":  limit.Limit(),
+		"action": action,
+	}})
+	if action == QuotaStop && p.IsRunning() {
+		err = p.Stop()
+		if err != nil {
+			logging.Error("Error stopping server "+p.Id()+" over its disk quota", err)
+		}
+	}
+}
+
+//Keeps a server whose quota stops it from starting while it is still over the quota.
+func (p *programData) checkQuotaForStart() error {
+	p.lock.Lock()
+	settings := p.Quota
+	p.lock.Unlock()
+	if settings == nil || settings.action() != QuotaStop || !p.GetQuota().Exceeded() {
+		return nil
+	}
+	return ErrQuotaExceeded
+}
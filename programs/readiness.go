@@ -0,0 +1,238 @@
+/*
+ Copyright 2016 Padduck, LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+ 	http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package programs
+
+import (
+	"errors"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pufferpanel/pufferd/logging"
+	"github.com/pufferpanel/pufferd/utils"
+)
+
+const (
+	StateStarting = "starting"
+	StateReady    = "ready"
+	//the server did not become ready within the timeout, though it may still be running
+	StateFailed = "failed"
+)
+
+const (
+	//how long a server with a console or port check has to become ready, unless the template sets a timeout
+	defaultReadyTimeout = 300
+	//how many console lines are returned when a server fails to become ready
+	failureConsoleLines = 20
+)
+
+//How to tell a started server is ready for players. Every check given must pass.
+//Console is a regex matched against each line of output, and Port a port, which can use variables
+//such as ${port}, that accepts connections or, for udp, has been bound. Bound udp ports are only seen on
+//Linux, elsewhere a console check is needed. With neither set the server is ready once it has run for
+//Timeout seconds, otherwise Timeout is how long it has to become ready.
+type Readiness struct {
+	Console  string `json:"console,omitempty"`
+	Port     string `json:"port,omitempty"`
+	Protocol string `json:"protocol,omitempty"`
+	Timeout  int    `json:"timeout,omitempty"`
+}
+
+//Returned when a server stops or times out before it is ready, with the end of its console output.
+type NotReadyError struct {
+	Reason  string   `json:"error"`
+	Console []string `json:"console"`
+}
+
+func (e *NotReadyError) Error() string {
+	return e.Reason
+}
+
+//Tracks one start of a server until it is ready. A later start replaces it.
+type readinessCheck struct {
+	console *regexp.Regexp
+	matched chan struct{}
+	done    chan struct{}
+	err     error
+}
+
+//Begins tracking the readiness of a server which has just been started.
+func (p *programData) startReadiness() {
+	ready := p.RunData.Ready
+	if ready == nil {
+		p.stateLock.Lock()
+		p.readiness = nil
+		p.stateLock.Unlock()
+		p.setState("")
+		return
+	}
+
+	check := &readinessCheck{matched: make(chan struct{}), done: make(chan struct{})}
+	if ready.Console != "" {
+		regex, err := regexp.Compile(ready.Console)
+		if err != nil {
+			logging.Error("Invalid readiness pattern on server "+p.Id(), err)
+		} else {
+			check.console = regex
+		}
+	}
+
+	p.stateLock.Lock()
+	p.readiness = check
+	p.stateLock.Unlock()
+	p.setState(StateStarting)
+	go p.waitForReady(check, *ready)
+}
+
+func (p *programData) waitForReady(check *readinessCheck, ready Readiness) {
+	address := ""
+	if ready.Port != "" {
+		port, err := utils.ReplaceTokens(ready.Port, p.getVariableValues())
+		if err != nil {
+			logging.Error("Invalid readiness port on server "+p.Id(), err)
+		} else {
+			address = p.probeAddress(port)
+		}
+	}
+
+	timeout := ready.Timeout
+	if timeout == 0 && (check.console != nil || address != "") {
+		timeout = defaultReadyTimeout
+	}
+	deadline := time.After(time.Duration(timeout) * time.Second)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	consoleReady := check.console == nil
+	portReady := address == ""
+	matched := check.matched
+	for !consoleReady || !portReady || (check.console == nil && address == "") {
+		select {
+		case <-matched:
+			consoleReady = true
+			//a closed channel is always ready, so it is not selected again
+			matched = nil
+		case <-ticker.C:
+			if !p.isCurrentCheck(check) {
+				p.finishReadiness(check, errors.New("Server was started again"), "")
+				return
+			}
+			if !p.IsRunning() {
+				p.finishReadiness(check, p.notReady("Server stopped before it was ready"), "")
+				return
+			}
+			if !portReady {
+				portReady = probe(ready.Protocol, address)
+			}
+		case <-deadline:
+			if check.console == nil && address == "" {
+				p.finishReadiness(check, nil, StateReady)
+			} else {
+				p.finishReadiness(check, p.notReady("Server was not ready after "+strconv.Itoa(timeout)+" seconds"), StateFailed)
+			}
+			return
+		}
+	}
+	p.finishReadiness(check, nil, StateReady)
+}
+
+//Waits for the last start of the server to become ready.
+//Returns nil straight away if the server has no readiness checks or was not started.
+func (p *programData) WaitUntilReady() error {
+	p.stateLock.Lock()
+	check := p.readiness
+	p.stateLock.Unlock()
+	if check == nil {
+		return nil
+	}
+	<-check.done
+	return check.err
+}
+
+//Matches console output against the readiness pattern of the current start.
+func (p *programData) matchReadiness(line string) {
+	p.stateLock.Lock()
+	defer p.stateLock.Unlock()
+	check := p.readiness
+	if check == nil || check.console == nil || p.state != StateStarting {
+		return
+	}
+	if check.console.MatchString(line) {
+		select {
+		case <-check.matched:
+		default:
+			close(check.matched)
+		}
+	}
+}
+
+func (p *programData) isCurrentCheck(check *readinessCheck) bool {
+	p.stateLock.Lock()
+	defer p.stateLock.Unlock()
+	return p.readiness == check
+}
+
+//Records the result of a check, moving the server out of starting if the check is still current.
+func (p *programData) finishReadiness(check *readinessCheck, err error, state string) {
+	check.err = err
+	close(check.done)
+
+	p.stateLock.Lock()
+	current := p.readiness == check && p.state == StateStarting
+	p.stateLock.Unlock()
+	if current {
+		p.setState(state)
+	}
+	if err != nil {
+		logging.Debugf("Server %s did not become ready: %s", p.Id(), err.Error())
+	}
+}
+
+func (p *programData) notReady(reason string) error {
+	console, _ := p.Environment.GetConsole()
+	lines := strings.Split(strings.TrimRight(strings.Join(console, ""), "\n"), "\n")
+	if len(lines) > failureConsoleLines {
+		lines = lines[len(lines)-failureConsoleLines:]
+	}
+	return &NotReadyError{Reason: reason, Console: lines}
+}
+
+//Returns the address to probe for a port, using the ip of the server if it has one.
+func (p *programData) probeAddress(port string) string {
+	host, _, err := net.SplitHostPort(p.GetNetwork())
+	if err != nil || host == "0.0.0.0" || host == "" {
+		host = "127.0.0.1"
+	}
+	return net.JoinHostPort(host, port)
+}
+
+//Checks a tcp port accepts connections, or a udp port has been bound.
+//Nothing can be assumed to answer an arbitrary udp packet, so udp is checked against the bound sockets.
+func probe(protocol string, address string) bool {
+	if protocol == "udp" {
+		return udpBound(address)
+	}
+
+	connection, err := net.DialTimeout("tcp", address, time.Second)
+	if err != nil {
+		return false
+	}
+	connection.Close()
+	return true
+}
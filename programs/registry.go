@@ -0,0 +1,94 @@
+/*
+ Copyright 2016 Padduck, LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+ 	http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package programs
+
+import (
+	"sort"
+	"sync"
+)
+
+//Holds the loaded servers by id, along with those in quarantine and the ids being created.
+//It is read and changed from HTTP handlers, SFTP and install goroutines, so all access goes through the lock.
+type registry struct {
+	lock        sync.RWMutex
+	programs    map[string]Program
+	quarantined map[string]QuarantinedServer
+	reserved    map[string]bool
+}
+
+var servers = &registry{
+	programs:    make(map[string]Program),
+	quarantined: make(map[string]QuarantinedServer),
+	reserved:    make(map[string]bool),
+}
+
+func (r *registry) get(id string) Program {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.programs[id]
+}
+
+//Returns the loaded servers ordered by id.
+func (r *registry) all() []Program {
+	r.lock.RLock()
+	result := make([]Program, 0, len(r.programs))
+	for _, v := range r.programs {
+		result = append(result, v)
+	}
+	r.lock.RUnlock()
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Id() < result[j].Id()
+	})
+	return result
+}
+
+func (r *registry) add(program Program) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.programs[program.Id()] = program
+	delete(r.quarantined, program.Id())
+}
+
+//Removes a server, returning it if it was loaded.
+func (r *registry) remove(id string) Program {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	program := r.programs[id]
+	delete(r.programs, id)
+	return program
+}
+
+//Claims an id for a server being created. Returns false if the id is loaded, quarantined or already claimed.
+func (r *registry) reserve(id string) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.programs[id] != nil || r.reserved[id] {
+		return false
+	}
+	if _, exists := r.quarantined[id]; exists {
+		return false
+	}
+	r.reserved[id] = true
+	return true
+}
+
+func (r *registry) release(id string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	delete(r.reserved, id)
+}
@@ -0,0 +1,233 @@
+/*
+ Copyright 2016 Padduck, LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+ 	http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package programs
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+
+	"github.com/pufferpanel/pufferd/data/templates"
+	"github.com/pufferpanel/pufferd/environments"
+	"github.com/pufferpanel/pufferd/programs/install"
+	"github.com/pufferpanel/pufferd/utils"
+)
+
+//Records the template a server was created from.
+type TemplateOrigin struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+//Changes an upgrade would make to a server to match the current version of its template.
+//Variable definitions are compared without their values, since an upgrade keeps the server's values.
+type TemplateDiff struct {
+	Template        string             `json:"template"`
+	ServerVersion   string             `json:"serverVersion"`
+	TemplateVersion string             `json:"templateVersion"`
+	Changes         []utils.Difference `json:"changes"`
+}
+
+//Environment settings which belong to the server rather than its template, kept when it is upgraded.
+var serverEnvironmentKeys = []string{"root"}
+
+//The parts of a server definition the template decides, decoded into the types the server uses.
+type templateSections struct {
+	run         Runtime
+	install     install.InstallSection
+	environment map[string]interface{}
+	data        map[string]*Variable
+	serverType  string
+	display     string
+	version     string
+}
+
+//Compares a server with the current version of its template.
+//Servers created before the template was recorded can name the template to compare against.
+func DiffTemplate(id string, templateName string) (*TemplateDiff, error) {
+	program, name, err := getUpgradable(id, templateName)
+	if err != nil {
+		return nil, err
+	}
+	sections, err := loadTemplateSections(name)
+	if err != nil {
+		return nil, err
+	}
+
+	program.lock.Lock()
+	defer program.lock.Unlock()
+	return program.diffTemplate(name, sections)
+}
+
+//Applies the current version of the template to a server, keeping the values of its variables
+//and whether it is enabled. Variables the template no longer declares are removed.
+func UpgradeTemplate(id string, templateName string) (*TemplateDiff, error) {
+	program, name, err := getUpgradable(id, templateName)
+	if err != nil {
+		return nil, err
+	}
+	sections, err := loadTemplateSections(name)
+	if err != nil {
+		return nil, err
+	}
+
+	program.lock.Lock()
+	defer program.lock.Unlock()
+	diff, err := program.diffTemplate(name, sections)
+	if err != nil {
+		return nil, err
+	}
+
+	variables := sections.data
+	for k, v := range variables {
+		if existing, ok := program.Data[k]; ok {
+			v.Value = existing.Value
+		}
+	}
+	if errs := ValidateVariables(variables); len(errs) > 0 {
+		return nil, errs
+	}
+
+	sections.run.Enabled = program.RunData.Enabled
+	sections.run.AutoStart = program.RunData.AutoStart
+	program.RunData = sections.run
+	program.InstallData = sections.install
+	program.Data = variables
+	program.Type = sections.serverType
+	program.Display = sections.display
+	environment := withServerEnvironment(sections.environment, program.EnvironmentData)
+	if !reflect.DeepEqual(program.EnvironmentData, environment) {
+		environmentType := utils.GetStringOrDefault(environment, "type", "standard")
+		program.SetEnvironment(environments.LoadEnvironment(environmentType, ServerFolder, id, environment))
+		program.EnvironmentData = environment
+		program.updateQuota()
+	}
+	program.Template = &TemplateOrigin{Name: name, Version: sections.version}
+
+	err = program.save(utils.JoinPath(ServerFolder, id+".json"))
+	if err != nil {
+		return nil, err
+	}
+	return diff, nil
+}
+
+//Copies the environment of a template, carrying over the settings which belong to the server.
+func withServerEnvironment(template map[string]interface{}, server map[string]interface{}) map[string]interface{} {
+	result := make(map[string]interface{}, len(template))
+	for k, v := range template {
+		result[k] = v
+	}
+	for _, key := range serverEnvironmentKeys {
+		delete(result, key)
+		if value, exists := server[key]; exists {
+			result[key] = value
+		}
+	}
+	return result
+}
+
+func getUpgradable(id string, templateName string) (*programData, string, error) {
+	existing := GetFromCache(id)
+	if existing == nil {
+		return nil, "", errors.New("No server with given id")
+	}
+	program := existing.(*programData)
+
+	if templateName == "" && program.Template != nil {
+		templateName = program.Template.Name
+	}
+	if templateName == "" {
+		return nil, "", utils.ValidationErrors{{Field: "template", Message: "Server has no template recorded, a template must be given"}}
+	}
+	return program, templateName, nil
+}
+
+func loadTemplateSections(name string) (*templateSections, error) {
+	template, err := templates.Load(name)
+	if err != nil {
+		if _, ok := err.(utils.ValidationErrors); ok {
+			return nil, err
+		}
+		return nil, utils.ValidationErrors{{Field: "template", Message: "Cannot load template " + name + ": " + err.Error()}}
+	}
+
+	segment := utils.GetMapOrNull(template, "pufferd")
+	if abstract, _ := segment["abstract"].(bool); abstract {
+		return nil, utils.ValidationErrors{{Field: "template", Message: "Template " + name + " is abstract"}}
+	}
+	version, _ := segment["version"].(string)
+	definition, err := decodeDefinition(template)
+	if err != nil {
+		return nil, err
+	}
+	return &templateSections{
+		run:         definition.Pufferd.Run,
+		install:     definition.Pufferd.Install,
+		environment: definition.Pufferd.Environment,
+		data:        definition.Pufferd.Data,
+		serverType:  definition.Pufferd.Type,
+		display:     definition.Pufferd.Display,
+		version:     version,
+	}, nil
+}
+
+func (p *programData) diffTemplate(name string, sections *templateSections) (*TemplateDiff, error) {
+	current := &templateSections{run: p.RunData, install: p.InstallData, environment: p.EnvironmentData, data: p.Data}
+	old, err := current.comparable()
+	if err != nil {
+		return nil, err
+	}
+	updated, err := sections.comparable()
+	if err != nil {
+		return nil, err
+	}
+
+	diff := &TemplateDiff{Template: name, TemplateVersion: sections.version, Changes: utils.DiffJson("", old, updated)}
+	if p.Template != nil && p.Template.Name == name {
+		diff.ServerVersion = p.Template.Version
+	}
+	return diff, nil
+}
+
+//Converts the sections to their JSON form, leaving out the values and settings which belong to the server.
+func (s *templateSections) comparable() (map[string]interface{}, error) {
+	definitions := copyVariables(s.data)
+	for _, v := range definitions {
+		v.Value = nil
+	}
+	sections := map[string]interface{}{
+		"run":         s.run,
+		"install":     s.install,
+		"environment": withServerEnvironment(s.environment, nil),
+		"data":        definitions,
+	}
+
+	data, err := json.Marshal(sections)
+	if err != nil {
+		return nil, err
+	}
+	var result map[string]interface{}
+	err = json.Unmarshal(data, &result)
+	if err != nil {
+		return nil, err
+	}
+	if run, ok := result["run"].(map[string]interface{}); ok {
+		delete(run, "enabled")
+		delete(run, "autostart")
+	}
+	return result, nil
+}
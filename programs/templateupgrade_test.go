@@ -0,0 +1,73 @@
+/*
+ Copyright 2016 Padduck, LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+ 	http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package programs_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pufferpanel/pufferd/data/templates"
+	"github.com/pufferpanel/pufferd/programs"
+)
+
+func TestUpgradeTemplate_KeepsRoot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pufferd-upgrade")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	programs.ServerFolder = filepath.Join(dir, "servers")
+	templates.Folder = filepath.Join(dir, "templates")
+	root := filepath.Join(dir, "world")
+	os.MkdirAll(programs.ServerFolder, 0755)
+	os.MkdirAll(templates.Folder, 0755)
+	os.MkdirAll(root, 0755)
+	ioutil.WriteFile(filepath.Join(root, "level.dat"), make([]byte, 100), 0644)
+
+	ioutil.WriteFile(filepath.Join(templates.Folder, "game.json"), []byte(`{"pufferd":{"schemaVersion":2,"version":"2",
+		"run":{"stop":"stop","program":"./game","arguments":[]},"environment":{"type":"tty"}}}`), 0644)
+	ioutil.WriteFile(filepath.Join(programs.ServerFolder, "upgraded.json"), []byte(`{"pufferd":{"schemaVersion":2,
+		"template":{"name":"game","version":"1"},"run":{"stop":"stop","program":"./game","arguments":[]},
+		"environment":{"type":"standard","root":"`+filepath.ToSlash(root)+`"}}}`), 0644)
+	programs.LoadFromFolder()
+	defer programs.Delete("upgraded")
+
+	diff, err := programs.DiffTemplate("upgraded", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, change := range diff.Changes {
+		if strings.Contains(change.Path, "root") {
+			t.Errorf("Expected the root of the server to be left out of the diff but found %+v", change)
+		}
+	}
+
+	_, err = programs.UpgradeTemplate("upgraded", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	program := programs.GetFromCache("upgraded")
+	if actual := program.GetEnvironment().GetRootDirectory(); actual != filepath.ToSlash(root) && actual != root {
+		t.Errorf("Expected the root %s to be kept but got %s", root, actual)
+	}
+	if used, err := program.GetQuota().Measure(); err != nil || used != 100 {
+		t.Errorf("Expected the quota to measure the kept root but got %d, %v", used, err)
+	}
+}
@@ -0,0 +1,219 @@
+/*
+ Copyright 2016 Padduck, LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+ 	http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package programs
+
+import (
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/pufferpanel/pufferd/events"
+	"github.com/pufferpanel/pufferd/logging"
+	"github.com/pufferpanel/pufferd/utils"
+)
+
+const (
+	TriggerEvent   = "event"
+	TriggerState   = "state"
+	TriggerCommand = "command"
+	TriggerKill    = "kill"
+	TriggerRestart = "restart"
+)
+
+const (
+	//seconds a trigger waits after firing before it can fire again, unless it sets its own cooldown
+	defaultTriggerCooldown = 5
+	//most actions all the triggers of a server can take in a minute
+	triggerLimit = 20
+	//longest console line matched, longer lines are cut
+	maxLineLength = 4096
+)
+
+//Runs an action when a line of console output matches a pattern.
+//Commands can refer to groups in the pattern as $1 or $name.
+type Trigger struct {
+	Pattern  string `json:"pattern"`
+	Action   string `json:"action"`
+	Event    string `json:"event,omitempty"`
+	State    string `json:"state,omitempty"`
+	Command  string `json:"command,omitempty"`
+	Cooldown *int   `json:"cooldown,omitempty"`
+}
+
+type compiledTrigger struct {
+	Trigger
+	regex     *regexp.Regexp
+	lastFired time.Time
+}
+
+func (t *Trigger) Validate(field string) utils.ValidationErrors {
+	errs := utils.ValidationErrors{}
+	if _, err := regexp.Compile(t.Pattern); err != nil || t.Pattern == "" {
+		message := "Value is required"
+		if err != nil {
+			message = "Invalid regex: " + err.Error()
+		}
+		errs = append(errs, utils.ValidationError{Field: field + ".pattern", Message: message})
+	}
+
+	required := map[string]string{TriggerEvent: t.Event, TriggerState: t.State, TriggerCommand: t.Command}
+	switch t.Action {
+	case TriggerEvent, TriggerState, TriggerCommand:
+		if required[t.Action] == "" {
+			errs = append(errs, utils.ValidationError{Field: field + "." + t.Action, Message: "Value is required"})
+		}
+	case TriggerKill, TriggerRestart:
+	default:
+		errs = append(errs, utils.ValidationError{Field: field + ".action", Message: "Value " + t.Action + " must be one of event, state, command, kill, restart"})
+	}
+
+	if t.Cooldown != nil && *t.Cooldown < 0 {
+		errs = append(errs, utils.ValidationError{Field: field + ".cooldown", Message: "Value must be at least 0"})
+	}
+	return errs
+}
+
+func validateTriggers(triggers []Trigger) utils.ValidationErrors {
+	errs := utils.ValidationErrors{}
+	for i, trigger := range triggers {
+		errs = append(errs, trigger.Validate("triggers["+strconv.Itoa(i)+"]")...)
+	}
+	return errs
+}
+
+//Splits console output into lines and matches them against the triggers of a server.
+type consoleListener struct {
+	program *programData
+	partial []byte
+}
+
+func (l *consoleListener) Write(data []byte) (int, error) {
+	for _, b := range data {
+		if b == '\n' || b == '\r' {
+			if len(l.partial) > 0 {
+				line := string(l.partial)
+				l.program.matchReadiness(line)
+				l.program.matchTriggers(line)
+				l.partial = l.partial[:0]
+			}
+			continue
+		}
+		if len(l.partial) < maxLineLength {
+			l.partial = append(l.partial, b)
+		}
+	}
+	return len(data), nil
+}
+
+//Compiles the triggers of the definition, replacing those in use.
+func (p *programData) compileTriggers() {
+	compiled := make([]*compiledTrigger, 0, len(p.Triggers))
+	for _, trigger := range p.Triggers {
+		regex, err := regexp.Compile(trigger.Pattern)
+		if err != nil {
+			logging.Error("Invalid trigger pattern on server "+p.Id(), err)
+			continue
+		}
+		compiled = append(compiled, &compiledTrigger{Trigger: trigger, regex: regex})
+	}
+
+	p.stateLock.Lock()
+	p.triggers = compiled
+	p.stateLock.Unlock()
+}
+
+func (p *programData) matchTriggers(line string) {
+	now := time.Now()
+	p.stateLock.Lock()
+	defer p.stateLock.Unlock()
+
+	for _, trigger := range p.triggers {
+		match := trigger.regex.FindStringSubmatchIndex(line)
+		if match == nil {
+			continue
+		}
+
+		cooldown := defaultTriggerCooldown
+		if trigger.Cooldown != nil {
+			cooldown = *trigger.Cooldown
+		}
+		if now.Sub(trigger.lastFired) < time.Duration(cooldown)*time.Second {
+			continue
+		}
+
+		recent := p.fired[:0]
+		for _, fired := range p.fired {
+			if now.Sub(fired) < time.Minute {
+				recent = append(recent, fired)
+			}
+		}
+		p.fired = recent
+		if len(p.fired) >= triggerLimit {
+			if !p.triggersLimited {
+				logging.Warnf("Triggers on server %s fired %d times in the last minute, ignoring them for now", p.Id(), triggerLimit)
+				p.triggersLimited = true
+			}
+			return
+		}
+		p.triggersLimited = false
+		p.fired = append(p.fired, now)
+		trigger.lastFired = now
+
+		//actions can wait on the process, which cannot exit while its output is blocked here
+		go p.fire(trigger.Trigger, trigger.regex, line, match)
+	}
+}
+
+func (p *programData) fire(trigger Trigger, regex *regexp.Regexp, line string, match []int) {
+	logging.Debugf("Trigger %s fired on server %s", trigger.Pattern, p.Id())
+	var err error
+	switch trigger.Action {
+	case TriggerEvent:
+		data := map[string]interface{}{"line": line}
+		groups := make([]string, 0)
+		for i := 1; i < len(match)/2; i++ {
+			group := ""
+			if match[2*i] >= 0 {
+				group = line[match[2*i]:match[2*i+1]]
+			}
+			groups = append(groups, group)
+			if name := regex.SubexpNames()[i]; name != "" {
+				data[name] = group
+			}
+		}
+		data["groups"] = groups
+		events.Publish(events.Event{Server: p.Id(), Type: events.TypeTrigger, Name: trigger.Event, Data: data})
+	case TriggerState:
+		p.setState(trigger.State)
+	case TriggerCommand:
+		command := string(regex.ExpandString(nil, trigger.Command, line, match))
+		err = p.Execute(command)
+	case TriggerKill:
+		err = p.Kill()
+	case TriggerRestart:
+		err = p.Stop()
+		if err == nil {
+			err = p.Environment.WaitForMainProcess()
+		}
+		if err == nil {
+			err = p.Start()
+		}
+	}
+	if err != nil {
+		logging.Error("Error running trigger on server "+p.Id(), err)
+	}
+}
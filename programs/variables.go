@@ -0,0 +1,252 @@
+/*
+ Copyright 2016 Padduck, LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+ 	http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package programs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pufferpanel/pufferd/utils"
+)
+
+const (
+	VariableString  = "string"
+	VariableInteger = "integer"
+	VariableBoolean = "boolean"
+	VariablePort    = "port"
+	VariableEnum    = "enum"
+	VariableMemory  = "memory"
+)
+
+var VariableTypes = []string{VariableString, VariableInteger, VariableBoolean, VariablePort, VariableEnum, VariableMemory}
+
+var memoryRegex = regexp.MustCompile(`^(?i)\s*([0-9]+(?:\.[0-9]+)?)\s*([KMGT]?)B?\s*$`)
+
+type Variable struct {
+	Value    interface{} `json:"value"`
+	Required bool        `json:"required"`
+	Desc     string      `json:"desc,omitempty"`
+	Display  string      `json:"display,omitempty"`
+	Internal bool        `json:"internal"`
+	Type     string      `json:"type,omitempty"`
+	Min      *float64    `json:"min,omitempty"`
+	Max      *float64    `json:"max,omitempty"`
+	Regex    string      `json:"regex,omitempty"`
+	Options  []string    `json:"options,omitempty"`
+}
+
+//Converts the value of this variable to the Go type matching its declared type.
+//Integers and ports become int64, booleans become bool and memory sizes become an int64 amount of MB.
+func (v *Variable) Coerce() (interface{}, error) {
+	if v.Value == nil {
+		return nil, nil
+	}
+	str := strings.TrimSpace(fmt.Sprint(v.Value))
+
+	switch v.Type {
+	case VariableInteger, VariablePort:
+		switch value := v.Value.(type) {
+		case float64:
+			if value != float64(int64(value)) {
+				return nil, errors.New("Value must be a whole number")
+			}
+			return int64(value), nil
+		case int:
+			return int64(value), nil
+		case int64:
+			return value, nil
+		}
+		if str == "" {
+			return nil, nil
+		}
+		result, err := strconv.ParseInt(str, 10, 64)
+		if err != nil {
+			return nil, errors.New("Value must be a whole number")
+		}
+		return result, nil
+	case VariableBoolean:
+		if value, ok := v.Value.(bool); ok {
+			return value, nil
+		}
+		if str == "" {
+			return nil, nil
+		}
+		result, err := strconv.ParseBool(str)
+		if err != nil {
+			return nil, errors.New("Value must be true or false")
+		}
+		return result, nil
+	case VariableMemory:
+		if value, ok := v.Value.(float64); ok {
+			return int64(value), nil
+		}
+		if str == "" {
+			return nil, nil
+		}
+		return parseMemory(str)
+	default:
+		if _, ok := v.Value.(string); ok {
+			return v.Value, nil
+		}
+		return fmt.Sprint(v.Value), nil
+	}
+}
+
+//Validates the value of this variable against its type and constraints.
+func (v *Variable) Validate(name string) utils.ValidationErrors {
+	errs := utils.ValidationErrors{}
+	fail := func(msg string) utils.ValidationErrors {
+		return append(errs, utils.ValidationError{Field: name, Message: msg})
+	}
+
+	if v.Type != "" && !utils.ContainsValue(VariableTypes, v.Type) {
+		return fail("Unknown variable type " + v.Type)
+	}
+
+	coerced, err := v.Coerce()
+	if err != nil {
+		return fail(err.Error())
+	}
+
+	//a number given as only spaces coerces to nothing, so it is as empty as no value at all
+	if coerced == nil || coerced == "" {
+		if v.Required {
+			return fail("Value is required")
+		}
+		return errs
+	}
+
+	switch v.Type {
+	case VariableInteger, VariableMemory:
+		errs = append(errs, v.checkRange(name, float64(coerced.(int64)), "Value")...)
+	case VariablePort:
+		port := coerced.(int64)
+		if port < 1 || port > 65535 {
+			return fail("Port must be between 1 and 65535")
+		}
+		errs = append(errs, v.checkRange(name, float64(port), "Port")...)
+	case VariableEnum:
+		if !utils.ContainsValue(v.Options, fmt.Sprint(coerced)) {
+			return fail("Value must be one of " + strings.Join(v.Options, ", "))
+		}
+	case VariableBoolean:
+	default:
+		errs = append(errs, v.checkRange(name, float64(len(coerced.(string))), "Length")...)
+	}
+
+	if v.Regex != "" {
+		regex, err := regexp.Compile(v.Regex)
+		if err != nil {
+			errs = append(errs, utils.ValidationError{Field: name, Message: "Invalid regex " + v.Regex})
+		} else if !regex.MatchString(fmt.Sprint(v.Value)) {
+			errs = append(errs, utils.ValidationError{Field: name, Message: "Value does not match " + v.Regex})
+		}
+	}
+	return errs
+}
+
+func (v *Variable) checkRange(name string, value float64, label string) utils.ValidationErrors {
+	errs := utils.ValidationErrors{}
+	if v.Min != nil && value < *v.Min {
+		errs = append(errs, utils.ValidationError{Field: name, Message: fmt.Sprintf("%s must be at least %v", label, *v.Min)})
+	}
+	if v.Max != nil && value > *v.Max {
+		errs = append(errs, utils.ValidationError{Field: name, Message: fmt.Sprintf("%s must be at most %v", label, *v.Max)})
+	}
+	return errs
+}
+
+//Validates every variable, returning the errors ordered by variable name.
+func ValidateVariables(variables map[string]*Variable) utils.ValidationErrors {
+	names := make([]string, 0, len(variables))
+	for k := range variables {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	errs := utils.ValidationErrors{}
+	for _, name := range names {
+		errs = append(errs, variables[name].Validate(name)...)
+	}
+	return errs
+}
+
+//Applies the given values to a copy of the variables.
+//Keys which do not match a declared variable are reported as errors.
+func applyVariableValues(variables map[string]*Variable, values map[string]interface{}) (map[string]*Variable, utils.ValidationErrors) {
+	updated := copyVariables(variables)
+	errs := utils.ValidationErrors{}
+	for k, v := range values {
+		variable, exists := updated[k]
+		if !exists {
+			errs = append(errs, utils.ValidationError{Field: k, Message: "Unknown variable"})
+			continue
+		}
+		if definition, ok := v.(map[string]interface{}); ok {
+			v = definition["value"]
+		}
+		variable.Value = v
+	}
+	sort.Slice(errs, func(i, j int) bool {
+		return errs[i].Field < errs[j].Field
+	})
+	return updated, errs
+}
+
+func copyVariables(variables map[string]*Variable) map[string]*Variable {
+	result := make(map[string]*Variable, len(variables))
+	for k, v := range variables {
+		copied := *v
+		result[k] = &copied
+	}
+	return result
+}
+
+func decodeVariables(section map[string]interface{}) (map[string]*Variable, error) {
+	result := make(map[string]*Variable, len(section))
+	if section == nil {
+		return result, nil
+	}
+	data, err := json.Marshal(section)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(data, &result)
+	return result, err
+}
+
+func parseMemory(value string) (int64, error) {
+	match := memoryRegex.FindStringSubmatch(value)
+	if match == nil {
+		return 0, errors.New("Value must be a memory size such as 1024, 512M or 2G")
+	}
+	amount, _ := strconv.ParseFloat(match[1], 64)
+	switch strings.ToUpper(match[2]) {
+	case "K":
+		amount = amount / 1024
+	case "G":
+		amount = amount * 1024
+	case "T":
+		amount = amount * 1024 * 1024
+	}
+	return int64(amount), nil
+}
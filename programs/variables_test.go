@@ -0,0 +1,43 @@
+/*
+ Copyright 2016 Padduck, LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+ 	http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package programs_test
+
+import (
+	"testing"
+
+	"github.com/pufferpanel/pufferd/programs"
+)
+
+func TestVariable_Validate(t *testing.T) {
+	tests := []struct {
+		variable programs.Variable
+		errors   int
+	}{
+		{variable: programs.Variable{Type: programs.VariablePort, Value: "25565"}},
+		{variable: programs.Variable{Type: programs.VariablePort, Value: "70000"}, errors: 1},
+		{variable: programs.Variable{Type: programs.VariableInteger, Value: "  "}},
+		{variable: programs.Variable{Type: programs.VariableMemory, Value: "  ", Required: true}, errors: 1},
+		{variable: programs.Variable{Type: programs.VariablePort, Value: "  ", Required: true}, errors: 1},
+		{variable: programs.Variable{Value: "", Required: true}, errors: 1},
+	}
+	for _, test := range tests {
+		errs := test.variable.Validate("value")
+		if len(errs) != test.errors {
+			t.Errorf("Validating %+v expected %d errors but got %v", test.variable, test.errors, errs)
+		}
+	}
+}
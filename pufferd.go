@@ -29,6 +29,7 @@ import (
 
 	"github.com/braintree/manners"
 	"github.com/gin-gonic/gin"
+	"github.com/pufferpanel/pufferd/backup"
 	"github.com/pufferpanel/pufferd/config"
 	"github.com/pufferpanel/pufferd/data"
 	"github.com/pufferpanel/pufferd/data/templates"
@@ -39,6 +40,7 @@ import (
 	"github.com/pufferpanel/pufferd/programs"
 	"github.com/pufferpanel/pufferd/routing"
 	"github.com/pufferpanel/pufferd/routing/server"
+	"github.com/pufferpanel/pufferd/scheduler"
 	"github.com/pufferpanel/pufferd/sftp"
 	"github.com/pufferpanel/pufferd/utils"
 	"net/http"
@@ -63,6 +65,8 @@ func main() {
 	var license bool
 	var migrate bool
 	var configPath string
+	var validateTemplate string
+	var syncTemplates bool
 	flag.StringVar(&loggingLevel, "logging", "INFO", "Lowest logging level to display")
 	flag.IntVar(&webPort, "webport", 5656, "Port to run web service on")
 	flag.StringVar(&authRoot, "auth", "", "Base URL to the authorization server")
@@ -72,6 +76,8 @@ func main() {
 	flag.BoolVar(&license, "license", false, "View license")
 	flag.BoolVar(&migrate, "migrate", false, "Migrate Scales data to pufferd")
 	flag.StringVar(&configPath, "config", "config.json", "Path to pufferd config.json")
+	flag.StringVar(&validateTemplate, "validate-template", "", "Validate a template file and report any problems")
+	flag.BoolVar(&syncTemplates, "sync-templates", false, "Sync templates from the configured template repositories")
 	flag.Parse()
 
 	versionString := fmt.Sprintf("pufferd %s (%s %s)", VERSION, BUILDDATE, GITHASH)
@@ -91,7 +97,44 @@ func main() {
 		migration.MigrateFromScales()
 	}
 
-	if license || version || migrate {
+	if validateTemplate != "" {
+		errs, err := templates.ValidateFile(validateTemplate)
+		if err != nil {
+			os.Stdout.WriteString("Error reading template: " + err.Error() + "\r\n")
+			os.Exit(1)
+		}
+		for _, v := range errs {
+			os.Stdout.WriteString(v.Field + ": " + v.Message + "\r\n")
+		}
+		if len(errs) > 0 {
+			os.Exit(1)
+		}
+		os.Stdout.WriteString("Template is valid\r\n")
+	}
+
+	if syncTemplates {
+		config.Load(configPath)
+		templates.Initialize()
+		os.MkdirAll(templates.Folder, 0755)
+		result := templates.Sync()
+		for _, v := range result.Installed {
+			os.Stdout.WriteString("Installed " + v.Name + " " + v.Version + " from " + v.Source + "\r\n")
+		}
+		for _, v := range result.UpToDate {
+			os.Stdout.WriteString("Up to date " + v.Name + " " + v.Version + " from " + v.Source + "\r\n")
+		}
+		for _, v := range result.Failed {
+			os.Stdout.WriteString("Failed " + v.Name + " from " + v.Source + ": " + v.Message + "\r\n")
+			for _, e := range v.Errors {
+				os.Stdout.WriteString("  " + e.Field + ": " + e.Message + "\r\n")
+			}
+		}
+		if len(result.Failed) > 0 {
+			os.Exit(1)
+		}
+	}
+
+	if license || version || migrate || validateTemplate != "" || syncTemplates {
 		return
 	}
 
@@ -121,11 +164,15 @@ func main() {
 		replacements["authtoken"] = authToken
 		replacements["webport"] = webPort
 
-		configData := []byte(utils.ReplaceTokens(config, replacements))
+		configData, err := utils.ReplaceTokens(config, replacements)
+		if err != nil {
+			logging.Error("Error generating new config", err)
+			os.Exit(1)
+		}
 
 		var prettyJson bytes.Buffer
-		json.Indent(&prettyJson, configData, "", "  ")
-		err := ioutil.WriteFile(configPath, prettyJson.Bytes(), 0664)
+		json.Indent(&prettyJson, []byte(configData), "", "  ")
+		err = ioutil.WriteFile(configPath, prettyJson.Bytes(), 0664)
 
 		if err != nil {
 			logging.Error("Error writing new config")
@@ -142,6 +189,9 @@ func main() {
 
 	templates.Initialize()
 	programs.Initialize()
+	backup.Initialize()
+	scheduler.Start()
+	programs.StartQuotaChecks()
 
 	if _, err := os.Stat(templates.Folder); os.IsNotExist(err) {
 		logging.Info("No template directory found, creating")
@@ -0,0 +1,259 @@
+/*
+ Copyright 2016 Padduck, LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+ 	http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package query
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//Larger responses are not expected from a status request and are refused.
+const maxMinecraftPacket = 1 << 20
+
+//Colour and formatting codes, which are left out of the motd.
+var formattingCodes = regexp.MustCompile("§.?")
+
+type minecraftPing struct {
+	Version struct {
+		Name string `json:"name"`
+	} `json:"version"`
+	Players struct {
+		Max    int `json:"max"`
+		Online int `json:"online"`
+		Sample []struct {
+			Name string `json:"name"`
+		} `json:"sample"`
+	} `json:"players"`
+	Description interface{} `json:"description"`
+}
+
+//Asks a server for its status with the server list ping, as the game does when listing servers.
+//Only a sample of the player names is returned, which most servers limit to 12.
+func pingMinecraft(address string) (*Status, error) {
+	host, portText, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(portText)
+	if err != nil {
+		return nil, err
+	}
+
+	connection, err := net.DialTimeout("tcp", address, Timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer connection.Close()
+	connection.SetDeadline(time.Now().Add(Timeout))
+
+	handshake := &bytes.Buffer{}
+	writeVarInt(handshake, 0x00)
+	//-1 as the protocol version asks the server to report its own
+	writeVarInt(handshake, -1)
+	writeVarInt(handshake, int32(len(host)))
+	handshake.WriteString(host)
+	binary.Write(handshake, binary.BigEndian, uint16(port))
+	//the next state is status
+	writeVarInt(handshake, 1)
+
+	request := &bytes.Buffer{}
+	writeVarInt(request, int32(handshake.Len()))
+	handshake.WriteTo(request)
+	//the status request is an empty packet with id 0
+	request.Write([]byte{0x01, 0x00})
+	_, err = connection.Write(request.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	reader := bufio.NewReader(connection)
+	length, err := readVarInt(reader)
+	if err != nil {
+		return nil, err
+	}
+	if length <= 0 || length > maxMinecraftPacket {
+		return nil, errors.New("Invalid response length " + strconv.Itoa(int(length)))
+	}
+	packet := make([]byte, length)
+	_, err = io.ReadFull(reader, packet)
+	if err != nil {
+		return nil, err
+	}
+
+	body := bytes.NewReader(packet)
+	id, err := readVarInt(body)
+	if err != nil {
+		return nil, err
+	}
+	if id != 0x00 {
+		return nil, errors.New("Unexpected response packet " + strconv.Itoa(int(id)))
+	}
+	size, err := readVarInt(body)
+	if err != nil {
+		return nil, err
+	}
+	if size < 0 || int(size) > body.Len() {
+		return nil, errors.New("Invalid response length " + strconv.Itoa(int(size)))
+	}
+
+	var response minecraftPing
+	err = json.Unmarshal(packet[len(packet)-body.Len():][:size], &response)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &Status{
+		Motd:       strings.TrimSpace(formattingCodes.ReplaceAllString(chatText(response.Description), "")),
+		Version:    response.Version.Name,
+		Players:    response.Players.Online,
+		MaxPlayers: response.Players.Max,
+	}
+	for _, player := range response.Players.Sample {
+		status.PlayerNames = append(status.PlayerNames, player.Name)
+	}
+	return status, nil
+}
+
+//Flattens a chat component, which the description may be given as, to its text.
+func chatText(component interface{}) string {
+	switch value := component.(type) {
+	case string:
+		return value
+	case []interface{}:
+		text := ""
+		for _, v := range value {
+			text += chatText(v)
+		}
+		return text
+	case map[string]interface{}:
+		text, _ := value["text"].(string)
+		if extra, ok := value["extra"]; ok {
+			text += chatText(extra)
+		}
+		return text
+	}
+	return ""
+}
+
+func writeVarInt(buffer *bytes.Buffer, value int32) {
+	remaining := uint32(value)
+	for remaining >= 0x80 {
+		buffer.WriteByte(byte(remaining) | 0x80)
+		remaining >>= 7
+	}
+	buffer.WriteByte(byte(remaining))
+}
+
+func readVarInt(reader io.ByteReader) (int32, error) {
+	var value uint32
+	for i := uint(0); i < 5; i++ {
+		current, err := reader.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		value |= uint32(current&0x7F) << (7 * i)
+		if current&0x80 == 0 {
+			return int32(value), nil
+		}
+	}
+	return 0, errors.New("VarInt is too long")
+}
+
+//Asks a server for its full status over the query protocol, which includes every player name.
+func queryMinecraft(address string) (*Status, error) {
+	connection, err := net.DialTimeout("udp", address, Timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer connection.Close()
+	connection.SetDeadline(time.Now().Add(Timeout))
+
+	session := int32(time.Now().UnixNano()) & 0x0F0F0F0F
+	response, err := exchangeMinecraftQuery(connection, 0x09, session, nil)
+	if err != nil {
+		return nil, err
+	}
+	challenge, err := strconv.ParseInt(string(bytes.TrimRight(response, "\x00")), 10, 32)
+	if err != nil {
+		return nil, errors.New("Invalid challenge token")
+	}
+
+	payload := &bytes.Buffer{}
+	binary.Write(payload, binary.BigEndian, int32(challenge))
+	//padding asks for the full status rather than the basic one
+	payload.Write([]byte{0, 0, 0, 0})
+	response, err = exchangeMinecraftQuery(connection, 0x00, session, payload.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	//the key values start after a constant "splitnum" header
+	if len(response) < 11 {
+		return nil, errors.New("Response is too short")
+	}
+	parts := strings.Split(string(response[11:]), "\x00")
+
+	values := make(map[string]string)
+	i := 0
+	for ; i+1 < len(parts) && parts[i] != ""; i += 2 {
+		values[parts[i]] = parts[i+1]
+	}
+	status := &Status{
+		Motd:    strings.TrimSpace(formattingCodes.ReplaceAllString(values["hostname"], "")),
+		Map:     values["map"],
+		Version: values["version"],
+	}
+	status.Players, _ = strconv.Atoi(values["numplayers"])
+	status.MaxPlayers, _ = strconv.Atoi(values["maxplayers"])
+
+	//the players follow another constant header, "\x01player_\x00\x00"
+	for i += 3; i < len(parts) && parts[i] != ""; i++ {
+		status.PlayerNames = append(status.PlayerNames, parts[i])
+	}
+	return status, nil
+}
+
+//Sends a query packet and returns the payload of the response, after checking it answers the request.
+func exchangeMinecraftQuery(connection net.Conn, packetType byte, session int32, payload []byte) ([]byte, error) {
+	request := &bytes.Buffer{}
+	request.Write([]byte{0xFE, 0xFD, packetType})
+	binary.Write(request, binary.BigEndian, session)
+	request.Write(payload)
+	_, err := connection.Write(request.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	response := make([]byte, 65536)
+	n, err := connection.Read(response)
+	if err != nil {
+		return nil, err
+	}
+	response = response[:n]
+	if len(response) < 5 || response[0] != packetType || int32(binary.BigEndian.Uint32(response[1:5])) != session {
+		return nil, errors.New("Unexpected query response")
+	}
+	return response[5:], nil
+}
@@ -0,0 +1,76 @@
+/*
+ Copyright 2016 Padduck, LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+ 	http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+//Asks game servers for their status over the query protocols the games provide,
+//such as how many players are online and which map is loaded.
+package query
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	//Minecraft server list ping, over the game port
+	ProtocolMinecraft = "minecraft"
+	//Minecraft query, over udp when enable-query is set in server.properties
+	ProtocolMinecraftQuery = "minecraft-query"
+	//Source engine A2S_INFO and A2S_PLAYER
+	ProtocolSource = "source"
+)
+
+//How long a query has to complete.
+var Timeout = 3 * time.Second
+
+type Status struct {
+	Online      bool      `json:"online"`
+	Motd        string    `json:"motd,omitempty"`
+	Map         string    `json:"map,omitempty"`
+	Version     string    `json:"version,omitempty"`
+	Players     int       `json:"players"`
+	MaxPlayers  int       `json:"maxPlayers"`
+	PlayerNames []string  `json:"playerNames"`
+	Time        time.Time `json:"time"`
+}
+
+func ValidProtocol(protocol string) bool {
+	return protocol == ProtocolMinecraft || protocol == ProtocolMinecraftQuery || protocol == ProtocolSource
+}
+
+//Queries the server at the given address. The status returned is always online, failures are returned as errors.
+func Query(protocol string, address string) (*Status, error) {
+	var status *Status
+	var err error
+	switch protocol {
+	case ProtocolMinecraft:
+		status, err = pingMinecraft(address)
+	case ProtocolMinecraftQuery:
+		status, err = queryMinecraft(address)
+	case ProtocolSource:
+		status, err = querySource(address)
+	default:
+		return nil, errors.New("Unknown query protocol " + protocol)
+	}
+	if err != nil {
+		return nil, err
+	}
+	status.Online = true
+	status.Time = time.Now()
+	if status.PlayerNames == nil {
+		status.PlayerNames = []string{}
+	}
+	return status, nil
+}
@@ -0,0 +1,170 @@
+/*
+ Copyright 2016 Padduck, LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+ 	http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package query_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/pufferpanel/pufferd/query"
+)
+
+func TestQuery_Minecraft(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	go func() {
+		connection, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer connection.Close()
+		reader := bufio.NewReader(connection)
+		//the handshake, then the status request
+		for i := 0; i < 2; i++ {
+			length, _ := binary.ReadUvarint(reader)
+			io.CopyN(ioutil.Discard, reader, int64(length))
+		}
+		response := `{"version":{"name":"1.12.2","protocol":340},"players":{"max":20,"online":2,"sample":[{"name":"alice","id":"1"},{"name":"bob","id":"2"}]},"description":{"text":"§aA ","extra":[{"text":"server"}]}}`
+		packet := append([]byte{0x00}, uvarint(len(response))...)
+		packet = append(packet, response...)
+		connection.Write(append(uvarint(len(packet)), packet...))
+	}()
+
+	status, err := query.Query(query.ProtocolMinecraft, listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := query.Status{Online: true, Motd: "A server", Version: "1.12.2", Players: 2, MaxPlayers: 20, PlayerNames: []string{"alice", "bob"}, Time: status.Time}
+	if !reflect.DeepEqual(*status, expected) {
+		t.Errorf("Expected %+v but got %+v", expected, *status)
+	}
+}
+
+func TestQuery_MinecraftQuery(t *testing.T) {
+	connection := listenUdp(t, func(request []byte) [][]byte {
+		session := request[3:7]
+		switch {
+		case request[2] == 0x09:
+			return [][]byte{append(append([]byte{0x09}, session...), "9513307\x00"...)}
+		case request[2] == 0x00 && binary.BigEndian.Uint32(request[7:11]) == 9513307:
+			response := append([]byte{0x00}, session...)
+			response = append(response, "splitnum\x00\x80\x00"...)
+			response = append(response, "hostname\x00A Minecraft Server\x00map\x00world\x00numplayers\x002\x00maxplayers\x0020\x00\x00"...)
+			return [][]byte{append(response, "\x01player_\x00\x00alice\x00bob\x00\x00"...)}
+		}
+		return nil
+	})
+	defer connection.Close()
+
+	status, err := query.Query(query.ProtocolMinecraftQuery, connection.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := query.Status{Online: true, Motd: "A Minecraft Server", Map: "world", Players: 2, MaxPlayers: 20, PlayerNames: []string{"alice", "bob"}, Time: status.Time}
+	if !reflect.DeepEqual(*status, expected) {
+		t.Errorf("Expected %+v but got %+v", expected, *status)
+	}
+}
+
+func TestQuery_Source(t *testing.T) {
+	challenge := []byte{1, 2, 3, 4}
+	connection := listenUdp(t, func(request []byte) [][]byte {
+		header := []byte{0xFF, 0xFF, 0xFF, 0xFF}
+		if !bytes.HasSuffix(request, challenge) {
+			return [][]byte{append(append(header, 0x41), challenge...)}
+		}
+		if request[4] == 0x54 {
+			response := append(header, 0x49, 17)
+			response = append(response, "A Source Server\x00de_dust2\x00cstrike\x00Counter-Strike\x00"...)
+			response = append(response, 240, 0, 3, 24, 0, 'd', 'l', 0, 1)
+			return [][]byte{append(response, "1.0.0.0\x00"...)}
+		}
+		response := append(header, 0x44, 3)
+		for i, name := range []string{"alice", "", "bob"} {
+			response = append(response, byte(i))
+			response = append(response, name+"\x00"...)
+			response = append(response, make([]byte, 8)...)
+		}
+		//sent in two parts, the second first
+		first := append([]byte{0xFE, 0xFF, 0xFF, 0xFF, 7, 0, 0, 0, 2, 0, 0xE0, 0x04}, response[:20]...)
+		second := append([]byte{0xFE, 0xFF, 0xFF, 0xFF, 7, 0, 0, 0, 2, 1, 0xE0, 0x04}, response[20:]...)
+		return [][]byte{second, first}
+	})
+	defer connection.Close()
+
+	status, err := query.Query(query.ProtocolSource, connection.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := query.Status{Online: true, Motd: "A Source Server", Map: "de_dust2", Version: "1.0.0.0", Players: 3, MaxPlayers: 24, PlayerNames: []string{"alice", "bob"}, Time: status.Time}
+	if !reflect.DeepEqual(*status, expected) {
+		t.Errorf("Expected %+v but got %+v", expected, *status)
+	}
+}
+
+func TestQuery_NoAnswer(t *testing.T) {
+	connection := listenUdp(t, func(request []byte) [][]byte {
+		return nil
+	})
+	defer connection.Close()
+	defer func(timeout time.Duration) {
+		query.Timeout = timeout
+	}(query.Timeout)
+	query.Timeout = 100 * time.Millisecond
+
+	_, err := query.Query(query.ProtocolSource, connection.LocalAddr().String())
+	if err == nil {
+		t.Error("Expected query of a silent server to fail")
+	}
+}
+
+//Answers each packet sent to the returned connection with the packets returned by the handler.
+func listenUdp(t *testing.T, handler func(request []byte) [][]byte) net.PacketConn {
+	connection, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		buffer := make([]byte, 1500)
+		for {
+			n, address, err := connection.ReadFrom(buffer)
+			if err != nil {
+				return
+			}
+			for _, response := range handler(buffer[:n]) {
+				connection.WriteTo(response, address)
+			}
+		}
+	}()
+	return connection
+}
+
+func uvarint(value int) []byte {
+	buffer := make([]byte, binary.MaxVarintLen64)
+	return buffer[:binary.PutUvarint(buffer, uint64(value))]
+}
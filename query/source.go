@@ -0,0 +1,236 @@
+/*
+ Copyright 2016 Padduck, LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+ 	http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package query
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"time"
+)
+
+const (
+	sourceInfoRequest     = 0x54
+	sourceInfoResponse    = 0x49
+	sourcePlayersRequest  = 0x55
+	sourcePlayersResponse = 0x44
+	sourceChallenge       = 0x41
+)
+
+//The app id of The Ship, which puts extra fields in the middle of its info response.
+const theShipAppId = 2400
+
+//Asks a Source engine server for its info and players with A2S_INFO and A2S_PLAYER.
+func querySource(address string) (*Status, error) {
+	connection, err := net.DialTimeout("udp", address, Timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer connection.Close()
+	connection.SetDeadline(time.Now().Add(Timeout))
+
+	request := append([]byte{sourceInfoRequest}, "Source Engine Query\x00"...)
+	response, err := sourceRequest(connection, request, nil, sourceInfoResponse)
+	if err != nil {
+		return nil, err
+	}
+	reader := &packetReader{data: response}
+	reader.byte() //protocol
+	status := &Status{Motd: reader.string(), Map: reader.string()}
+	reader.string() //folder
+	reader.string() //game
+	appId := reader.short()
+	status.Players = int(reader.byte())
+	status.MaxPlayers = int(reader.byte())
+	reader.byte() //bots
+	reader.byte() //server type
+	reader.byte() //environment
+	reader.byte() //visibility
+	reader.byte() //vac
+	if appId == theShipAppId {
+		reader.skip(3)
+	}
+	status.Version = reader.string()
+	if reader.err != nil {
+		return nil, reader.err
+	}
+
+	response, err = sourceRequest(connection, []byte{sourcePlayersRequest}, []byte{0xFF, 0xFF, 0xFF, 0xFF}, sourcePlayersResponse)
+	if err != nil {
+		return nil, err
+	}
+	reader = &packetReader{data: response}
+	count := int(reader.byte())
+	for i := 0; i < count && reader.err == nil; i++ {
+		reader.byte() //index
+		name := reader.string()
+		reader.skip(8) //score and duration
+		//players still connecting have no name yet
+		if name != "" && reader.err == nil {
+			status.PlayerNames = append(status.PlayerNames, name)
+		}
+	}
+	if reader.err != nil {
+		return nil, reader.err
+	}
+	return status, nil
+}
+
+//Sends a request, answering a challenge if the server replies with one, and returns the response after its type.
+//The challenge is appended to the request, starting with the given placeholder.
+func sourceRequest(connection net.Conn, request []byte, challenge []byte, expected byte) ([]byte, error) {
+	//a server may answer with a challenge, which is then sent back with the request
+	for attempt := 0; attempt < 3; attempt++ {
+		packet := append([]byte{0xFF, 0xFF, 0xFF, 0xFF}, request...)
+		packet = append(packet, challenge...)
+		_, err := connection.Write(packet)
+		if err != nil {
+			return nil, err
+		}
+		response, err := readSourcePacket(connection)
+		if err != nil {
+			return nil, err
+		}
+		if len(response) == 0 {
+			return nil, errors.New("Empty response")
+		}
+		switch response[0] {
+		case expected:
+			return response[1:], nil
+		case sourceChallenge:
+			if len(response) < 5 {
+				return nil, errors.New("Invalid challenge")
+			}
+			challenge = response[1:5]
+		default:
+			return nil, errors.New("Unexpected response type")
+		}
+	}
+	return nil, errors.New("Server kept sending challenges")
+}
+
+//Reads a response, joining it back together if the server split it over several packets.
+func readSourcePacket(connection net.Conn) ([]byte, error) {
+	var parts [][]byte
+	var id int32
+	received := 0
+	for {
+		buffer := make([]byte, 65536)
+		n, err := connection.Read(buffer)
+		if err != nil {
+			return nil, err
+		}
+		reader := &packetReader{data: buffer[:n]}
+		switch reader.long() {
+		case -1:
+			if parts == nil {
+				return reader.data[reader.offset:], nil
+			}
+		case -2:
+			packetId := reader.long()
+			total := int(reader.byte())
+			number := int(reader.byte())
+			reader.short() //size
+			if reader.err != nil {
+				return nil, reader.err
+			}
+			if uint32(packetId)&0x80000000 != 0 {
+				return nil, errors.New("Compressed responses are not supported")
+			}
+			if parts == nil {
+				if total == 0 {
+					return nil, errors.New("Invalid split response")
+				}
+				parts = make([][]byte, total)
+				id = packetId
+			}
+			if packetId != id || total != len(parts) || number >= total {
+				return nil, errors.New("Invalid split response")
+			}
+			if parts[number] == nil {
+				parts[number] = reader.data[reader.offset:]
+				received++
+			}
+			if received == total {
+				joined := bytes.Join(parts, nil)
+				if len(joined) < 4 || int32(binary.LittleEndian.Uint32(joined)) != -1 {
+					return nil, errors.New("Invalid split response")
+				}
+				return joined[4:], nil
+			}
+			continue
+		}
+		return nil, errors.New("Invalid response header")
+	}
+}
+
+//Reads the little endian fields of a response, remembering the first error.
+type packetReader struct {
+	data   []byte
+	offset int
+	err    error
+}
+
+func (r *packetReader) skip(length int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if r.offset+length > len(r.data) {
+		r.err = errors.New("Response is too short")
+		return nil
+	}
+	field := r.data[r.offset : r.offset+length]
+	r.offset += length
+	return field
+}
+
+func (r *packetReader) byte() byte {
+	if field := r.skip(1); field != nil {
+		return field[0]
+	}
+	return 0
+}
+
+func (r *packetReader) short() int16 {
+	if field := r.skip(2); field != nil {
+		return int16(binary.LittleEndian.Uint16(field))
+	}
+	return 0
+}
+
+func (r *packetReader) long() int32 {
+	if field := r.skip(4); field != nil {
+		return int32(binary.LittleEndian.Uint32(field))
+	}
+	return 0
+}
+
+//Reads a null terminated string.
+func (r *packetReader) string() string {
+	if r.err != nil {
+		return ""
+	}
+	end := bytes.IndexByte(r.data[r.offset:], 0)
+	if end < 0 {
+		r.err = errors.New("Response is too short")
+		return ""
+	}
+	value := string(r.data[r.offset : r.offset+end])
+	r.offset += end + 1
+	return value
+}
@@ -0,0 +1,155 @@
+/*
+ Copyright 2016 Padduck, LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+ 	http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+//Limits how much disk a server can use. Usage is measured by walking the server root, and writes made through
+//pufferd are added to it as they happen so they can be checked without walking the root each time.
+//Writes which race each other, or files changed by the server itself, can take usage past the limit
+//until the next measurement catches up.
+package quota
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/pufferpanel/pufferd/utils"
+)
+
+var ErrExceeded = errors.New("Disk quota exceeded")
+
+//How long a measurement of usage is used before the root is walked again.
+var MaxAge = time.Minute
+
+type Quota struct {
+	root  string
+	limit int64
+	block bool
+
+	lock     sync.Mutex
+	used     int64
+	measured time.Time
+}
+
+//Creates a quota for a root. A limit of 0 only measures usage. Without block set, writes
+//past the limit are allowed, leaving it to the caller to warn about or act on the usage.
+func New(root string, limit int64, block bool) *Quota {
+	return &Quota{root: root, limit: limit, block: block}
+}
+
+//Returns the limit in bytes, or 0 if there is none.
+func (q *Quota) Limit() int64 {
+	return q.limit
+}
+
+//Returns the bytes used under the root, measuring it if the last measurement is too old.
+func (q *Quota) Used() int64 {
+	q.lock.Lock()
+	used, measured := q.used, q.measured
+	q.lock.Unlock()
+	if time.Since(measured) < MaxAge {
+		return used
+	}
+	used, _ = q.Measure()
+	return used
+}
+
+//Returns the bytes used under the root as last measured, with the writes made since, without walking the root.
+func (q *Quota) Cached() int64 {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return q.used
+}
+
+//Walks the root to measure the bytes used under it.
+func (q *Quota) Measure() (int64, error) {
+	used, err := utils.DirectorySize(q.root)
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if err != nil {
+		return q.used, err
+	}
+	q.used = used
+	q.measured = time.Now()
+	return used, nil
+}
+
+func (q *Quota) Exceeded() bool {
+	return q != nil && q.limit > 0 && q.Used() > q.limit
+}
+
+//Returns how many more bytes can be written, or -1 if writes are not limited.
+func (q *Quota) Remaining() int64 {
+	if q == nil || q.limit <= 0 || !q.block {
+		return -1
+	}
+	remaining := q.limit - q.Used()
+	if remaining < 0 {
+		return 0
+	}
+	return remaining
+}
+
+//Fails with ErrExceeded if a write of size bytes would not fit.
+func (q *Quota) Check(size int64) error {
+	remaining := q.Remaining()
+	if remaining >= 0 && size > remaining {
+		return ErrExceeded
+	}
+	return nil
+}
+
+//Records bytes written under the root, or freed if size is negative.
+func (q *Quota) Add(size int64) {
+	if q == nil {
+		return
+	}
+	q.lock.Lock()
+	q.used += size
+	if q.used < 0 {
+		q.used = 0
+	}
+	q.lock.Unlock()
+}
+
+//Wraps a writer so it fails with ErrExceeded rather than write more than fits, where free is the number
+//of bytes the write replaces which are already counted. Nothing is recorded until Add is called.
+func (q *Quota) Writer(writer io.Writer, free int64) io.Writer {
+	remaining := q.Remaining()
+	if remaining < 0 {
+		return writer
+	}
+	return &limitedWriter{writer: writer, left: remaining + free}
+}
+
+type limitedWriter struct {
+	writer io.Writer
+	left   int64
+}
+
+func (l *limitedWriter) Write(data []byte) (int, error) {
+	if int64(len(data)) <= l.left {
+		n, err := l.writer.Write(data)
+		l.left -= int64(n)
+		return n, err
+	}
+	n, err := l.writer.Write(data[:l.left])
+	l.left -= int64(n)
+	if err == nil {
+		err = ErrExceeded
+	}
+	return n, err
+}
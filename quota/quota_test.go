@@ -0,0 +1,76 @@
+/*
+ Copyright 2016 Padduck, LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+ 	http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package quota_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pufferpanel/pufferd/quota"
+)
+
+func TestQuota(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pufferd-quota")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "world.dat"), make([]byte, 60), 0644)
+
+	q := quota.New(dir, 100, true)
+	if used := q.Cached(); used != 0 {
+		t.Errorf("Expected nothing cached before measuring but got %d", used)
+	}
+	if used := q.Used(); used != 60 {
+		t.Errorf("Expected 60 bytes used but got %d", used)
+	}
+	if remaining := q.Remaining(); remaining != 40 {
+		t.Errorf("Expected 40 bytes remaining but got %d", remaining)
+	}
+	if err := q.Check(41); err != quota.ErrExceeded {
+		t.Errorf("Expected a write past the limit to fail but got %v", err)
+	}
+
+	var buffer bytes.Buffer
+	n, err := q.Writer(&buffer, 5).Write(make([]byte, 50))
+	if err != quota.ErrExceeded || n != 45 {
+		t.Errorf("Expected 45 bytes then an error but got %d, %v", n, err)
+	}
+
+	q.Add(45)
+	if used := q.Cached(); used != 105 {
+		t.Errorf("Expected the write to be added to the cached usage but got %d", used)
+	}
+	if !q.Exceeded() {
+		t.Error("Expected the quota to be exceeded after recording a write")
+	}
+	if used, err := q.Measure(); err != nil || used != 60 {
+		t.Errorf("Expected measuring to find 60 bytes but got %d, %v", used, err)
+	}
+
+	warn := quota.New(dir, 10, false)
+	if warn.Remaining() != -1 || warn.Check(1000) != nil || !warn.Exceeded() {
+		t.Error("Expected a quota which does not block to allow writes while reporting it is exceeded")
+	}
+	var unlimited *quota.Quota
+	if unlimited.Remaining() != -1 || unlimited.Exceeded() {
+		t.Error("Expected no quota to allow everything")
+	}
+}
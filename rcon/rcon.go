@@ -0,0 +1,190 @@
+/*
+ Copyright 2016 Padduck, LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+ 	http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+//Sends commands to game servers over RCON, for servers which do not read commands from stdin reliably.
+//Source and Minecraft servers speak the same protocol, but only Source servers answer the empty packet
+//used to find the end of a response split over several packets.
+package rcon
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+const (
+	ProtocolSource    = "source"
+	ProtocolMinecraft = "minecraft"
+)
+
+const (
+	packetResponse     = 0
+	packetCommand      = 2
+	packetAuthResponse = 2
+	packetAuth         = 3
+)
+
+//Servers send at most 4096 bytes of body in a packet, anything much larger is not RCON.
+const maxPacketSize = 1 << 16
+
+//How long connecting, and each command, has to complete.
+var Timeout = 5 * time.Second
+
+var ErrAuthentication = errors.New("RCON password was rejected")
+
+//Returned when the server closes the connection after a command was sent, as it does for commands which stop it.
+var ErrClosed = errors.New("RCON connection was closed before a response was received")
+
+//Returned when the server closes the connection before accepting the password, so nothing was sent.
+var ErrConnection = errors.New("RCON connection was closed while authenticating")
+
+type Client struct {
+	connection net.Conn
+	reader     *bufio.Reader
+	protocol   string
+	lastId     int32
+}
+
+//Connects to a server and authenticates with the password.
+func Dial(protocol string, address string, password string) (*Client, error) {
+	if protocol != ProtocolSource && protocol != ProtocolMinecraft {
+		return nil, errors.New("Unknown RCON protocol " + protocol)
+	}
+	connection, err := net.DialTimeout("tcp", address, Timeout)
+	if err != nil {
+		return nil, err
+	}
+	client := &Client{connection: connection, reader: bufio.NewReader(connection), protocol: protocol}
+	err = client.authenticate(password)
+	if err != nil {
+		connection.Close()
+		return nil, err
+	}
+	return client, nil
+}
+
+func (c *Client) authenticate(password string) error {
+	c.connection.SetDeadline(time.Now().Add(Timeout))
+	id, err := c.write(packetAuth, password)
+	if err != nil {
+		return err
+	}
+	for {
+		responseId, responseType, _, err := c.read()
+		if err != nil {
+			return readError(err, ErrConnection)
+		}
+		//Source servers send an empty response before the result of authenticating
+		if responseType != packetAuthResponse {
+			continue
+		}
+		if responseId == -1 {
+			return ErrAuthentication
+		}
+		if responseId == id {
+			return nil
+		}
+	}
+}
+
+//Runs a command and returns the response of the server.
+func (c *Client) Execute(command string) (string, error) {
+	c.connection.SetDeadline(time.Now().Add(Timeout))
+	id, err := c.write(packetCommand, command)
+	if err != nil {
+		return "", err
+	}
+
+	if c.protocol == ProtocolMinecraft {
+		for {
+			responseId, _, body, err := c.read()
+			if err != nil {
+				return "", readError(err, ErrClosed)
+			}
+			if responseId == id {
+				return body, nil
+			}
+		}
+	}
+
+	//Source servers answer an empty response packet after they have sent the whole response to the command
+	end, err := c.write(packetResponse, "")
+	if err != nil {
+		return "", err
+	}
+	response := &bytes.Buffer{}
+	for {
+		responseId, _, body, err := c.read()
+		if err != nil {
+			return "", readError(err, ErrClosed)
+		}
+		switch responseId {
+		case id:
+			response.WriteString(body)
+		case end:
+			return response.String(), nil
+		}
+	}
+}
+
+func (c *Client) Close() error {
+	return c.connection.Close()
+}
+
+func (c *Client) write(packetType int32, body string) (int32, error) {
+	c.lastId++
+	packet := &bytes.Buffer{}
+	binary.Write(packet, binary.LittleEndian, int32(len(body)+10))
+	binary.Write(packet, binary.LittleEndian, c.lastId)
+	binary.Write(packet, binary.LittleEndian, packetType)
+	packet.WriteString(body)
+	packet.Write([]byte{0, 0})
+	_, err := c.connection.Write(packet.Bytes())
+	return c.lastId, err
+}
+
+func (c *Client) read() (id int32, packetType int32, body string, err error) {
+	var size int32
+	err = binary.Read(c.reader, binary.LittleEndian, &size)
+	if err != nil {
+		return 0, 0, "", err
+	}
+	if size < 10 || size > maxPacketSize {
+		return 0, 0, "", errors.New("Invalid RCON packet size " + strconv.Itoa(int(size)))
+	}
+	packet := make([]byte, size)
+	_, err = io.ReadFull(c.reader, packet)
+	if err != nil {
+		return 0, 0, "", err
+	}
+	id = int32(binary.LittleEndian.Uint32(packet))
+	packetType = int32(binary.LittleEndian.Uint32(packet[4:]))
+	body = string(bytes.TrimRight(packet[8:], "\x00"))
+	return
+}
+
+//Reports a failed read as the given closed error, which includes the connection being reset, unless it timed out.
+func readError(err error, closed error) error {
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return err
+	}
+	return closed
+}
@@ -0,0 +1,189 @@
+/*
+ Copyright 2016 Padduck, LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+ 	http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package rcon_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pufferpanel/pufferd/rcon"
+)
+
+//A stand-in RCON server, answering "list" with a response split over two packets for Source servers
+//and closing the connection on "stop".
+func listen(t *testing.T, protocol string, password string) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			connection, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serve(connection, protocol, password)
+		}
+	}()
+	return listener
+}
+
+func serve(connection net.Conn, protocol string, password string) {
+	defer connection.Close()
+	for {
+		var size int32
+		if binary.Read(connection, binary.LittleEndian, &size) != nil {
+			return
+		}
+		packet := make([]byte, size)
+		if _, err := io.ReadFull(connection, packet); err != nil {
+			return
+		}
+		id := int32(binary.LittleEndian.Uint32(packet))
+		packetType := int32(binary.LittleEndian.Uint32(packet[4:]))
+		body := string(bytes.TrimRight(packet[8:], "\x00"))
+
+		switch {
+		case packetType == 3 && body == "drop":
+			return
+		case packetType == 3:
+			if protocol == rcon.ProtocolSource {
+				write(connection, id, 0, "")
+			}
+			if body != password {
+				id = -1
+			}
+			write(connection, id, 2, "")
+		case packetType == 2 && body == "stop":
+			return
+		case packetType == 2 && body == "hang":
+			//as a server busy saving a large world, nothing is answered in time
+			continue
+		case packetType == 2 && body == "list" && protocol == rcon.ProtocolSource:
+			write(connection, id, 0, "There are 2 players: ")
+			write(connection, id, 0, "alice, bob")
+		case packetType == 2:
+			write(connection, id, 0, "Ran "+body)
+		case packetType == 0:
+			write(connection, id, 0, "")
+			//as srcds does, an odd packet follows the mirrored one
+			write(connection, id, 0, "\x00\x01")
+		}
+	}
+}
+
+func write(connection net.Conn, id int32, packetType int32, body string) {
+	packet := &bytes.Buffer{}
+	binary.Write(packet, binary.LittleEndian, int32(len(body)+10))
+	binary.Write(packet, binary.LittleEndian, id)
+	binary.Write(packet, binary.LittleEndian, packetType)
+	packet.WriteString(body)
+	packet.Write([]byte{0, 0})
+	connection.Write(packet.Bytes())
+}
+
+func TestClient_Source(t *testing.T) {
+	listener := listen(t, rcon.ProtocolSource, "secret")
+	defer listener.Close()
+
+	client, err := rcon.Dial(rcon.ProtocolSource, listener.Addr().String(), "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	for _, command := range []string{"list", "status", "list"} {
+		response, err := client.Execute(command)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := "Ran " + command
+		if command == "list" {
+			expected = "There are 2 players: alice, bob"
+		}
+		if response != expected {
+			t.Errorf("Expected response %q but got %q", expected, response)
+		}
+	}
+
+	_, err = client.Execute("stop")
+	if err != rcon.ErrClosed {
+		t.Errorf("Expected closed connection but got %v", err)
+	}
+}
+
+func TestClient_NoAnswer(t *testing.T) {
+	listener := listen(t, rcon.ProtocolMinecraft, "secret")
+	defer listener.Close()
+	timeout := rcon.Timeout
+	rcon.Timeout = 100 * time.Millisecond
+	defer func() {
+		rcon.Timeout = timeout
+	}()
+
+	client, err := rcon.Dial(rcon.ProtocolMinecraft, listener.Addr().String(), "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	_, err = client.Execute("hang")
+	netErr, ok := err.(net.Error)
+	if !ok || !netErr.Timeout() {
+		t.Errorf("Expected the command to time out but got %v", err)
+	}
+}
+
+func TestClient_Minecraft(t *testing.T) {
+	listener := listen(t, rcon.ProtocolMinecraft, "secret")
+	defer listener.Close()
+
+	client, err := rcon.Dial(rcon.ProtocolMinecraft, listener.Addr().String(), "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	response, err := client.Execute("say hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response != "Ran say hello" {
+		t.Errorf("Unexpected response %q", response)
+	}
+}
+
+func TestClient_WrongPassword(t *testing.T) {
+	for _, protocol := range []string{rcon.ProtocolSource, rcon.ProtocolMinecraft} {
+		listener := listen(t, protocol, "secret")
+		_, err := rcon.Dial(protocol, listener.Addr().String(), "wrong")
+		if err != rcon.ErrAuthentication {
+			t.Errorf("Expected %s authentication to fail but got %v", protocol, err)
+		}
+		//the connection closing before the password is accepted is not a command stopping the server
+		_, err = rcon.Dial(protocol, listener.Addr().String(), "drop")
+		if err != rcon.ErrConnection {
+			t.Errorf("Expected %s authentication to be cut off but got %v", protocol, err)
+		}
+		listener.Close()
+	}
+}
+
@@ -17,11 +17,18 @@
 package routing
 
 import (
+	"io"
+	"time"
+
 	"github.com/braintree/manners"
 	"github.com/gin-gonic/gin"
+	"github.com/pufferpanel/pufferd/backup"
+	"github.com/pufferpanel/pufferd/data/templates"
 	"github.com/pufferpanel/pufferd/httphandlers"
+	"github.com/pufferpanel/pufferd/jobs"
 	"github.com/pufferpanel/pufferd/logging"
 	"github.com/pufferpanel/pufferd/programs"
+	"github.com/pufferpanel/pufferd/sftp"
 	"github.com/pufferpanel/pufferd/utils"
 )
 
@@ -31,6 +38,12 @@ func RegisterRoutes(e *gin.Engine) {
 		c.String(200, "pufferd is running")
 	})
 	e.GET("/templates", GetTemplates)
+	e.POST("/templates/sync", httphandlers.OAuth2Handler, SyncTemplates)
+	e.GET("/quarantine", httphandlers.OAuth2Handler, GetQuarantined)
+	e.POST("/quarantine/:id/retry", httphandlers.OAuth2Handler, RetryQuarantined)
+	e.DELETE("/quarantine/:id", httphandlers.OAuth2Handler, DeleteQuarantined)
+	e.POST("/backups/gc", httphandlers.OAuth2Handler, CollectBackupGarbage)
+	e.GET("/job/:id", httphandlers.OAuth2Handler, GetJob)
 	e.GET("_shutdown", httphandlers.OAuth2Handler, Shutdown)
 }
 
@@ -54,6 +67,123 @@ func GetTemplates(c *gin.Context) {
 	c.JSON(200, programs.GetPlugins())
 }
 
+func SyncTemplates(c *gin.Context) {
+	if !hasScope(c, "node.templates") {
+		c.AbortWithStatus(401)
+		return
+	}
+
+	c.JSON(200, templates.Sync())
+}
+
+func GetQuarantined(c *gin.Context) {
+	if !hasScope(c, "node.quarantine") {
+		c.AbortWithStatus(401)
+		return
+	}
+
+	c.JSON(200, programs.GetQuarantined())
+}
+
+func RetryQuarantined(c *gin.Context) {
+	if !hasScope(c, "node.quarantine") {
+		c.AbortWithStatus(401)
+		return
+	}
+
+	id := c.Param("id")
+	if !programs.IsQuarantined(id) {
+		c.AbortWithStatus(404)
+		return
+	}
+
+	err := programs.RetryQuarantined(id)
+	if errs, ok := err.(utils.ValidationErrors); ok {
+		result := make(map[string]interface{})
+		result["errors"] = errs
+		c.JSON(400, result)
+		c.Abort()
+		return
+	}
+	if err != nil {
+		c.AbortWithError(500, err)
+		return
+	}
+	c.Status(204)
+}
+
+//Deletes a quarantined server which cannot be fixed, freeing its id.
+func DeleteQuarantined(c *gin.Context) {
+	if !hasScope(c, "node.quarantine") {
+		c.AbortWithStatus(401)
+		return
+	}
+
+	id := c.Param("id")
+	if !programs.IsQuarantined(id) {
+		c.AbortWithStatus(404)
+		return
+	}
+
+	err := programs.DeleteQuarantined(id)
+	if err != nil {
+		c.AbortWithError(500, err)
+		return
+	}
+	err = sftp.DeleteKeys(id)
+	if err != nil {
+		logging.Error("Error deleting SFTP keys of server "+id, err)
+	}
+	c.Status(204)
+}
+
+//Removes the snapshot chunks no backup uses any more, returning the job doing it.
+func CollectBackupGarbage(c *gin.Context) {
+	if !hasScope(c, "node.backups") {
+		c.AbortWithStatus(401)
+		return
+	}
+
+	c.JSON(202, backup.StartGarbageCollection().Status())
+}
+
+//Returns the status of a job. With follow=true the status is streamed as server-sent events until the job finishes.
+func GetJob(c *gin.Context) {
+	job := jobs.Get(c.Param("id"))
+	if job == nil {
+		c.AbortWithStatus(404)
+		return
+	}
+
+	accessId, _ := c.Get("server_id")
+	if accessId != "*" && accessId != job.ServerId() {
+		c.AbortWithStatus(401)
+		return
+	}
+
+	if c.Query("follow") != "true" {
+		c.JSON(200, job.Status())
+		return
+	}
+
+	c.Stream(func(w io.Writer) bool {
+		updated := job.Updated()
+		status := job.Status()
+		c.SSEvent("status", status)
+		if status.Status != jobs.StatusRunning {
+			return false
+		}
+		select {
+		case <-updated:
+		case <-c.Request.Context().Done():
+			return false
+		}
+		//limit how often progress is sent for jobs which update constantly
+		time.Sleep(250 * time.Millisecond)
+		return true
+	})
+}
+
 func hasScope(gin *gin.Context, scope string) bool {
 	scopes, _ := gin.Get("scopes")
 	return utils.ContainsValue(scopes.([]string), scope)
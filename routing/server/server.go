@@ -28,9 +28,14 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
 	"github.com/itsjamie/gin-cors"
+	"github.com/pufferpanel/pufferd/backup"
+	"github.com/pufferpanel/pufferd/events"
+	"github.com/pufferpanel/pufferd/files"
 	"github.com/pufferpanel/pufferd/httphandlers"
 	"github.com/pufferpanel/pufferd/logging"
 	"github.com/pufferpanel/pufferd/programs"
+	"github.com/pufferpanel/pufferd/scheduler"
+	"github.com/pufferpanel/pufferd/sftp"
 	"github.com/pufferpanel/pufferd/utils"
 	"github.com/pkg/errors"
 	"strings"
@@ -61,9 +66,46 @@ func RegisterRoutes(e *gin.Engine) {
 		l.POST("/:id/install", InstallServer)
 		l.GET("/:id/file/*filename", GetFile)
 		l.PUT("/:id/file/*filename", PutFile)
+		l.DELETE("/:id/file/*filename", DeleteFile)
+		l.POST("/:id/file/delete", DeleteFiles)
+		l.POST("/:id/file/move", MoveFiles)
+		l.POST("/:id/file/copy", CopyFiles)
+		l.POST("/:id/file/mkdir", MakeDirectories)
+		l.POST("/:id/file/chmod", ChmodFiles)
+		l.POST("/:id/file/extract", ExtractFiles)
+		l.GET("/:id/archive", DownloadArchive)
+		l.GET("/:id/search", SearchFiles)
+		l.POST("/:id/uploads", CreateUpload)
+		l.GET("/:id/uploads/:upload", GetUpload)
+		l.PUT("/:id/uploads/:upload", PutUploadChunk)
+		l.POST("/:id/uploads/:upload/commit", CommitUpload)
+		l.DELETE("/:id/uploads/:upload", AbortUpload)
 		l.POST("/:id/console", PostConsole)
 		l.GET("/:id/stats", GetStats)
 		l.POST("/:id/reload", ReloadServer)
+		l.GET("/:id/template/diff", DiffServerTemplate)
+		l.POST("/:id/template/upgrade", UpgradeServerTemplate)
+		l.POST("/:id/clone", CloneServer)
+		l.GET("/:id/backups", ListBackups)
+		l.POST("/:id/backups", CreateBackup)
+		l.GET("/:id/backups/:backup/download", DownloadBackup)
+		l.POST("/:id/backups/:backup/restore", RestoreBackup)
+		l.POST("/:id/backups/:backup/verify", VerifyBackup)
+		l.DELETE("/:id/backups/:backup", DeleteBackup)
+		l.GET("/:id/backup-settings", GetBackupSettings)
+		l.PUT("/:id/backup-settings", PutBackupSettings)
+		l.GET("/:id/schedules", ListSchedules)
+		l.GET("/:id/schedules/:name", GetSchedule)
+		l.PUT("/:id/schedules/:name", PutSchedule)
+		l.DELETE("/:id/schedules/:name", DeleteSchedule)
+		l.POST("/:id/schedules/:name/run", RunSchedule)
+		l.GET("/:id/triggers", GetTriggers)
+		l.PUT("/:id/triggers", PutTriggers)
+		l.GET("/:id/sftp/keys", ListSftpKeys)
+		l.POST("/:id/sftp/keys", AddSftpKey)
+		l.DELETE("/:id/sftp/keys/:key", DeleteSftpKey)
+		l.GET("/:id/status", GetStatus)
+		l.GET("/:id/events", GetEvents)
 		l.GET("/:id/console", cors.Middleware(cors.Config{
 			Origins:     "*",
 			Credentials: true,
@@ -81,7 +123,24 @@ func StartServer(c *gin.Context) {
 		return
 	}
 
-	existing.Start()
+	err := existing.Start()
+	if err != nil {
+		c.AbortWithError(500, err)
+		return
+	}
+
+	if c.Query("wait") == "true" {
+		err = existing.WaitUntilReady()
+		if notReady, ok := err.(*programs.NotReadyError); ok {
+			c.JSON(503, notReady)
+			c.Abort()
+			return
+		}
+		if err != nil {
+			c.AbortWithError(500, err)
+			return
+		}
+	}
 }
 
 func StopServer(c *gin.Context) {
@@ -114,7 +173,7 @@ func CreateServer(c *gin.Context) {
 
 	existing := programs.GetFromCache(serverId)
 
-	if existing != nil {
+	if existing != nil || programs.IsQuarantined(serverId) {
 		c.AbortWithStatus(409)
 		return
 	}
@@ -128,10 +187,16 @@ func CreateServer(c *gin.Context) {
 		return
 	}
 
-	serverType := data["type"].(string)
+	serverType, ok := data["type"].(string)
+	if !ok || serverType == "" {
+		c.AbortWithError(400, errors.New("Server type required"))
+		return
+	}
+	delete(data, "type")
 
-	if !programs.Create(serverId, serverType, data) {
-		c.AbortWithStatus(500)
+	err = programs.Create(serverId, serverType, data)
+	if err != nil {
+		handleProgramError(c, err)
 	}
 }
 
@@ -142,7 +207,16 @@ func DeleteServer(c *gin.Context) {
 		return
 	}
 
-	programs.Delete(existing.Id())
+	err := programs.Delete(existing.Id())
+	if err != nil {
+		handleProgramError(c, err)
+		return
+	}
+	//the keys are only removed once the server is gone, a failed delete leaves it as it was
+	err = sftp.DeleteKeys(existing.Id())
+	if err != nil {
+		logging.Error("Error deleting SFTP keys of server "+existing.Id(), err)
+	}
 	c.Status(204)
 }
 
@@ -167,10 +241,19 @@ func EditServer(c *gin.Context) {
 	}
 
 	data := make(map[string]interface{}, 0)
-	json.NewDecoder(c.Request.Body).Decode(&data)
+	err := json.NewDecoder(c.Request.Body).Decode(&data)
+	if err != nil {
+		logging.Error("Error decoding JSON body", err)
+		c.AbortWithError(400, err)
+		return
+	}
 
+	err = existing.Edit(data)
+	if err != nil {
+		handleProgramError(c, err)
+		return
+	}
 	c.Status(200)
-	existing.Edit(data)
 }
 
 func GetFile(c *gin.Context) {
@@ -183,21 +266,21 @@ func GetFile(c *gin.Context) {
 
 	targetPath := c.Param("filename")
 
-	targetFile := utils.JoinPath(server.GetEnvironment().GetRootDirectory(), targetPath)
-
-	if !utils.EnsureAccess(targetFile, server.GetEnvironment().GetRootDirectory()) {
+	file, err := files.Open(server.GetEnvironment().GetRootDirectory(), targetPath)
+	if err != nil {
+		handleFileError(c, err)
 		return
 	}
+	defer file.Close()
 
-	info, err := os.Stat(targetFile)
-
-	if os.IsNotExist(err) {
-		c.Status(404)
+	info, err := file.Stat()
+	if err != nil {
+		handleFileError(c, err)
 		return
 	}
 
 	if info.IsDir() {
-		files, _ := ioutil.ReadDir(targetFile)
+		files, _ := file.Readdir(-1)
 		fileNames := make([]interface{}, 0)
 		for _, file := range files {
 			type FileDesc struct {
@@ -219,7 +302,9 @@ func GetFile(c *gin.Context) {
 		}
 		c.JSON(200, fileNames)
 	} else {
-		c.File(targetFile)
+		//with an etag, ranges can be resumed with If-Range and unchanged files skipped with If-None-Match
+		c.Header("ETag", "\""+strconv.FormatInt(info.ModTime().UnixNano(), 16)+"-"+strconv.FormatInt(info.Size(), 16)+"\"")
+		http.ServeContent(c.Writer, c.Request, info.Name(), info.ModTime(), file)
 	}
 }
 
@@ -237,24 +322,375 @@ func PutFile(c *gin.Context) {
 		return
 	}
 
-	targetFile := utils.JoinPath(server.GetEnvironment().GetRootDirectory(), targetPath)
+	err := files.WriteFile(server.GetEnvironment().GetRootDirectory(), targetPath, c.Request.Body, server.GetQuota())
+	if err != nil {
+		handleFileError(c, err)
+		return
+	}
+	c.Status(200)
+}
+
+func DeleteFile(c *gin.Context) {
+	valid, server := handleInitialCallServer(c, "server.file.delete", true)
+
+	if !valid {
+		return
+	}
+
+	err := files.Delete(server.GetEnvironment().GetRootDirectory(), c.Param("filename"))
+	if err != nil {
+		handleFileError(c, err)
+		return
+	}
+	c.Status(204)
+}
+
+//The body of the bulk file operations. Target is used by move and copy, mode and recursive by chmod.
+type fileRequest struct {
+	Paths     []string `json:"paths"`
+	Target    string   `json:"target"`
+	Mode      string   `json:"mode"`
+	Recursive bool     `json:"recursive"`
+}
+
+func DeleteFiles(c *gin.Context) {
+	server, request, ok := decodeFileRequest(c, "server.file.delete")
+	if !ok {
+		return
+	}
+	root := server.GetEnvironment().GetRootDirectory()
+	respondFileErrors(c, files.Each(request.Paths, func(path string) error {
+		return files.Delete(root, path)
+	}))
+}
+
+func MoveFiles(c *gin.Context) {
+	server, request, ok := decodeFileRequest(c, "server.file.put")
+	if !ok {
+		return
+	}
+	root := server.GetEnvironment().GetRootDirectory()
+	if len(request.Paths) > 1 {
+		if err := files.CheckDirectory(root, request.Target); err != nil {
+			handleFileError(c, err)
+			return
+		}
+	}
+	respondFileErrors(c, files.Each(request.Paths, func(path string) error {
+		return files.Move(root, path, request.Target)
+	}))
+}
+
+func CopyFiles(c *gin.Context) {
+	server, request, ok := decodeFileRequest(c, "server.file.put")
+	if !ok {
+		return
+	}
+	root := server.GetEnvironment().GetRootDirectory()
+	if len(request.Paths) > 1 {
+		if err := files.CheckDirectory(root, request.Target); err != nil {
+			handleFileError(c, err)
+			return
+		}
+	}
+	respondFileErrors(c, files.Each(request.Paths, func(path string) error {
+		return files.Copy(root, path, request.Target, server.GetQuota())
+	}))
+}
+
+func MakeDirectories(c *gin.Context) {
+	server, request, ok := decodeFileRequest(c, "server.file.put")
+	if !ok {
+		return
+	}
+	root := server.GetEnvironment().GetRootDirectory()
+	respondFileErrors(c, files.Each(request.Paths, func(path string) error {
+		return files.Mkdir(root, path)
+	}))
+}
+
+func ChmodFiles(c *gin.Context) {
+	server, request, ok := decodeFileRequest(c, "server.file.put")
+	if !ok {
+		return
+	}
+	root := server.GetEnvironment().GetRootDirectory()
+	mode, err := strconv.ParseUint(request.Mode, 8, 32)
+	if err != nil {
+		handleFileError(c, &files.Error{Code: files.CodeInvalid, Message: "Mode must be given in octal, such as 0644"})
+		return
+	}
+	respondFileErrors(c, files.Each(request.Paths, func(path string) error {
+		return files.Chmod(root, path, os.FileMode(mode), request.Recursive)
+	}))
+}
+
+//Extracts an archive into the directory given by target, which defaults to the server root.
+//The archive is the file given by path, or else the request body. Overwrite is fail, skip or overwrite.
+func ExtractFiles(c *gin.Context) {
+	valid, server := handleInitialCallServer(c, "server.file.put", true)
+
+	if !valid {
+		return
+	}
+
+	root := server.GetEnvironment().GetRootDirectory()
+	target := c.Query("target")
+	policy := c.Query("overwrite")
+	var result files.ExtractResult
+	var err error
+	if archive := c.Query("path"); archive != "" {
+		result, err = files.ExtractFile(root, archive, target, policy, server.GetQuota())
+	} else {
+		result, err = files.Extract(root, c.Request.Body, target, policy, server.GetQuota())
+	}
+	if err != nil {
+		handleFileError(c, err)
+		return
+	}
+	c.JSON(200, result)
+}
+
+//Streams a zip, or with format=tar.gz a tar.gz, of the paths given as path query parameters.
+func DownloadArchive(c *gin.Context) {
+	valid, server := handleInitialCallServer(c, "server.file.get", true)
+
+	if !valid {
+		return
+	}
+
+	paths := c.Request.URL.Query()["path"]
+	if len(paths) == 0 {
+		handleFileError(c, &files.Error{Code: files.CodeInvalid, Message: "No paths given"})
+		return
+	}
+	format := c.DefaultQuery("format", files.FormatZip)
+	name := server.Id()
+	if len(paths) == 1 && filepath.Base(paths[0]) != "/" && filepath.Base(paths[0]) != "." {
+		name = filepath.Base(paths[0])
+	}
+
+	writer := &downloadWriter{context: c, filename: name + "." + format}
+	err := files.WriteArchive(writer, server.GetEnvironment().GetRootDirectory(), paths, format)
+	if err != nil && !writer.started {
+		handleFileError(c, err)
+		return
+	}
+	if err != nil {
+		//the download has started, so all that can be done is cutting it short
+		logging.Error("Error writing archive for server "+server.Id(), err)
+		c.Abort()
+	}
+}
+
+//Sends the download headers on the first write, so errors found before anything is written can still be returned.
+type downloadWriter struct {
+	context  *gin.Context
+	filename string
+	started  bool
+}
+
+func (d *downloadWriter) Write(data []byte) (int, error) {
+	if !d.started {
+		d.started = true
+		contentType := "application/zip"
+		if strings.HasSuffix(d.filename, ".tar.gz") {
+			contentType = "application/gzip"
+		}
+		d.context.Header("Content-Type", contentType)
+		d.context.Header("Content-Disposition", "attachment; filename=\""+d.filename+"\"")
+		d.context.Status(200)
+	}
+	return d.context.Writer.Write(data)
+}
+
+//Searches the files of a server. The query takes the name glob and content regex to search for, the path
+//to search under, the number of context lines, and limits on file size, results and time in seconds.
+func SearchFiles(c *gin.Context) {
+	valid, server := handleInitialCallServer(c, "server.file.get", true)
+
+	if !valid {
+		return
+	}
+
+	options := files.SearchOptions{
+		Path:    c.Query("path"),
+		Name:    c.Query("name"),
+		Content: c.Query("content"),
+		Context: -1,
+	}
+	if value := c.Query("context"); value != "" {
+		options.Context, _ = strconv.Atoi(value)
+	}
+	options.MaxFileSize, _ = strconv.ParseInt(c.Query("maxFileSize"), 10, 64)
+	options.MaxResults, _ = strconv.Atoi(c.Query("maxResults"))
+	options.Timeout, _ = strconv.Atoi(c.Query("timeout"))
+
+	result, err := files.Search(server.GetEnvironment().GetRootDirectory(), options)
+	if err != nil {
+		handleFileError(c, err)
+		return
+	}
+	c.JSON(200, result)
+}
+
+func CreateUpload(c *gin.Context) {
+	valid, server := handleInitialCallServer(c, "server.file.put", true)
+
+	if !valid {
+		return
+	}
+
+	var request struct {
+		Path string `json:"path"`
+		Size int64  `json:"size"`
+	}
+	err := json.NewDecoder(c.Request.Body).Decode(&request)
+	if err != nil {
+		logging.Error("Error decoding JSON body", err)
+		c.AbortWithError(400, err)
+		return
+	}
+
+	upload, err := files.CreateUpload(server.Id(), server.GetEnvironment().GetRootDirectory(), request.Path, request.Size, server.GetQuota())
+	if err != nil {
+		handleFileError(c, err)
+		return
+	}
+	c.JSON(201, upload.Progress())
+}
+
+func GetUpload(c *gin.Context) {
+	upload, ok := findUpload(c)
+	if !ok {
+		return
+	}
+	c.JSON(200, upload.Progress())
+}
+
+//Writes the body at the byte given by offset, which cannot be past the bytes received so far.
+func PutUploadChunk(c *gin.Context) {
+	upload, ok := findUpload(c)
+	if !ok {
+		return
+	}
+
+	offset, err := strconv.ParseInt(c.DefaultQuery("offset", "0"), 10, 64)
+	if err != nil {
+		handleFileError(c, &files.Error{Path: upload.Path, Code: files.CodeInvalid, Message: "Offset must be a number"})
+		return
+	}
+	_, err = upload.Write(offset, c.Request.Body)
+	if err != nil {
+		handleFileError(c, err)
+		return
+	}
+	c.JSON(200, upload.Progress())
+}
 
-	if !utils.EnsureAccess(targetFile, server.GetEnvironment().GetRootDirectory()) {
+func CommitUpload(c *gin.Context) {
+	upload, ok := findUpload(c)
+	if !ok {
 		return
 	}
 
-	file, err := os.Create(targetFile)
+	var request struct {
+		Sha256 string `json:"sha256"`
+	}
+	if c.Request.ContentLength != 0 {
+		err := json.NewDecoder(c.Request.Body).Decode(&request)
+		if err != nil {
+			logging.Error("Error decoding JSON body", err)
+			c.AbortWithError(400, err)
+			return
+		}
+	}
+
+	err := upload.Commit(request.Sha256)
+	if err != nil {
+		handleFileError(c, err)
+		return
+	}
+	c.Status(204)
+}
 
+func AbortUpload(c *gin.Context) {
+	upload, ok := findUpload(c)
+	if !ok {
+		return
+	}
+	err := upload.Abort()
 	if err != nil {
-		logging.Error("Error writing file", err)
+		handleFileError(c, err)
+		return
+	}
+	c.Status(204)
+}
+
+func findUpload(c *gin.Context) (upload *files.Upload, ok bool) {
+	valid, server := handleInitialCallServer(c, "server.file.put", true)
+
+	if !valid {
+		return
+	}
+
+	upload = files.GetUpload(server.Id(), c.Param("upload"))
+	if upload == nil {
+		c.AbortWithStatus(404)
 		return
 	}
+	return upload, true
+}
 
-	_, err = io.Copy(file, c.Request.Body)
+func decodeFileRequest(c *gin.Context, perm string) (server programs.Program, request fileRequest, ok bool) {
+	valid, server := handleInitialCallServer(c, perm, true)
+
+	if !valid {
+		return
+	}
 
+	err := json.NewDecoder(c.Request.Body).Decode(&request)
 	if err != nil {
-		logging.Error("Error writing file", err)
+		logging.Error("Error decoding JSON body", err)
+		c.AbortWithError(400, err)
+		return
+	}
+	if len(request.Paths) == 0 {
+		handleFileError(c, &files.Error{Code: files.CodeInvalid, Message: "No paths given"})
+		return
+	}
+	return server, request, true
+}
+
+//Responds to a bulk operation with the paths which failed. The paths which are not listed succeeded.
+func respondFileErrors(c *gin.Context, errs []*files.Error) {
+	result := make(map[string]interface{})
+	result["errors"] = errs
+	c.JSON(200, result)
+}
+
+func handleFileError(c *gin.Context, err error) {
+	fileErr, ok := err.(*files.Error)
+	if !ok {
+		logging.Error("Error handling file request", err)
+		c.AbortWithError(500, err)
+		return
 	}
+	status := 500
+	switch fileErr.Code {
+	case files.CodeNotFound:
+		status = 404
+	case files.CodeExists:
+		status = 409
+	case files.CodeOutsideRoot, files.CodePermissionDenied:
+		status = 403
+	case files.CodeNotDirectory, files.CodeInvalid:
+		status = 400
+	case files.CodeQuotaExceeded:
+		status = 507
+	}
+	c.JSON(status, fileErr)
+	c.Abort()
 }
 
 func PostConsole(c *gin.Context) {
@@ -264,11 +700,11 @@ func PostConsole(c *gin.Context) {
 	}
 	d, _ := ioutil.ReadAll(c.Request.Body)
 	cmd := string(d)
-	err := program.Execute(cmd)
+	response, err := program.SendCommand(cmd)
 	if err != nil {
 		c.Error(err)
 	} else {
-		c.Status(200)
+		c.String(200, response)
 	}
 }
 
@@ -299,12 +735,18 @@ func GetStats(c *gin.Context) {
 
 	results, err := server.GetEnvironment().GetStats()
 	if err != nil {
-		result := make(map[string]interface{})
-		result["error"] = err.Error()
-		c.JSON(200, result)
-	} else {
-		c.JSON(200, results)
+		results = make(map[string]interface{})
+		results["error"] = err.Error()
+	}
+	if status := server.GetQueryStatus(); status != nil {
+		results["query"] = status
 	}
+	disk := server.GetQuota()
+	results["disk"] = map[string]interface{}{
+		"used":  disk.Cached(),
+		"limit": disk.Limit(),
+	}
+	c.JSON(200, results)
 }
 
 func ReloadServer(c *gin.Context) {
@@ -318,63 +760,528 @@ func ReloadServer(c *gin.Context) {
 	programs.Reload(existing.Id())
 }
 
-func NetworkServer(c *gin.Context) {
+func DiffServerTemplate(c *gin.Context) {
+	valid, existing := handleInitialCallServer(c, "server.edit", true)
 
-	scopes, _ := c.Get("scopes")
-	valid := false
-	for _, v := range scopes.([]string) {
-		if v == "server.network"{
-			valid = true
-		}
-	}
 	if !valid {
-		c.AbortWithStatus(401)
 		return
 	}
 
-	servers := c.DefaultQuery("ids", "")
-	if servers == "" {
-		c.AbortWithError(400, errors.New("Server ids required"))
+	diff, err := programs.DiffTemplate(existing.Id(), c.Query("template"))
+	if err != nil {
+		handleProgramError(c, err)
 		return
 	}
-	serverIds := strings.Split(servers, ",")
-	result := make(map[string]string)
-	for _, v := range serverIds {
-		program, _ := programs.Get(v)
-		if program == nil {
-			continue
-		}
-		result[program.Id()] = program.GetNetwork()
-	}
-	c.JSON(200, result)
+	c.JSON(200, diff)
 }
 
-func GetLogs (c *gin.Context) {
-	valid, program := handleInitialCallServer(c, "server.console", true)
+func UpgradeServerTemplate(c *gin.Context) {
+	valid, existing := handleInitialCallServer(c, "server.edit", true)
+
 	if !valid {
 		return
 	}
 
-	time := c.DefaultQuery("time", "0")
-
-	castedTime, ok := strconv.ParseInt(time, 10, 64)
+	if existing.IsRunning() {
+		c.AbortWithError(409, errors.New("Server must be stopped to upgrade"))
+		return
+	}
 
-	if ok != nil {
-		c.AbortWithError(400, errors.New("Time provided is not a valid UNIX time"))
+	diff, err := programs.UpgradeTemplate(existing.Id(), c.Query("template"))
+	if err != nil {
+		handleProgramError(c, err)
 		return
 	}
+	c.JSON(200, diff)
+}
 
-	console, epoch := program.GetEnvironment().GetConsoleFrom(castedTime)
-	msg := ""
-	for _, k := range console {
-		msg += k + "\n"
+func CloneServer(c *gin.Context) {
+	valid, existing := handleInitialCallServer(c, "server.create", true)
+
+	if !valid {
+		return
+	}
+
+	request := struct {
+		Id   string                 `json:"id"`
+		Data map[string]interface{} `json:"data"`
+	}{}
+	err := json.NewDecoder(c.Request.Body).Decode(&request)
+	if err != nil {
+		logging.Error("Error decoding JSON body", err)
+		c.AbortWithError(400, err)
+		return
+	}
+
+	job, err := programs.Clone(existing.Id(), request.Id, request.Data)
+	if err != nil {
+		handleProgramError(c, err)
+		return
+	}
+	c.JSON(202, job.Status())
+}
+
+func ListBackups(c *gin.Context) {
+	valid, existing := handleInitialCallServer(c, "server.backup", true)
+
+	if !valid {
+		return
+	}
+
+	backups, err := backup.List(existing.Id())
+	if err != nil {
+		handleProgramError(c, err)
+		return
+	}
+	c.JSON(200, backups)
+}
+
+func CreateBackup(c *gin.Context) {
+	valid, existing := handleInitialCallServer(c, "server.backup", true)
+
+	if !valid {
+		return
+	}
+
+	job, err := backup.Create(existing)
+	if err != nil {
+		c.AbortWithError(409, err)
+		return
+	}
+	c.JSON(202, job.Status())
+}
+
+func DownloadBackup(c *gin.Context) {
+	valid, existing := handleInitialCallServer(c, "server.backup", true)
+
+	if !valid {
+		return
+	}
+
+	id := c.Param("backup")
+	info, err := backup.Get(existing.Id(), id)
+	if os.IsNotExist(err) {
+		c.AbortWithStatus(404)
+		return
+	}
+	reader, err := backup.Open(existing.Id(), id)
+	if err != nil {
+		handleProgramError(c, err)
+		return
+	}
+	defer reader.Close()
+
+	c.Header("Content-Disposition", "attachment; filename=\""+existing.Id()+"-"+id+".tar.gz\"")
+	c.Header("Content-Type", "application/gzip")
+	if info.Format != programs.BackupFormatSnapshot {
+		c.Header("Content-Length", strconv.FormatInt(info.Size, 10))
+	}
+	c.Status(200)
+	_, err = io.Copy(c.Writer, reader)
+	if err != nil {
+		logging.Error("Error sending backup", err)
+	}
+}
+
+func RestoreBackup(c *gin.Context) {
+	valid, existing := handleInitialCallServer(c, "server.backup", true)
+
+	if !valid {
+		return
+	}
+
+	if existing.IsRunning() {
+		c.AbortWithError(409, errors.New("Server must be stopped to restore a backup"))
+		return
+	}
+
+	job, err := backup.Restore(existing, c.Param("backup"), c.DefaultQuery("definition", "true") != "false")
+	if os.IsNotExist(err) {
+		c.AbortWithStatus(404)
+		return
+	}
+	if err != nil {
+		c.AbortWithError(409, err)
+		return
+	}
+	c.JSON(202, job.Status())
+}
+
+func VerifyBackup(c *gin.Context) {
+	valid, existing := handleInitialCallServer(c, "server.backup", true)
+
+	if !valid {
+		return
+	}
+
+	job, err := backup.Verify(existing.Id(), c.Param("backup"))
+	if os.IsNotExist(err) {
+		c.AbortWithStatus(404)
+		return
+	}
+	if err != nil {
+		handleProgramError(c, err)
+		return
+	}
+	c.JSON(202, job.Status())
+}
+
+func DeleteBackup(c *gin.Context) {
+	valid, existing := handleInitialCallServer(c, "server.backup", true)
+
+	if !valid {
+		return
+	}
+
+	err := backup.Delete(existing.Id(), c.Param("backup"))
+	if os.IsNotExist(err) {
+		c.AbortWithStatus(404)
+		return
+	}
+	if err != nil {
+		handleProgramError(c, err)
+		return
+	}
+	c.Status(204)
+}
+
+func GetBackupSettings(c *gin.Context) {
+	valid, existing := handleInitialCallServer(c, "server.backup", true)
+
+	if !valid {
+		return
+	}
+
+	c.JSON(200, existing.GetBackupSettings())
+}
+
+func PutBackupSettings(c *gin.Context) {
+	valid, existing := handleInitialCallServer(c, "server.backup", true)
+
+	if !valid {
+		return
+	}
+
+	var settings programs.BackupSettings
+	err := json.NewDecoder(c.Request.Body).Decode(&settings)
+	if err != nil {
+		logging.Error("Error decoding JSON body", err)
+		c.AbortWithError(400, err)
+		return
+	}
+
+	err = existing.SetBackupSettings(settings)
+	if err != nil {
+		handleProgramError(c, err)
+		return
+	}
+	c.JSON(200, existing.GetBackupSettings())
+}
+
+func ListSftpKeys(c *gin.Context) {
+	valid, existing := handleInitialCallServer(c, "server.sftp", true)
+
+	if !valid {
+		return
+	}
+
+	keys, err := sftp.GetKeys(existing.Id())
+	if err != nil {
+		handleProgramError(c, err)
+		return
+	}
+	c.JSON(200, keys)
+}
+
+func AddSftpKey(c *gin.Context) {
+	valid, existing := handleInitialCallServer(c, "server.sftp", true)
+
+	if !valid {
+		return
+	}
+
+	var request struct {
+		User string `json:"user"`
+		Key  string `json:"key"`
+	}
+	err := json.NewDecoder(c.Request.Body).Decode(&request)
+	if err != nil {
+		logging.Error("Error decoding JSON body", err)
+		c.AbortWithError(400, err)
+		return
+	}
+
+	key, err := sftp.AddKey(existing.Id(), request.User, request.Key)
+	if err == sftp.ErrKeyExists {
+		c.AbortWithError(409, err)
+		return
+	}
+	if err != nil {
+		handleProgramError(c, err)
+		return
+	}
+	logging.Infof("Added SFTP key %s for %s on server %s", key.Fingerprint, key.User, existing.Id())
+	c.JSON(200, key)
+}
+
+func DeleteSftpKey(c *gin.Context) {
+	valid, existing := handleInitialCallServer(c, "server.sftp", true)
+
+	if !valid {
+		return
+	}
+
+	err := sftp.DeleteKey(existing.Id(), c.Param("key"))
+	if err == sftp.ErrKeyNotFound {
+		c.AbortWithStatus(404)
+		return
+	}
+	if err != nil {
+		handleProgramError(c, err)
+		return
+	}
+	c.Status(204)
+}
+
+func ListSchedules(c *gin.Context) {
+	valid, existing := handleInitialCallServer(c, "server.schedules", true)
+
+	if !valid {
+		return
+	}
+
+	c.JSON(200, scheduler.Describe(existing))
+}
+
+func GetSchedule(c *gin.Context) {
+	valid, existing := handleInitialCallServer(c, "server.schedules", true)
+
+	if !valid {
+		return
+	}
+
+	info, exists := scheduler.Describe(existing)[c.Param("name")]
+	if !exists {
+		c.AbortWithStatus(404)
+		return
+	}
+	c.JSON(200, info)
+}
+
+func PutSchedule(c *gin.Context) {
+	valid, existing := handleInitialCallServer(c, "server.schedules", true)
+
+	if !valid {
+		return
+	}
+
+	var schedule programs.Schedule
+	err := json.NewDecoder(c.Request.Body).Decode(&schedule)
+	if err != nil {
+		logging.Error("Error decoding JSON body", err)
+		c.AbortWithError(400, err)
+		return
+	}
+
+	name := c.Param("name")
+	err = existing.SetSchedule(name, schedule)
+	if err != nil {
+		handleProgramError(c, err)
+		return
+	}
+	c.JSON(200, scheduler.Describe(existing)[name])
+}
+
+func DeleteSchedule(c *gin.Context) {
+	valid, existing := handleInitialCallServer(c, "server.schedules", true)
+
+	if !valid {
+		return
+	}
+
+	name := c.Param("name")
+	if _, exists := existing.GetSchedules()[name]; !exists {
+		c.AbortWithStatus(404)
+		return
+	}
+	err := existing.DeleteSchedule(name)
+	if err != nil {
+		handleProgramError(c, err)
+		return
+	}
+	scheduler.Forget(existing.Id(), name)
+	c.Status(204)
+}
+
+func RunSchedule(c *gin.Context) {
+	valid, existing := handleInitialCallServer(c, "server.schedules", true)
+
+	if !valid {
+		return
+	}
+
+	name := c.Param("name")
+	if _, exists := existing.GetSchedules()[name]; !exists {
+		c.AbortWithStatus(404)
+		return
+	}
+	err := scheduler.Run(existing, name)
+	if err != nil {
+		c.AbortWithError(409, err)
+		return
+	}
+	c.Status(202)
+}
+
+func GetTriggers(c *gin.Context) {
+	valid, existing := handleInitialCallServer(c, "server.edit", true)
+
+	if !valid {
+		return
+	}
+
+	c.JSON(200, existing.GetTriggers())
+}
+
+func PutTriggers(c *gin.Context) {
+	valid, existing := handleInitialCallServer(c, "server.edit", true)
+
+	if !valid {
+		return
+	}
+
+	triggers := make([]programs.Trigger, 0)
+	err := json.NewDecoder(c.Request.Body).Decode(&triggers)
+	if err != nil {
+		logging.Error("Error decoding JSON body", err)
+		c.AbortWithError(400, err)
+		return
+	}
+
+	err = existing.SetTriggers(triggers)
+	if err != nil {
+		handleProgramError(c, err)
+		return
+	}
+	c.JSON(200, existing.GetTriggers())
+}
+
+func GetStatus(c *gin.Context) {
+	valid, existing := handleInitialCallServer(c, "server.stats", true)
+
+	if !valid {
+		return
+	}
+
+	result := make(map[string]interface{})
+	result["state"] = existing.GetState()
+	result["running"] = existing.IsRunning()
+	if status := existing.GetQueryStatus(); status != nil {
+		result["query"] = status
+	}
+	c.JSON(200, result)
+}
+
+//Returns the recent events of a server. With follow=true they are streamed as server-sent events
+//until the client disconnects, starting with the recent ones.
+func GetEvents(c *gin.Context) {
+	valid, existing := handleInitialCallServer(c, "server.console", true)
+
+	if !valid {
+		return
+	}
+
+	if c.Query("follow") != "true" {
+		c.JSON(200, events.Recent(existing.Id()))
+		return
+	}
+
+	subscription, cancel := events.Subscribe(existing.Id())
+	defer cancel()
+	for _, event := range events.Recent(existing.Id()) {
+		c.SSEvent("event", event)
+	}
+	c.Writer.Flush()
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case event := <-subscription:
+			c.SSEvent("event", event)
+			return true
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
+
+func NetworkServer(c *gin.Context) {
+
+	scopes, _ := c.Get("scopes")
+	valid := false
+	for _, v := range scopes.([]string) {
+		if v == "server.network"{
+			valid = true
+		}
+	}
+	if !valid {
+		c.AbortWithStatus(401)
+		return
+	}
+
+	servers := c.DefaultQuery("ids", "")
+	if servers == "" {
+		c.AbortWithError(400, errors.New("Server ids required"))
+		return
+	}
+	serverIds := strings.Split(servers, ",")
+	result := make(map[string]string)
+	for _, v := range serverIds {
+		program, _ := programs.Get(v)
+		if program == nil {
+			continue
+		}
+		result[program.Id()] = program.GetNetwork()
 	}
-	result := make(map[string]interface{})
-	result["epoch"] = epoch
-	result["logs"] = msg;
 	c.JSON(200, result)
 }
 
+func GetLogs (c *gin.Context) {
+	valid, program := handleInitialCallServer(c, "server.console", true)
+	if !valid {
+		return
+	}
+
+	time := c.DefaultQuery("time", "0")
+
+	castedTime, ok := strconv.ParseInt(time, 10, 64)
+
+	if ok != nil {
+		c.AbortWithError(400, errors.New("Time provided is not a valid UNIX time"))
+		return
+	}
+
+	console, epoch := program.GetEnvironment().GetConsoleFrom(castedTime)
+	msg := ""
+	for _, k := range console {
+		msg += k + "\n"
+	}
+	result := make(map[string]interface{})
+	result["epoch"] = epoch
+	result["logs"] = msg;
+	c.JSON(200, result)
+}
+
+func handleProgramError(c *gin.Context, err error) {
+	if errs, ok := err.(utils.ValidationErrors); ok {
+		result := make(map[string]interface{})
+		result["errors"] = errs
+		c.JSON(400, result)
+		c.Abort()
+		return
+	}
+	logging.Error("Error handling server request", err)
+	c.AbortWithError(500, err)
+}
+
 func handleInitialCallServer(c *gin.Context, perm string, requireServer bool) (valid bool, program programs.Program) {
 	valid = false
 
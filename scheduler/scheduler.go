@@ -0,0 +1,260 @@
+/*
+ Copyright 2016 Padduck, LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+ 	http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+//Runs the schedules in server definitions, checking every minute for those whose cron expression matches.
+//Run results are kept in memory, so the last run of each schedule is only known since pufferd started.
+package scheduler
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/pufferpanel/pufferd/backup"
+	"github.com/pufferpanel/pufferd/jobs"
+	"github.com/pufferpanel/pufferd/logging"
+	"github.com/pufferpanel/pufferd/programs"
+	"github.com/pufferpanel/pufferd/utils"
+)
+
+const (
+	ResultCompleted = "completed"
+	ResultFailed    = "failed"
+	ResultSkipped   = "skipped"
+)
+
+type Status struct {
+	LastRun    *time.Time `json:"lastRun,omitempty"`
+	LastResult string     `json:"lastResult,omitempty"`
+	LastError  string     `json:"lastError,omitempty"`
+	NextRun    *time.Time `json:"nextRun,omitempty"`
+	Running    bool       `json:"running"`
+}
+
+//A schedule along with when it last and next runs.
+type Info struct {
+	programs.Schedule
+	Status
+}
+
+type state struct {
+	lastRun time.Time
+	result  string
+	err     string
+	running bool
+}
+
+var (
+	states     = make(map[string]*state)
+	statesLock sync.Mutex
+)
+
+func Start() {
+	go func() {
+		for {
+			now := time.Now()
+			next := now.Truncate(time.Minute).Add(time.Minute)
+			time.Sleep(next.Sub(now))
+			runDue(next)
+		}
+	}()
+}
+
+//Starts every enabled schedule whose cron expression matches the given minute.
+func runDue(minute time.Time) {
+	for _, program := range programs.GetAll() {
+		for name, schedule := range program.GetSchedules() {
+			if !schedule.Enabled {
+				continue
+			}
+			cron, err := utils.ParseCron(schedule.Cron)
+			if err != nil {
+				logging.Error("Invalid cron expression in schedule "+name+" of server "+program.Id(), err)
+				continue
+			}
+			if cron.Next(minute.Add(-time.Minute)).Equal(minute) {
+				go run(program, name, schedule, true)
+			}
+		}
+	}
+}
+
+//Runs a schedule now, whether or not it is enabled or the server is running.
+func Run(program programs.Program, name string) error {
+	schedule, exists := program.GetSchedules()[name]
+	if !exists {
+		return errors.New("No schedule with given name")
+	}
+	if Describe(program)[name].Running {
+		return errors.New("Schedule is already running")
+	}
+	go run(program, name, schedule, false)
+	return nil
+}
+
+//Returns the schedules of a server with their status.
+func Describe(program programs.Program) map[string]Info {
+	now := time.Now()
+	result := make(map[string]Info)
+
+	statesLock.Lock()
+	defer statesLock.Unlock()
+	for name, schedule := range program.GetSchedules() {
+		info := Info{Schedule: schedule}
+		if current := states[key(program.Id(), name)]; current != nil {
+			if !current.lastRun.IsZero() {
+				lastRun := current.lastRun
+				info.LastRun = &lastRun
+			}
+			info.LastResult = current.result
+			info.LastError = current.err
+			info.Running = current.running
+		}
+		if cron, err := utils.ParseCron(schedule.Cron); err == nil && schedule.Enabled {
+			if next := cron.Next(now); !next.IsZero() {
+				info.NextRun = &next
+			}
+		}
+		result[name] = info
+	}
+	return result
+}
+
+//Forgets the status of a schedule which has been deleted.
+func Forget(serverId string, name string) {
+	statesLock.Lock()
+	defer statesLock.Unlock()
+	delete(states, key(serverId, name))
+}
+
+func run(program programs.Program, name string, schedule programs.Schedule, scheduled bool) {
+	id := key(program.Id(), name)
+	if !begin(id) {
+		logging.Warnf("Schedule %s of server %s is still running, skipping this run", name, program.Id())
+		return
+	}
+
+	if scheduled && schedule.SkipOffline && !program.IsRunning() {
+		logging.Debugf("Skipping schedule %s of server %s as the server is not running", name, program.Id())
+		finish(id, ResultSkipped, nil)
+		return
+	}
+
+	logging.Debugf("Running schedule %s of server %s", name, program.Id())
+	for i, action := range schedule.Actions {
+		time.Sleep(time.Duration(action.Delay) * time.Second)
+		err := runAction(program, action)
+		if err != nil {
+			logging.Errorf("Error running action %d of schedule %s on server %s: %s", i+1, name, program.Id(), err.Error())
+			finish(id, ResultFailed, err)
+			return
+		}
+	}
+	finish(id, ResultCompleted, nil)
+}
+
+func runAction(program programs.Program, action programs.ScheduleAction) error {
+	switch action.Type {
+	case programs.ActionStart:
+		if program.IsRunning() {
+			return nil
+		}
+		return program.Start()
+	case programs.ActionStop:
+		return stop(program)
+	case programs.ActionRestart:
+		err := stop(program)
+		if err != nil {
+			return err
+		}
+		return program.Start()
+	case programs.ActionCommand:
+		if !program.IsRunning() {
+			return errors.New("Server is not running")
+		}
+		return program.Execute(action.Command)
+	case programs.ActionInstall:
+		return program.Install()
+	case programs.ActionBackup:
+		job, err := backup.Create(program)
+		if err != nil {
+			return err
+		}
+		return waitFor(job)
+	}
+	return errors.New("Unknown action " + action.Type)
+}
+
+func stop(program programs.Program) error {
+	if !program.IsRunning() {
+		return nil
+	}
+	err := program.Stop()
+	if err != nil {
+		return err
+	}
+	return program.GetEnvironment().WaitForMainProcess()
+}
+
+func waitFor(job *jobs.Job) error {
+	for {
+		updated := job.Updated()
+		status := job.Status()
+		switch status.Status {
+		case jobs.StatusCompleted:
+			return nil
+		case jobs.StatusFailed:
+			return errors.New(status.Error)
+		}
+		<-updated
+	}
+}
+
+func begin(id string) bool {
+	statesLock.Lock()
+	defer statesLock.Unlock()
+	current := states[id]
+	if current == nil {
+		current = &state{}
+		states[id] = current
+	}
+	if current.running {
+		return false
+	}
+	current.running = true
+	current.lastRun = time.Now()
+	return true
+}
+
+func finish(id string, result string, err error) {
+	statesLock.Lock()
+	defer statesLock.Unlock()
+	current := states[id]
+	if current == nil {
+		//the schedule was deleted while it ran
+		return
+	}
+	current.running = false
+	current.result = result
+	current.err = ""
+	if err != nil {
+		current.err = err.Error()
+	}
+}
+
+func key(serverId string, name string) string {
+	return serverId + "/" + name
+}
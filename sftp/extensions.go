@@ -0,0 +1,211 @@
+/*
+ Copyright 2016 Padduck, LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+ 	http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+
+package sftp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+//Packet types and status codes of SFTP version 3.
+const (
+	packetVersion       = 2
+	packetStatus        = 101
+	packetExtended      = 200
+	packetExtendedReply = 201
+
+	statusNoSuchFile       = 2
+	statusPermissionDenied = 3
+	statusFailure          = 4
+
+	statvfsExtension = "statvfs@openssh.com"
+
+	//well above the 256KiB OpenSSH allows
+	maxPacketSize = 1024 * 1024
+)
+
+//Serves the SFTP extensions sftpd does not know, passing every other packet through to it.
+//Packets written by sftpd are forwarded whole, so replies sent from here never land inside one of them.
+type extensionChannel struct {
+	ssh.Channel
+	fs *VirtualFS
+
+	//the rest of the packet sftpd is reading
+	incoming []byte
+	//a packet sftpd has only partly written
+	outgoing []byte
+	lock     sync.Mutex
+}
+
+//Wraps a channel sftpd serves, adding the statvfs@openssh.com extension for the files of fs.
+func NewExtensionChannel(channel ssh.Channel, fs *VirtualFS) ssh.Channel {
+	return &extensionChannel{Channel: channel, fs: fs}
+}
+
+func (c *extensionChannel) Read(data []byte) (int, error) {
+	for len(c.incoming) == 0 {
+		packet, err := readPacket(c.Channel)
+		if err != nil {
+			return 0, err
+		}
+		handled, err := c.handle(packet)
+		if err != nil {
+			return 0, err
+		}
+		if !handled {
+			c.incoming = packet
+		}
+	}
+	n := copy(data, c.incoming)
+	c.incoming = c.incoming[n:]
+	return n, nil
+}
+
+func (c *extensionChannel) Write(data []byte) (int, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.outgoing = append(c.outgoing, data...)
+
+	written := 0
+	for len(c.outgoing)-written >= 4 {
+		length := 4 + int(binary.BigEndian.Uint32(c.outgoing[written:]))
+		if len(c.outgoing)-written < length {
+			break
+		}
+		packet := c.outgoing[written : written+length]
+		if length > 4 && packet[4] == packetVersion {
+			packet = advertiseExtensions(packet)
+		}
+		if _, err := c.Channel.Write(packet); err != nil {
+			return 0, err
+		}
+		written += length
+	}
+	c.outgoing = c.outgoing[:copy(c.outgoing, c.outgoing[written:])]
+	return len(data), nil
+}
+
+//Answers a packet if it is an extension served here, reporting whether it was.
+func (c *extensionChannel) handle(packet []byte) (bool, error) {
+	if packet[4] != packetExtended {
+		return false, nil
+	}
+	id, rest, ok := readUint32(packet[5:])
+	var name, path string
+	if ok {
+		name, rest, ok = readString(rest)
+	}
+	if !ok || name != statvfsExtension {
+		return false, nil
+	}
+
+	var reply []byte
+	path, _, ok = readString(rest)
+	if !ok {
+		reply = statusPacket(id, errors.New("Invalid statvfs request"))
+	} else if stat, err := c.fs.StatVFS(path); err != nil {
+		reply = statusPacket(id, err)
+	} else {
+		reply = buildPacket(packetExtendedReply, id, *stat)
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	_, err := c.Channel.Write(reply)
+	return true, err
+}
+
+//Adds the extensions served here to the version sftpd sends when the session starts.
+func advertiseExtensions(packet []byte) []byte {
+	buffer := bytes.NewBuffer(append([]byte{}, packet...))
+	writeString(buffer, statvfsExtension)
+	writeString(buffer, "2")
+	extended := buffer.Bytes()
+	binary.BigEndian.PutUint32(extended, uint32(len(extended)-4))
+	return extended
+}
+
+func readPacket(reader io.Reader) ([]byte, error) {
+	header := make([]byte, 4)
+	_, err := io.ReadFull(reader, header)
+	if err != nil {
+		return nil, err
+	}
+	length := binary.BigEndian.Uint32(header)
+	if length == 0 || length > maxPacketSize {
+		return nil, errors.New("Invalid SFTP packet length")
+	}
+	packet := make([]byte, 4+length)
+	copy(packet, header)
+	_, err = io.ReadFull(reader, packet[4:])
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return packet, err
+}
+
+//Builds a packet from uint32s, strings and structs of fixed size fields.
+func buildPacket(kind byte, fields ...interface{}) []byte {
+	buffer := bytes.NewBuffer([]byte{0, 0, 0, 0, kind})
+	for _, field := range fields {
+		if value, ok := field.(string); ok {
+			writeString(buffer, value)
+		} else {
+			binary.Write(buffer, binary.BigEndian, field)
+		}
+	}
+	packet := buffer.Bytes()
+	binary.BigEndian.PutUint32(packet, uint32(len(packet)-4))
+	return packet
+}
+
+func statusPacket(id uint32, err error) []byte {
+	code := uint32(statusFailure)
+	if os.IsNotExist(err) {
+		code = statusNoSuchFile
+	} else if os.IsPermission(err) {
+		code = statusPermissionDenied
+	}
+	return buildPacket(packetStatus, id, code, err.Error(), "")
+}
+
+func writeString(buffer *bytes.Buffer, value string) {
+	binary.Write(buffer, binary.BigEndian, uint32(len(value)))
+	buffer.WriteString(value)
+}
+
+func readUint32(data []byte) (uint32, []byte, bool) {
+	if len(data) < 4 {
+		return 0, data, false
+	}
+	return binary.BigEndian.Uint32(data), data[4:], true
+}
+
+func readString(data []byte) (string, []byte, bool) {
+	length, rest, ok := readUint32(data)
+	if !ok || uint32(len(rest)) < length {
+		return "", data, false
+	}
+	return string(rest[:length]), rest[length:], true
+}
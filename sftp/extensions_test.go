@@ -0,0 +1,145 @@
+/*
+ Copyright 2016 Padduck, LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+ 	http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+
+package sftp_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"testing"
+
+	"github.com/pufferpanel/pufferd/confined"
+	"github.com/pufferpanel/pufferd/quota"
+	"github.com/pufferpanel/pufferd/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+//A channel which reads what the client sent from in and collects what is written to it.
+type fakeChannel struct {
+	ssh.Channel
+	in  io.Reader
+	out bytes.Buffer
+}
+
+func (c *fakeChannel) Read(data []byte) (int, error) {
+	return c.in.Read(data)
+}
+
+func (c *fakeChannel) Write(data []byte) (int, error) {
+	return c.out.Write(data)
+}
+
+//Builds a packet from bytes, uint32s and strings.
+func packet(fields ...interface{}) []byte {
+	var body bytes.Buffer
+	for _, field := range fields {
+		if value, ok := field.(string); ok {
+			binary.Write(&body, binary.BigEndian, uint32(len(value)))
+			body.WriteString(value)
+		} else {
+			binary.Write(&body, binary.BigEndian, field)
+		}
+	}
+	return append(packet32(uint32(body.Len())), body.Bytes()...)
+}
+
+func packet32(value uint32) []byte {
+	data := make([]byte, 4)
+	binary.BigEndian.PutUint32(data, value)
+	return data
+}
+
+func TestExtensionChannel(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("Filesystem sizes are only available on Linux")
+	}
+	dir, err := ioutil.TempDir("", "pufferd-sftp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	initPacket := packet(byte(1), uint32(3))
+	otherExtension := packet(byte(200), uint32(8), "other@example.com", "/")
+	var input bytes.Buffer
+	input.Write(initPacket)
+	input.Write(packet(byte(200), uint32(7), "statvfs@openssh.com", "/"))
+	input.Write(otherExtension)
+	input.Write(packet(byte(200), uint32(9), "statvfs@openssh.com", "/missing"))
+
+	fake := &fakeChannel{in: &input}
+	channel := sftp.NewExtensionChannel(fake, &sftp.VirtualFS{Root: confined.New(dir)})
+
+	//a reply sftpd is part way through writing is held back until it is complete
+	version := packet(byte(2), uint32(3))
+	channel.Write(version[:6])
+
+	passed, err := ioutil.ReadAll(channel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(passed, append(initPacket, otherExtension...)) {
+		t.Errorf("Expected only the packets sftpd serves to be passed through but got %v", passed)
+	}
+	channel.Write(version[6:])
+
+	out := fake.out.Bytes()
+	reply := out[:4+1+4+11*8]
+	if reply[4] != 201 || binary.BigEndian.Uint32(reply[5:]) != 7 || binary.BigEndian.Uint32(reply) != uint32(len(reply)-4) {
+		t.Fatalf("Expected the statvfs reply first but got %v", out)
+	}
+	if binary.BigEndian.Uint64(reply[9:]) == 0 {
+		t.Error("Expected the block size of the filesystem in the reply")
+	}
+	status := out[len(reply) : len(reply)+4+int(binary.BigEndian.Uint32(out[len(reply):]))]
+	if status[4] != 101 || binary.BigEndian.Uint32(status[5:]) != 9 || binary.BigEndian.Uint32(status[9:]) != 2 {
+		t.Errorf("Expected a no such file status for the missing path but got %v", status)
+	}
+	advertised := packet(byte(2), uint32(3), "statvfs@openssh.com", "2")
+	if rest := out[len(reply)+len(status):]; !bytes.Equal(rest, advertised) {
+		t.Errorf("Expected the version to advertise statvfs but got %v", rest)
+	}
+}
+
+func TestVirtualFS_StatVFS(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("Filesystem sizes are only available on Linux")
+	}
+	dir, err := ioutil.TempDir("", "pufferd-sftp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(dir+"/world.dat", make([]byte, 256*1024), 0644)
+
+	limit := int64(1024 * 1024)
+	fs := sftp.VirtualFS{Root: confined.New(dir), Quota: quota.New(dir, limit, true)}
+	stat, err := fs.StatVFS("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := stat.Blocks * stat.FragmentSize; size > uint64(limit) {
+		t.Errorf("Expected the size to be capped at the quota but got %d", size)
+	}
+	if free := stat.BlocksAvailable * stat.FragmentSize; free > uint64(limit-256*1024) {
+		t.Errorf("Expected the free space to leave out what the server uses but got %d", free)
+	}
+}
@@ -0,0 +1,219 @@
+/*
+ Copyright 2016 Padduck, LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+ 	http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package sftp
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	configuration "github.com/pufferpanel/pufferd/config"
+	"github.com/pufferpanel/pufferd/utils"
+	"golang.org/x/crypto/ssh"
+)
+
+var (
+	ErrKeyNotFound = errors.New("No key with given id")
+	ErrKeyExists   = errors.New("Key has already been added for this user")
+)
+
+//A public key which lets a user log in to a server over SFTP.
+//Id is the hex SHA256 of the key, which stays the same however the key is written.
+type AuthorizedKey struct {
+	Id          string    `json:"id"`
+	User        string    `json:"user"`
+	Key         string    `json:"key"`
+	Fingerprint string    `json:"fingerprint"`
+	Comment     string    `json:"comment,omitempty"`
+	Added       time.Time `json:"added"`
+}
+
+//Guards the key files, which are kept for each server under the data folder.
+var keysLock sync.Mutex
+
+func keysFolder() string {
+	return path.Join(configuration.GetOrDefault("datafolder", "data"), "sftpkeys")
+}
+
+func keysFile(server string) string {
+	return path.Join(keysFolder(), server+".json")
+}
+
+//Returns the keys added to a server.
+func GetKeys(server string) ([]AuthorizedKey, error) {
+	keysLock.Lock()
+	defer keysLock.Unlock()
+	return readKeys(server)
+}
+
+//Adds a key, given as a line of an authorized_keys file, which the user can log in to the server with.
+func AddKey(server string, user string, key string) (AuthorizedKey, error) {
+	errs := utils.ValidationErrors{}
+	if user == "" || strings.Contains(user, "|") {
+		errs = append(errs, utils.ValidationError{Field: "user", Message: "Value is required and cannot contain |"})
+	}
+	parsed, comment, _, _, err := ssh.ParseAuthorizedKey([]byte(key))
+	if err != nil {
+		errs = append(errs, utils.ValidationError{Field: "key", Message: "Invalid public key"})
+	}
+	if len(errs) > 0 {
+		return AuthorizedKey{}, errs
+	}
+
+	entry := AuthorizedKey{
+		Id:          keyId(parsed),
+		User:        user,
+		Key:         strings.TrimSpace(string(ssh.MarshalAuthorizedKey(parsed))),
+		Fingerprint: ssh.FingerprintSHA256(parsed),
+		Comment:     comment,
+		Added:       time.Now(),
+	}
+
+	keysLock.Lock()
+	defer keysLock.Unlock()
+	keys, err := readKeys(server)
+	if err != nil {
+		return AuthorizedKey{}, err
+	}
+	for _, existing := range keys {
+		if existing.Id == entry.Id && existing.User == user {
+			return AuthorizedKey{}, ErrKeyExists
+		}
+	}
+	err = writeKeys(server, append(keys, entry))
+	return entry, err
+}
+
+//Removes a key from a server, from every user it was added for.
+func DeleteKey(server string, id string) error {
+	keysLock.Lock()
+	defer keysLock.Unlock()
+	keys, err := readKeys(server)
+	if err != nil {
+		return err
+	}
+	remaining := make([]AuthorizedKey, 0, len(keys))
+	for _, key := range keys {
+		if key.Id != id {
+			remaining = append(remaining, key)
+		}
+	}
+	if len(remaining) == len(keys) {
+		return ErrKeyNotFound
+	}
+	return writeKeys(server, remaining)
+}
+
+//Removes every key of a server, as when it is deleted.
+func DeleteKeys(server string) error {
+	keysLock.Lock()
+	defer keysLock.Unlock()
+	err := os.Remove(keysFile(server))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+//Finds the server a user can log in to with a key. A user with the key on several servers
+//picks one by logging in as user|server.
+func findKey(username string, key ssh.PublicKey) (server string, found bool) {
+	user := username
+	candidates := make([]string, 0)
+	if i := strings.LastIndex(username, "|"); i >= 0 {
+		user = username[:i]
+		candidates = append(candidates, username[i+1:])
+	} else {
+		files, _ := ioutil.ReadDir(keysFolder())
+		for _, file := range files {
+			if strings.HasSuffix(file.Name(), ".json") {
+				candidates = append(candidates, strings.TrimSuffix(file.Name(), ".json"))
+			}
+		}
+	}
+
+	id := keyId(key)
+	keysLock.Lock()
+	defer keysLock.Unlock()
+	matches := make([]string, 0, 1)
+	for _, candidate := range candidates {
+		keys, err := readKeys(candidate)
+		if err != nil {
+			continue
+		}
+		for _, authorized := range keys {
+			if authorized.Id == id && authorized.User == user {
+				matches = append(matches, candidate)
+				break
+			}
+		}
+	}
+	if len(matches) != 1 {
+		return "", false
+	}
+	return matches[0], true
+}
+
+func keyId(key ssh.PublicKey) string {
+	sum := sha256.Sum256(key.Marshal())
+	return hex.EncodeToString(sum[:])
+}
+
+//Reads the keys of a server. The caller must hold the lock.
+func readKeys(server string) ([]AuthorizedKey, error) {
+	keys := make([]AuthorizedKey, 0)
+	if !validServerId(server) {
+		return keys, nil
+	}
+	data, err := ioutil.ReadFile(keysFile(server))
+	if os.IsNotExist(err) {
+		return keys, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(data, &keys)
+	return keys, err
+}
+
+//Writes the keys of a server. The caller must hold the lock.
+func writeKeys(server string, keys []AuthorizedKey) error {
+	if !validServerId(server) {
+		return errors.New("Invalid server id")
+	}
+	err := os.MkdirAll(keysFolder(), 0700)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(keys, "", "  ")
+	if err != nil {
+		return err
+	}
+	return utils.WriteFileAtomic(keysFile(server), data, 0600)
+}
+
+//Server ids from a login name are used as file names, so they cannot lead out of the folder.
+func validServerId(server string) bool {
+	return server != "" && !strings.ContainsAny(server, "/\\") && !strings.HasPrefix(server, ".")
+}
@@ -0,0 +1,90 @@
+/*
+ Copyright 2016 Padduck, LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+ 	http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package sftp_test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	configuration "github.com/pufferpanel/pufferd/config"
+	"github.com/pufferpanel/pufferd/sftp"
+	"github.com/pufferpanel/pufferd/utils"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pufferd-sftp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	configFile := filepath.Join(dir, "config.json")
+	ioutil.WriteFile(configFile, []byte(`{"datafolder": "`+filepath.ToSlash(dir)+`"}`), 0644)
+	configuration.Load(configFile)
+
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sshKey, err := ssh.NewPublicKey(&private.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	line := string(ssh.MarshalAuthorizedKey(sshKey))
+
+	key, err := sftp.AddKey("server", "admin", line[:len(line)-1]+" admin@laptop\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key.Fingerprint != ssh.FingerprintSHA256(sshKey) || key.Comment != "admin@laptop" {
+		t.Errorf("Unexpected key %+v", key)
+	}
+	if _, err = sftp.AddKey("server", "admin", line); err != sftp.ErrKeyExists {
+		t.Errorf("Expected adding the key twice to fail but got %v", err)
+	}
+	if _, err = sftp.AddKey("server", "deploy", line); err != nil {
+		t.Error(err)
+	}
+	if _, err = sftp.AddKey("server", "", "not a key"); len(err.(utils.ValidationErrors)) != 2 {
+		t.Errorf("Expected the user and key to be invalid but got %v", err)
+	}
+
+	keys, err := sftp.GetKeys("server")
+	if err != nil || len(keys) != 2 {
+		t.Errorf("Expected 2 keys but got %v, %v", keys, err)
+	}
+	if keys, _ := sftp.GetKeys("other"); len(keys) != 0 {
+		t.Error("Expected keys to belong to their server")
+	}
+
+	if err = sftp.DeleteKey("server", key.Id); err != nil {
+		t.Error(err)
+	}
+	if err = sftp.DeleteKey("server", key.Id); err != sftp.ErrKeyNotFound {
+		t.Errorf("Expected deleting a missing key to fail but got %v", err)
+	}
+	if keys, _ := sftp.GetKeys("server"); len(keys) != 0 {
+		t.Errorf("Expected the key to be removed for every user but found %d", len(keys))
+	}
+	if err = sftp.DeleteKeys("server"); err != nil {
+		t.Error(err)
+	}
+}
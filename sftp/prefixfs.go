@@ -17,27 +17,44 @@
 package sftp
 
 import (
-	"errors"
 	"os"
+	"path"
 	"path/filepath"
+	"time"
 
+	"github.com/pufferpanel/pufferd/confined"
 	"github.com/pufferpanel/pufferd/logging"
-	"github.com/pufferpanel/pufferd/utils"
+	"github.com/pufferpanel/pufferd/quota"
 	"github.com/taruti/sftpd"
 )
 
+//Serves the files of a server, which the client sees as the root of the filesystem.
+//Writes which grow files count towards the quota of the server.
 type VirtualFS struct {
 	sftpd.EmptyFS
-	Prefix string
+	Root  *confined.FS
+	Quota *quota.Quota
 }
 
+//Open flags of SFTP version 3, which the client sends with each open.
+const (
+	openRead   = 0x01
+	openWrite  = 0x02
+	openAppend = 0x04
+	openCreate = 0x08
+	openTrunc  = 0x10
+	openExcl   = 0x20
+)
+
 type vdir struct {
 	d *os.File
 }
 
 type vfile struct {
 	sftpd.EmptyFile
-	f *os.File
+	f      *os.File
+	quota  *quota.Quota
+	append bool
 }
 
 func (rf vfile) Close() error {
@@ -52,9 +69,28 @@ func (rf vfile) ReadAt(bs []byte, pos int64) (int, error) {
 }
 
 func (rf vfile) WriteAt(bs []byte, offset int64) (int, error) {
-	i, e := rf.f.WriteAt(bs, offset)
+	//only the part of a write past the end of the file uses more space
+	var growth int64
+	if rf.append {
+		growth = int64(len(bs))
+	} else if info, e := rf.f.Stat(); e == nil && offset+int64(len(bs)) > info.Size() {
+		growth = offset + int64(len(bs)) - info.Size()
+	}
+	if e := rf.quota.Check(growth); e != nil {
+		return 0, e
+	}
+	var i int
+	var e error
+	if rf.append {
+		//a file opened to append is written at its end whatever the offset, which os.File only allows with Write
+		i, e = rf.f.Write(bs)
+	} else {
+		i, e = rf.f.WriteAt(bs, offset)
+	}
 	if e != nil {
 		logging.Error("Error", e)
+	} else {
+		rf.quota.Add(growth)
 	}
 	return i, e
 }
@@ -66,6 +102,10 @@ func (rf vfile) FStat() (*sftpd.Attr, error) {
 	return fi, e
 }
 
+func (rf vfile) FSetStat(attr *sftpd.Attr) error {
+	return setStat(openFile{rf.f}, attr, rf.quota)
+}
+
 func (d vdir) Readdir(count int) ([]sftpd.NamedAttr, error) {
 	fis, e := d.d.Readdir(count)
 	if e != nil {
@@ -82,59 +122,75 @@ func (d vdir) Close() error {
 	return d.d.Close()
 }
 
-func (fs VirtualFS) prefix(path string) (string, error) {
-	if len(path) > 0 && path[0] == '/' {
-		path = path[1:]
-	}
-	newPath := filepath.Clean(filepath.Join(fs.Prefix, path))
-	if utils.EnsureAccess(newPath, fs.Prefix) {
-		return newPath, nil
-	} else {
-		return "<invalid>", errors.New("Invalid path")
-	}
+//Cleans a client path as if the root were the root of the filesystem, so .. at the root stays there.
+func clean(name string) string {
+	return path.Clean("/" + name)
 }
 
-func (fs VirtualFS) OpenDir(path string) (sftpd.Dir, error) {
-	p, e := fs.prefix(path)
-	if e != nil {
-		return nil, e
-	}
-	f, e := os.Open(p)
+func (fs VirtualFS) OpenDir(name string) (sftpd.Dir, error) {
+	f, e := fs.Root.Open(clean(name))
 	if e != nil {
 		return nil, e
 	}
 	return vdir{f}, nil
 }
 
-func (fs VirtualFS) OpenFile(path string, mode uint32, a *sftpd.Attr) (sftpd.File, error) {
-	p, e := fs.prefix(path)
-	if e != nil {
-		return nil, e
+func (fs VirtualFS) OpenFile(name string, flags uint32, attr *sftpd.Attr) (sftpd.File, error) {
+	p := clean(name)
+	var flag int
+	switch {
+	case flags&openRead != 0 && flags&openWrite != 0:
+		flag = os.O_RDWR
+	case flags&openWrite != 0:
+		flag = os.O_WRONLY
+	default:
+		flag = os.O_RDONLY
 	}
-	f, e := os.OpenFile(p, os.O_RDWR, os.ModeType)
-	if e != nil {
-		if mode == 26 || mode == 58 {
-			logging.Debug("Creating file " + p)
-			f, e = os.Create(p)
-		}
-		if e != nil {
-			logging.Error("Error openning file", e)
+	if flags&openAppend != 0 {
+		flag |= os.O_APPEND
+	}
+	if flags&openCreate != 0 {
+		flag |= os.O_CREATE
+		//a server with no room left cannot create files
+		if e := fs.Quota.Check(1); e != nil {
 			return nil, e
 		}
+		if flags&openExcl != 0 {
+			flag |= os.O_EXCL
+		}
+	}
+	if flags&openTrunc != 0 {
+		flag |= os.O_TRUNC
+	}
+	perm := os.FileMode(0644)
+	if attr != nil && attr.Flags&sftpd.ATTR_MODE != 0 {
+		perm = attr.Mode.Perm()
 	}
-	return vfile{f: f}, nil
-}
 
-func (fs VirtualFS) Stat(name string, islstat bool) (*sftpd.Attr, error) {
-	p, e := fs.prefix(name)
+	//the space of a truncated file is freed
+	var truncated int64
+	if flag&os.O_TRUNC != 0 {
+		if info, e := fs.Root.Stat(p); e == nil && info.Mode().IsRegular() {
+			truncated = info.Size()
+		}
+	}
+	logging.Debugf("Opening file %s with flags %d", p, flags)
+	f, e := fs.Root.OpenFile(p, flag, perm)
 	if e != nil {
+		logging.Error("Error openning file", e)
 		return nil, e
 	}
+	fs.Quota.Add(-truncated)
+	return vfile{f: f, quota: fs.Quota, append: flags&openAppend != 0}, nil
+}
+
+func (fs VirtualFS) Stat(name string, islstat bool) (*sftpd.Attr, error) {
 	var fi os.FileInfo
+	var e error
 	if islstat {
-		fi, e = os.Lstat(p)
+		fi, e = fs.Root.Lstat(clean(name))
 	} else {
-		fi, e = os.Stat(p)
+		fi, e = fs.Root.Stat(clean(name))
 	}
 	if e != nil {
 		return nil, e
@@ -145,50 +201,185 @@ func (fs VirtualFS) Stat(name string, islstat bool) (*sftpd.Attr, error) {
 	return &a, e
 }
 
-func (fs VirtualFS) SetStat(path string, attr *sftpd.Attr) error {
-	return nil
+func (fs VirtualFS) SetStat(name string, attr *sftpd.Attr) error {
+	return setStat(rootPath{fs.Root, clean(name)}, attr, fs.Quota)
 }
 
 func (fs VirtualFS) Remove(name string) error {
-	p, e := fs.prefix(name)
-	if e != nil {
-		return e
-	}
-	return os.Remove(p)
+	return fs.Root.Remove(clean(name))
 }
 
 func (fs VirtualFS) Rename(oldName string, newName string, mode uint32) error {
-	var p1, p2 string
-	var e error
-	p1, e = fs.prefix(oldName)
+	e := fs.Root.Rename(clean(oldName), clean(newName))
 	if e != nil {
 		logging.Error("Error renaming file", e)
-		return e
 	}
-	p2, e = fs.prefix(newName)
-	if e != nil {
-		logging.Error("Error renaming file", e)
-		return e
+	return e
+}
+
+func (fs VirtualFS) Mkdir(name string, attr *sftpd.Attr) error {
+	perm := os.FileMode(0755)
+	if attr != nil && attr.Flags&sftpd.ATTR_MODE != 0 {
+		perm = attr.Mode.Perm()
 	}
-	e = os.Rename(p1, p2)
+	return fs.Root.Mkdir(clean(name), perm)
+}
+
+func (fs VirtualFS) Rmdir(name string) error {
+	return fs.Root.Remove(clean(name))
+}
+
+//Returns the target of a symlink. Absolute targets inside the root are given as the client sees them.
+func (fs VirtualFS) ReadLink(name string) (string, error) {
+	target, e := fs.Root.Readlink(clean(name))
 	if e != nil {
-		logging.Error("Error renaming file", e)
+		return "", e
 	}
-	return e
+	if filepath.IsAbs(target) {
+		relative, e := fs.Root.Rel(filepath.Clean(target))
+		if e != nil {
+			return "", e
+		}
+		return "/" + relative, nil
+	}
+	return filepath.ToSlash(target), nil
 }
 
-func (fs VirtualFS) Mkdir(name string, attr *sftpd.Attr) error {
-	p, e := fs.prefix(name)
+//Creates a symlink, which must point inside the root. Absolute targets are as the client sees them,
+//so they are stored relative to the link, which keeps them working wherever the server folder is.
+func (fs VirtualFS) CreateLink(name string, target string, flags uint32) error {
+	link := clean(name)
+	if path.IsAbs(target) {
+		relative, e := filepath.Rel(filepath.FromSlash(path.Dir(link)), filepath.FromSlash(clean(target)))
+		if e != nil {
+			return e
+		}
+		target = filepath.ToSlash(relative)
+	}
+	return fs.Root.Symlink(filepath.FromSlash(target), link)
+}
+
+//Returns the absolute path of a name as the client sees it, with symlinks followed.
+func (fs VirtualFS) RealPath(name string) (string, error) {
+	resolved, e := fs.Root.Resolve(clean(name))
+	if e != nil {
+		return "", e
+	}
+	relative, e := fs.Root.Rel(resolved)
 	if e != nil {
-		return e
+		return "", e
 	}
-	return os.Mkdir(p, 0755)
+	return "/" + relative, nil
 }
 
-func (fs VirtualFS) Rmdir(name string) error {
-	p, e := fs.prefix(name)
+//Attributes set by the client, applied to an open file or a path under the root.
+//Ownership is left alone, since files belong to the user servers run as.
+type attrTarget interface {
+	Stat() (os.FileInfo, error)
+	Truncate(size int64) error
+	Chmod(mode os.FileMode) error
+	Chtimes(atime time.Time, mtime time.Time) error
+}
+
+func setStat(target attrTarget, attr *sftpd.Attr, limit *quota.Quota) error {
+	if attr.Flags&sftpd.ATTR_SIZE != 0 {
+		info, e := target.Stat()
+		if e != nil {
+			return e
+		}
+		growth := int64(attr.Size) - info.Size()
+		if e = limit.Check(growth); e != nil {
+			return e
+		}
+		if e = target.Truncate(int64(attr.Size)); e != nil {
+			return e
+		}
+		limit.Add(growth)
+	}
+	if attr.Flags&sftpd.ATTR_MODE != 0 {
+		if e := target.Chmod(attr.Mode.Perm()); e != nil {
+			return e
+		}
+	}
+	if attr.Flags&sftpd.ATTR_TIME != 0 {
+		if e := target.Chtimes(attr.ATime, attr.MTime); e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+type openFile struct {
+	*os.File
+}
+
+func (f openFile) Chtimes(atime time.Time, mtime time.Time) error {
+	return os.Chtimes(f.Name(), atime, mtime)
+}
+
+type rootPath struct {
+	root *confined.FS
+	name string
+}
+
+func (p rootPath) Stat() (os.FileInfo, error) {
+	return p.root.Stat(p.name)
+}
+
+func (p rootPath) Truncate(size int64) error {
+	return p.root.Truncate(p.name, size)
+}
+
+func (p rootPath) Chmod(mode os.FileMode) error {
+	return p.root.Chmod(p.name, mode)
+}
+
+func (p rootPath) Chtimes(atime time.Time, mtime time.Time) error {
+	return p.root.Chtimes(p.name, atime, mtime)
+}
+
+//Sizes of the filesystem a server is on, in the form of the statvfs@openssh.com extension.
+type StatVFS struct {
+	BlockSize       uint64
+	FragmentSize    uint64
+	Blocks          uint64
+	BlocksFree      uint64
+	BlocksAvailable uint64
+	Files           uint64
+	FilesFree       uint64
+	FilesAvailable  uint64
+	FilesystemId    uint64
+	Flags           uint64
+	MaxNameLength   uint64
+}
+
+//Reports the space of the filesystem under a path. With a quota, the sizes are those of the quota
+//where it is smaller, so clients see how much they can upload.
+func (fs VirtualFS) StatVFS(name string) (*StatVFS, error) {
+	resolved, e := fs.Root.Resolve(clean(name))
+	if e != nil {
+		return nil, e
+	}
+	stat, e := statfs(resolved)
 	if e != nil {
-		return e
+		return nil, e
+	}
+	if fs.Quota == nil || fs.Quota.Limit() <= 0 || stat.FragmentSize == 0 {
+		return stat, nil
+	}
+	blocks := uint64(fs.Quota.Limit()) / stat.FragmentSize
+	free := uint64(0)
+	if used := uint64(fs.Quota.Used()) / stat.FragmentSize; used < blocks {
+		free = blocks - used
+	}
+	if blocks < stat.Blocks {
+		stat.Blocks = blocks
+	}
+	if free < stat.BlocksFree {
+		stat.BlocksFree = free
+	}
+	if free < stat.BlocksAvailable {
+		stat.BlocksAvailable = free
 	}
-	return os.Remove(p)
+	return stat, nil
 }
@@ -34,6 +34,7 @@ import (
 
 	"github.com/pkg/errors"
 	configuration "github.com/pufferpanel/pufferd/config"
+	"github.com/pufferpanel/pufferd/confined"
 	"github.com/pufferpanel/pufferd/logging"
 	"github.com/pufferpanel/pufferd/programs"
 	"github.com/taruti/sftpd"
@@ -52,6 +53,9 @@ func runServer() error {
 		PasswordCallback: func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
 			return validateSSH(c.User(), string(pass))
 		},
+		PublicKeyCallback: func(c ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			return validateKey(c.User(), key)
+		},
 	}
 
 	serverKeyFile := path.Join(configuration.GetOrDefault("datafolder", "data"), "server.key")
@@ -128,6 +132,13 @@ func handleConn(conn net.Conn, config *ssh.ServerConfig) error {
 	}
 	defer sc.Close()
 
+	program := programs.GetFromCache(sc.Permissions.Extensions["server_id"])
+	if program == nil {
+		return errors.New("No server with id " + sc.Permissions.Extensions["server_id"])
+	}
+	//the root can be set in the definition, so it is taken from the server rather than the server folder
+	root := confined.New(program.GetEnvironment().GetRootDirectory())
+
 	// The incoming Request channel must be serviced.
 	go PrintDiscardRequests(reqs)
 
@@ -142,7 +153,7 @@ func handleConn(conn net.Conn, config *ssh.ServerConfig) error {
 			return err
 		}
 
-		fs := &VirtualFS{Prefix: path.Join(programs.ServerFolder, sc.Permissions.Extensions["server_id"])}
+		fs := &VirtualFS{Root: root, Quota: program.GetQuota()}
 
 		go func(in <-chan *ssh.Request) {
 			for req := range in {
@@ -151,7 +162,7 @@ func handleConn(conn net.Conn, config *ssh.ServerConfig) error {
 				case sftpd.IsSftpRequest(req):
 					ok = true
 					go func() {
-						sftpd.ServeChannel(channel, fs)
+						sftpd.ServeChannel(NewExtensionChannel(channel, fs), fs)
 					}()
 				}
 				req.Reply(ok, nil)
@@ -171,12 +182,41 @@ func PrintDiscardRequests(in <-chan *ssh.Request) {
 }
 
 func validateSSH(username string, password string) (*ssh.Permissions, error) {
-	authUrl := configuration.Get("authserver")
-	client := &http.Client{}
 	data := url.Values{}
 	data.Set("grant_type", "password")
 	data.Set("username", username)
 	data.Set("password", password)
+	return requestAuth(data, "Incorrect username or password")
+}
+
+//Checks a key against the keys added to servers here, then asks the auth server whether it knows the key.
+func validateKey(username string, key ssh.PublicKey) (*ssh.Permissions, error) {
+	fingerprint := ssh.FingerprintSHA256(key)
+	if server, found := findKey(username, key); found {
+		logging.Infof("Accepted SFTP key %s of %s for server %s", fingerprint, username, server)
+		return &ssh.Permissions{Extensions: map[string]string{"server_id": server}}, nil
+	}
+
+	if configuration.Get("authserver") != "" {
+		data := url.Values{}
+		data.Set("grant_type", "public_key")
+		data.Set("username", username)
+		data.Set("key", strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key))))
+		data.Set("fingerprint", fingerprint)
+		permissions, err := requestAuth(data, "Unknown key")
+		if err == nil {
+			logging.Infof("Accepted SFTP key %s of %s for server %s from the auth server", fingerprint, username, permissions.Extensions["server_id"])
+			return permissions, nil
+		}
+	}
+	logging.Infof("Rejected SFTP key %s of %s", fingerprint, username)
+	return nil, errors.New("Unknown key")
+}
+
+//Sends a grant request for the sftp scope to the auth server, which answers with the server it grants access to.
+func requestAuth(data url.Values, denied string) (*ssh.Permissions, error) {
+	authUrl := configuration.Get("authserver")
+	client := &http.Client{}
 	data.Set("scope", "sftp")
 	token := configuration.Get("authtoken")
 	request, _ := http.NewRequest("POST", authUrl, bytes.NewBufferString(data.Encode()))
@@ -188,6 +228,7 @@ func validateSSH(username string, password string) (*ssh.Permissions, error) {
 		logging.Error("Error talking to auth server", err)
 		return nil, errors.New("Invalid response from authorization server")
 	}
+	defer response.Body.Close()
 
 	//we should only get a 200 or 400 back, if we get any others, we have a problem
 	if response.StatusCode != 200 && response.StatusCode != 400 {
@@ -197,10 +238,12 @@ func validateSSH(username string, password string) (*ssh.Permissions, error) {
 	var respArr map[string]interface{}
 	json.NewDecoder(response.Body).Decode(&respArr)
 	if respArr["error"] != nil {
-		return nil, errors.New("Incorrect username or password")
+		return nil, errors.New(denied)
 	}
 	sshPerms := &ssh.Permissions{}
-	scopes := strings.Split(respArr["scope"].(string), " ")
+	//an auth server which does not know the grant type may leave the scope out
+	scope, _ := respArr["scope"].(string)
+	scopes := strings.Split(scope, " ")
 	if len(scopes) != 2 {
 		return nil, errors.New("Invalid response from authorization server")
 	}
@@ -211,5 +254,5 @@ func validateSSH(username string, password string) (*ssh.Permissions, error) {
 			return sshPerms, nil
 		}
 	}
-	return nil, errors.New("Incorrect username or password")
+	return nil, errors.New(denied)
 }
\ No newline at end of file
@@ -0,0 +1,46 @@
+// +build linux
+
+/*
+ Copyright 2016 Padduck, LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+ 	http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package sftp
+
+import (
+	"os"
+	"syscall"
+)
+
+func statfs(name string) (*StatVFS, error) {
+	var stat syscall.Statfs_t
+	err := syscall.Statfs(name, &stat)
+	if err != nil {
+		return nil, &os.PathError{Op: "statfs", Path: name, Err: err}
+	}
+	return &StatVFS{
+		BlockSize:       uint64(stat.Bsize),
+		FragmentSize:    uint64(stat.Frsize),
+		Blocks:          uint64(stat.Blocks),
+		BlocksFree:      uint64(stat.Bfree),
+		BlocksAvailable: uint64(stat.Bavail),
+		Files:           uint64(stat.Files),
+		FilesFree:       uint64(stat.Ffree),
+		FilesAvailable:  uint64(stat.Ffree),
+		FilesystemId:    uint64(uint32(stat.Fsid.X__val[0]))<<32 | uint64(uint32(stat.Fsid.X__val[1])),
+		//the extension only defines the read-only and nosuid flags, which match those of Linux
+		Flags:           uint64(stat.Flags) & 3,
+		MaxNameLength:   uint64(stat.Namelen),
+	}, nil
+}
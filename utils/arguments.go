@@ -17,22 +17,328 @@
 package utils
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"strconv"
 	"strings"
+	"text/template"
 )
 
-func ReplaceTokens(msg string, mapping map[string]interface{}) string {
-	newmsg := msg
+var (
+	identifierRegex  = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	undefinedRegex   = regexp.MustCompile(`function "([^"]+)" not defined`)
+	missingKeyRegex  = regexp.MustCompile(`map has no entry for key "([^"]+)"`)
+	builtinFunctions = []string{"and", "or", "not", "len", "index", "slice", "print", "printf", "println",
+		"eq", "ne", "lt", "le", "gt", "ge", "html", "js", "urlquery", "call", "true", "false", "nil"}
+)
+
+var templateHelpers = template.FuncMap{
+	"default":     defaultValue,
+	"add":         func(a, b interface{}) (interface{}, error) { return arithmetic("add", a, b) },
+	"sub":         func(a, b interface{}) (interface{}, error) { return arithmetic("sub", a, b) },
+	"mul":         func(a, b interface{}) (interface{}, error) { return arithmetic("mul", a, b) },
+	"div":         func(a, b interface{}) (interface{}, error) { return arithmetic("div", a, b) },
+	"mod":         func(a, b interface{}) (interface{}, error) { return arithmetic("mod", a, b) },
+	"quote":       func(v interface{}) string { return strconv.Quote(toString(v)) },
+	"shellescape": func(v interface{}) string { return ShellEscape(toString(v)) },
+	"join":        join,
+	"args":        spliceArguments,
+}
+
+//Separates the arguments rendered by the args helper. It cannot occur in a real argument.
+const argumentSeparator = "\x00"
+
+//Renders msg as a template using ${ and } as delimiters.
+//Variables can be referenced directly (${memory}) or through the data map (${.memory}),
+//and any variable which is not in the mapping results in an error.
+func ReplaceTokens(msg string, mapping map[string]interface{}) (string, error) {
+	result, err := renderTemplate(msg, mapping)
+	if err != nil {
+		return "", err
+	}
+	if strings.Contains(result, argumentSeparator) {
+		return "", errors.New("args can only be used as a whole element of an argument list")
+	}
+	return result, nil
+}
+
+func renderTemplate(msg string, mapping map[string]interface{}) (string, error) {
+	if !strings.Contains(msg, "${") {
+		return msg, nil
+	}
+
+	data := make(map[string]interface{}, len(mapping))
+	funcs := template.FuncMap{}
+	for k, v := range templateHelpers {
+		funcs[k] = v
+	}
 	for key, value := range mapping {
-		newmsg = strings.Replace(newmsg, "${"+key+"}", fmt.Sprint(value), -1)
+		if value == nil {
+			value = ""
+		}
+		data[key] = value
+		if !identifierRegex.MatchString(key) || funcs[key] != nil || ContainsValue(builtinFunctions, key) {
+			continue
+		}
+		val := value
+		funcs[key] = func() interface{} {
+			return val
+		}
+	}
+
+	tmpl, err := template.New("").Delims("${", "}").Option("missingkey=error").Funcs(funcs).Parse(msg)
+	if err != nil {
+		return "", translateTemplateError(err)
+	}
+
+	var buffer bytes.Buffer
+	err = tmpl.Execute(&buffer, data)
+	if err != nil {
+		return "", translateTemplateError(err)
+	}
+	return buffer.String(), nil
+}
+
+//Renders each element of msg as a template.
+//An element which consists of a single ${args ...} call is replaced by the arguments it renders,
+//so ${args "+map" map} adds two arguments, or none if map is empty. Other elements are kept even if they render empty.
+func ReplaceTokensInArr(msg []string, mapping map[string]interface{}) ([]string, error) {
+	newarr := make([]string, 0, len(msg))
+	for _, element := range msg {
+		replaced, err := renderTemplate(element, mapping)
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(replaced, argumentSeparator) {
+			if strings.Contains(replaced, argumentSeparator) {
+				return nil, errors.New("args can only be used as a whole element of an argument list")
+			}
+			newarr = append(newarr, replaced)
+		} else if replaced != argumentSeparator {
+			newarr = append(newarr, strings.Split(replaced[len(argumentSeparator):], argumentSeparator)...)
+		}
+	}
+	return newarr, nil
+}
+
+//Quotes a string so a POSIX shell treats it as a single word.
+func ShellEscape(value string) string {
+	if value == "" {
+		return "''"
+	}
+	if strings.IndexFunc(value, needsShellEscape) == -1 {
+		return value
+	}
+	return "'" + strings.Replace(value, "'", `'\''`, -1) + "'"
+}
+
+//Splits a command line into its arguments, honoring single quotes, double quotes and backslash escapes.
+func SplitArguments(command string) []string {
+	args := make([]string, 0)
+	var current bytes.Buffer
+	inWord := false
+	var quote rune
+	escaped := false
+	for _, r := range command {
+		switch {
+		case escaped:
+			current.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped = true
+			inWord = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				current.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inWord = true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inWord {
+				args = append(args, current.String())
+				current.Reset()
+				inWord = false
+			}
+		default:
+			current.WriteRune(r)
+			inWord = true
+		}
+	}
+	if inWord {
+		args = append(args, current.String())
+	}
+	return args
+}
+
+func needsShellEscape(r rune) bool {
+	switch {
+	case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		return false
+	}
+	return !strings.ContainsRune("-_./:=@%+,", r)
+}
+
+func translateTemplateError(err error) error {
+	if match := undefinedRegex.FindStringSubmatch(err.Error()); match != nil {
+		return errors.New("Unknown variable \"" + match[1] + "\"")
+	}
+	if match := missingKeyRegex.FindStringSubmatch(err.Error()); match != nil {
+		return errors.New("Unknown variable \"" + match[1] + "\"")
+	}
+	return err
+}
+
+func defaultValue(def interface{}, value ...interface{}) interface{} {
+	if len(value) == 0 || isEmpty(value[0]) {
+		return def
+	}
+	return value[0]
+}
+
+func isEmpty(value interface{}) bool {
+	if value == nil {
+		return true
+	}
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Ptr, reflect.Interface:
+		return v.IsNil()
+	}
+	return false
+}
+
+func join(sep string, value interface{}) string {
+	switch value.(type) {
+	case string:
+		return value.(string)
+	case []string:
+		return strings.Join(value.([]string), sep)
+	}
+	v := reflect.ValueOf(value)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return toString(value)
+	}
+	parts := make([]string, v.Len())
+	for i := 0; i < v.Len(); i++ {
+		parts[i] = toString(v.Index(i).Interface())
+	}
+	return strings.Join(parts, sep)
+}
+
+//Renders the values as separate arguments, or no arguments at all if any of them is empty.
+func spliceArguments(values ...interface{}) string {
+	parts := make([]string, len(values))
+	for i, value := range values {
+		parts[i] = toString(value)
+		if parts[i] == "" {
+			return argumentSeparator
+		}
+	}
+	return argumentSeparator + strings.Join(parts, argumentSeparator)
+}
+
+func toString(value interface{}) string {
+	if value == nil {
+		return ""
+	}
+	return fmt.Sprint(value)
+}
+
+func arithmetic(op string, a, b interface{}) (interface{}, error) {
+	ai, aIsInt, err := toNumber(a)
+	if err != nil {
+		return nil, err
+	}
+	bi, bIsInt, err := toNumber(b)
+	if err != nil {
+		return nil, err
+	}
+
+	if aIsInt && bIsInt {
+		x, y := int64(ai), int64(bi)
+		switch op {
+		case "add":
+			return x + y, nil
+		case "sub":
+			return x - y, nil
+		case "mul":
+			return x * y, nil
+		case "div":
+			if y == 0 {
+				return nil, errors.New("Division by zero")
+			}
+			return x / y, nil
+		case "mod":
+			if y == 0 {
+				return nil, errors.New("Division by zero")
+			}
+			return x % y, nil
+		}
+	}
+
+	switch op {
+	case "add":
+		return ai + bi, nil
+	case "sub":
+		return ai - bi, nil
+	case "mul":
+		return ai * bi, nil
+	case "div":
+		if bi == 0 {
+			return nil, errors.New("Division by zero")
+		}
+		return ai / bi, nil
+	case "mod":
+		if bi == 0 {
+			return nil, errors.New("Division by zero")
+		}
+		return math.Mod(ai, bi), nil
 	}
-	return newmsg
+	return nil, errors.New("Unknown operation " + op)
 }
 
-func ReplaceTokensInArr(msg []string, mapping map[string]interface{}) []string {
-	newarr := make([]string, len(msg))
-	for index, element := range msg {
-		newarr[index] = ReplaceTokens(element, mapping)
+func toNumber(value interface{}) (number float64, isInt bool, err error) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), true, nil
+	case int32:
+		return float64(v), true, nil
+	case int64:
+		return float64(v), true, nil
+	case uint16:
+		return float64(v), true, nil
+	case float32:
+		return float64(v), float64(v) == math.Trunc(float64(v)), nil
+	case float64:
+		return v, v == math.Trunc(v), nil
+	case string:
+		str := strings.TrimSpace(v)
+		if i, parseErr := strconv.ParseInt(str, 10, 64); parseErr == nil {
+			return float64(i), true, nil
+		}
+		number, err = strconv.ParseFloat(str, 64)
+		if err != nil {
+			err = errors.New("\"" + v + "\" is not a number")
+		}
+		return
 	}
-	return newarr
+	err = fmt.Errorf("%v is not a number", value)
+	return
 }
@@ -0,0 +1,104 @@
+/*
+ Copyright 2016 Padduck, LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+ 	http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package utils_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/pufferpanel/pufferd/utils"
+)
+
+func TestReplaceTokens(t *testing.T) {
+	mapping := map[string]interface{}{
+		"memory": "1024",
+		"map":    "",
+		"name":   "it's mine",
+		"mods":   []interface{}{"a", "b"},
+	}
+
+	cases := map[string]string{
+		"-Xmx${memory}M":                  "-Xmx1024M",
+		"-Xms${div memory 2}M":            "-Xms512M",
+		"-Xmx${.memory}M":                 "-Xmx1024M",
+		"${default \"ctf_2fort\" map}":    "ctf_2fort",
+		"${if map}+map ${map}${end}":      "",
+		"${shellescape name}":             `'it'\''s mine'`,
+		"${quote name}":                   `"it's mine"`,
+		"${join \",\" mods}":              "a,b",
+		"${add memory 1} ${mul memory 2}": "1025 2048",
+		"no tokens here {}":               "no tokens here {}",
+	}
+
+	for input, expected := range cases {
+		result, err := utils.ReplaceTokens(input, mapping)
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", input, err)
+			continue
+		}
+		if result != expected {
+			t.Errorf("%s: expected %q, got %q", input, expected, result)
+		}
+	}
+}
+
+func TestReplaceTokens_UnknownVariable(t *testing.T) {
+	for _, input := range []string{"${missing}", "${.missing}"} {
+		_, err := utils.ReplaceTokens(input, map[string]interface{}{"memory": "1024"})
+		if err == nil || err.Error() != "Unknown variable \"missing\"" {
+			t.Errorf("%s: expected unknown variable error, got %v", input, err)
+		}
+	}
+}
+
+func TestReplaceTokensInArr_KeepsEmpty(t *testing.T) {
+	result, err := utils.ReplaceTokensInArr([]string{"-console", "${if map}+map ${map}${end}", "${map}"}, map[string]interface{}{"map": ""})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(result, []string{"-console", "", ""}) {
+		t.Errorf("unexpected arguments %v", result)
+	}
+}
+
+func TestReplaceTokensInArr_Args(t *testing.T) {
+	arguments := []string{"-console", "${args \"+map\" map}", "${args \"+maxplayers\" players}", "-norestart"}
+	result, err := utils.ReplaceTokensInArr(arguments, map[string]interface{}{"map": "", "players": 24})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"-console", "+maxplayers", "24", "-norestart"}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("expected %v, got %v", expected, result)
+	}
+
+	mapping := map[string]interface{}{"map": "de_dust"}
+	if _, err = utils.ReplaceTokensInArr([]string{"-x ${args \"+map\" map}"}, mapping); err == nil {
+		t.Error("expected an error for args within an argument")
+	}
+	if _, err = utils.ReplaceTokens("${args \"+map\" map}", mapping); err == nil {
+		t.Error("expected an error for args outside an argument list")
+	}
+}
+
+func TestSplitArguments(t *testing.T) {
+	result := utils.SplitArguments(`java -jar 'my server.jar' "--name=a b" c\ d`)
+	expected := []string{"java", "-jar", "my server.jar", "--name=a b", "c d"}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("expected %v, got %v", expected, result)
+	}
+}
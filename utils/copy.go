@@ -0,0 +1,191 @@
+/*
+ Copyright 2016 Padduck, LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+ 	http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package utils
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/pufferpanel/pufferd/confined"
+)
+
+//Identifies a file on disk, so hardlinked files can be recognised.
+type fileKey struct {
+	device uint64
+	inode  uint64
+}
+
+//Called with the number of bytes copied so far.
+type CopyProgress func(copied int64)
+
+//Returns the total size of the regular files under a directory.
+//A directory which does not exist has a size of 0.
+func DirectorySize(root string) (size int64, err error) {
+	err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if path == root && os.IsNotExist(err) {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return
+}
+
+//Copies a directory tree, keeping modes, modification times, symlinks and hardlinks between files in the tree.
+//Files are cloned with a reflink where the filesystem supports it and copied byte by byte otherwise.
+//The target must not exist yet. A source which does not exist results in an empty target.
+//Files are opened confined to the source and target, so a symlink swapped in while copying cannot lead out of them.
+func CopyDirectory(source, target string, progress CopyProgress) error {
+	if progress == nil {
+		progress = func(int64) {}
+	}
+	links := make(map[fileKey]string)
+	directories := make([]string, 0)
+	var copied int64
+
+	//copies anything but a directory, from a name under one confined root to a name under the other
+	copyEntry := func(from *confined.FS, name string, to *confined.FS, destinationName string, info os.FileInfo) error {
+		destination, err := to.Lresolve(destinationName)
+		if err != nil {
+			return err
+		}
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := from.Readlink(name)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, destination)
+		case info.Mode().IsRegular():
+			if key, ok := fileIdentity(info); ok {
+				if existing, found := links[key]; found {
+					copied += info.Size()
+					progress(copied)
+					return os.Link(existing, destination)
+				}
+				links[key] = destination
+			}
+			return copyFile(from, name, to, destinationName, info, func(n int64) {
+				copied += n
+				progress(copied)
+			})
+		}
+		//sockets, devices and pipes are not copied
+		return nil
+	}
+
+	//a single file is confined to the directories holding it
+	if info, err := os.Lstat(source); err == nil && !info.IsDir() {
+		return copyEntry(confined.New(filepath.Dir(source)), filepath.Base(source), confined.New(filepath.Dir(target)), filepath.Base(target), info)
+	}
+
+	from := confined.New(source)
+	//the target is confined once it has been created, so its root can be found
+	var to *confined.FS
+	err := filepath.Walk(source, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if path == source && os.IsNotExist(err) {
+				return os.MkdirAll(target, 0755)
+			}
+			return err
+		}
+		relative, err := filepath.Rel(source, path)
+		if err != nil {
+			return err
+		}
+		if relative == "." {
+			directories = append(directories, relative)
+			err = os.Mkdir(target, 0755)
+			to = confined.New(target)
+			return err
+		}
+		if !info.IsDir() {
+			return copyEntry(from, relative, to, relative, info)
+		}
+		directories = append(directories, relative)
+		destination, err := to.Lresolve(relative)
+		if err != nil {
+			return err
+		}
+		return os.Mkdir(destination, 0755)
+	})
+	if err != nil {
+		return err
+	}
+
+	//directory modes and times are applied last, so read-only directories can still be filled
+	//and copying their contents does not change their times
+	for i := len(directories) - 1; i >= 0; i-- {
+		info, err := from.Stat(directories[i])
+		if err != nil {
+			return err
+		}
+		err = to.Chmod(directories[i], info.Mode().Perm())
+		if err == nil {
+			err = to.Chtimes(directories[i], info.ModTime(), info.ModTime())
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func copyFile(from *confined.FS, name string, to *confined.FS, destination string, info os.FileInfo, progress func(n int64)) error {
+	in, err := from.Open(name)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := to.OpenFile(destination, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+
+	if cloneFile(out, in) == nil {
+		progress(info.Size())
+	} else {
+		_, err = io.Copy(out, &progressReader{reader: in, progress: progress})
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return to.Chtimes(destination, info.ModTime(), info.ModTime())
+}
+
+type progressReader struct {
+	reader   io.Reader
+	progress func(n int64)
+}
+
+func (p *progressReader) Read(data []byte) (n int, err error) {
+	n, err = p.reader.Read(data)
+	if n > 0 {
+		p.progress(int64(n))
+	}
+	return
+}
@@ -0,0 +1,43 @@
+/*
+ Copyright 2016 Padduck, LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+ 	http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package utils
+
+import (
+	"os"
+	"syscall"
+)
+
+//ioctl request asking the filesystem to share the extents of one file with another
+const ficlone = 0x40049409
+
+//Makes target a reflink copy of source. Fails on filesystems without copy-on-write support.
+func cloneFile(target, source *os.File) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, target.Fd(), ficlone, source.Fd())
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+//Identifies files with more than one hardlink.
+func fileIdentity(info os.FileInfo) (fileKey, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || stat.Nlink < 2 {
+		return fileKey{}, false
+	}
+	return fileKey{device: uint64(stat.Dev), inode: uint64(stat.Ino)}, true
+}
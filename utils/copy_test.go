@@ -0,0 +1,83 @@
+/*
+ Copyright 2016 Padduck, LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+ 	http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package utils_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pufferpanel/pufferd/utils"
+)
+
+func TestCopyDirectory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pufferd-copy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	source := filepath.Join(dir, "source")
+	target := filepath.Join(dir, "target")
+
+	os.MkdirAll(filepath.Join(source, "world", "region"), 0755)
+	ioutil.WriteFile(filepath.Join(source, "world", "region", "r.0.0.mca"), []byte("region data"), 0640)
+	ioutil.WriteFile(filepath.Join(source, "server.properties"), []byte("server-port=25565"), 0600)
+	os.Link(filepath.Join(source, "world", "region", "r.0.0.mca"), filepath.Join(source, "backup.mca"))
+	os.Symlink("world", filepath.Join(source, "current"))
+
+	var reported int64
+	err = utils.CopyDirectory(source, target, func(copied int64) {
+		reported = copied
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	size, _ := utils.DirectorySize(source)
+	if reported != size {
+		t.Errorf("expected %d bytes reported, got %d", size, reported)
+	}
+	data, _ := ioutil.ReadFile(filepath.Join(target, "world", "region", "r.0.0.mca"))
+	if string(data) != "region data" {
+		t.Errorf("unexpected contents %q", data)
+	}
+	info, _ := os.Stat(filepath.Join(target, "server.properties"))
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("expected mode 0600, got %v", info.Mode().Perm())
+	}
+	if link, _ := os.Readlink(filepath.Join(target, "current")); link != "world" {
+		t.Errorf("expected symlink to world, got %q", link)
+	}
+	first, _ := os.Stat(filepath.Join(target, "backup.mca"))
+	second, _ := os.Stat(filepath.Join(target, "world", "region", "r.0.0.mca"))
+	if !os.SameFile(first, second) {
+		t.Error("hardlinked files were copied separately")
+	}
+
+	if err = utils.CopyDirectory(source, target, nil); err == nil {
+		t.Error("expected an error copying over an existing directory")
+	}
+
+	single := filepath.Join(dir, "single.properties")
+	if err = utils.CopyDirectory(filepath.Join(source, "server.properties"), single, nil); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := ioutil.ReadFile(single); string(data) != "server-port=25565" {
+		t.Errorf("unexpected contents %q copying a single file", data)
+	}
+}
@@ -0,0 +1,181 @@
+/*
+ Copyright 2016 Padduck, LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+ 	http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package utils
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//A parsed cron expression, with the fields minute, hour, day of month, month and day of week.
+//Each field is a bit set of the values it matches.
+type CronSchedule struct {
+	minute     uint64
+	hour       uint64
+	dayOfMonth uint64
+	month      uint64
+	dayOfWeek  uint64
+	//if either day field is *, only the other restricts the day, otherwise a day matching either runs
+	anyDay bool
+}
+
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var cronMonths = map[string]int{"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6, "jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12}
+
+var cronDays = map[string]int{"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6}
+
+//Parses a standard five field cron expression. Fields accept *, values, ranges, lists and steps,
+//months and days of week accept three letter names, and the macros such as @daily are supported.
+func ParseCron(expression string) (*CronSchedule, error) {
+	expression = strings.TrimSpace(expression)
+	if macro, exists := cronMacros[strings.ToLower(expression)]; exists {
+		expression = macro
+	}
+	fields := strings.Fields(expression)
+	if len(fields) != 5 {
+		return nil, errors.New("Cron expression must have 5 fields")
+	}
+
+	schedule := &CronSchedule{}
+	var err error
+	if schedule.minute, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+		return nil, errors.New("Invalid minute: " + err.Error())
+	}
+	if schedule.hour, err = parseCronField(fields[1], 0, 23, nil); err != nil {
+		return nil, errors.New("Invalid hour: " + err.Error())
+	}
+	if schedule.dayOfMonth, err = parseCronField(fields[2], 1, 31, nil); err != nil {
+		return nil, errors.New("Invalid day of month: " + err.Error())
+	}
+	if schedule.month, err = parseCronField(fields[3], 1, 12, cronMonths); err != nil {
+		return nil, errors.New("Invalid month: " + err.Error())
+	}
+	if schedule.dayOfWeek, err = parseCronField(fields[4], 0, 7, cronDays); err != nil {
+		return nil, errors.New("Invalid day of week: " + err.Error())
+	}
+	//7 is another name for sunday
+	if schedule.dayOfWeek&(1<<7) != 0 {
+		schedule.dayOfWeek |= 1
+	}
+	schedule.anyDay = strings.HasPrefix(fields[2], "*") || strings.HasPrefix(fields[4], "*")
+	return schedule, nil
+}
+
+func parseCronField(field string, min, max int, names map[string]int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if index := strings.Index(part, "/"); index >= 0 {
+			var err error
+			step, err = strconv.Atoi(part[index+1:])
+			if err != nil || step < 1 {
+				return 0, errors.New("invalid step in " + part)
+			}
+			part = part[:index]
+		}
+
+		start, end := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			start, err = parseCronValue(bounds[0], min, max, names)
+			if err != nil {
+				return 0, err
+			}
+			end = start
+			if len(bounds) == 2 {
+				end, err = parseCronValue(bounds[1], min, max, names)
+				if err != nil {
+					return 0, err
+				}
+			} else if step > 1 {
+				end = max
+			}
+			if end < start {
+				return 0, errors.New("range " + part + " is backwards")
+			}
+		}
+
+		for value := start; value <= end; value += step {
+			bits |= 1 << uint(value)
+		}
+	}
+	return bits, nil
+}
+
+func parseCronValue(value string, min, max int, names map[string]int) (int, error) {
+	if number, exists := names[strings.ToLower(value)]; exists {
+		return number, nil
+	}
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, errors.New(value + " is not a number")
+	}
+	if number < min || number > max {
+		return 0, errors.New(value + " is out of range " + strconv.Itoa(min) + "-" + strconv.Itoa(max))
+	}
+	return number, nil
+}
+
+//Returns the first time after the given one which the schedule matches, in the same location.
+//The zero time is returned if it never matches, such as on the 30th of February.
+func (c *CronSchedule) Next(after time.Time) time.Time {
+	current := after.Truncate(time.Minute).Add(time.Minute)
+	limit := current.AddDate(5, 0, 0)
+	location := current.Location()
+
+	for current.Before(limit) {
+		if c.month&(1<<uint(current.Month())) == 0 {
+			current = time.Date(current.Year(), current.Month()+1, 1, 0, 0, 0, 0, location)
+			continue
+		}
+		if !c.matchesDay(current) {
+			current = time.Date(current.Year(), current.Month(), current.Day()+1, 0, 0, 0, 0, location)
+			continue
+		}
+		if c.hour&(1<<uint(current.Hour())) == 0 {
+			current = time.Date(current.Year(), current.Month(), current.Day(), current.Hour()+1, 0, 0, 0, location)
+			continue
+		}
+		if c.minute&(1<<uint(current.Minute())) == 0 {
+			current = current.Add(time.Minute)
+			continue
+		}
+		return current
+	}
+	return time.Time{}
+}
+
+func (c *CronSchedule) matchesDay(t time.Time) bool {
+	dayOfMonth := c.dayOfMonth&(1<<uint(t.Day())) != 0
+	dayOfWeek := c.dayOfWeek&(1<<uint(t.Weekday())) != 0
+	if c.anyDay {
+		return dayOfMonth && dayOfWeek
+	}
+	return dayOfMonth || dayOfWeek
+}
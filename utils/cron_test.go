@@ -0,0 +1,61 @@
+/*
+ Copyright 2016 Padduck, LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+ 	http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package utils_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pufferpanel/pufferd/utils"
+)
+
+func TestCronSchedule_Next(t *testing.T) {
+	start := time.Date(2017, time.March, 10, 14, 37, 20, 0, time.UTC)
+	tests := []struct {
+		expression string
+		expected   time.Time
+	}{
+		{"* * * * *", time.Date(2017, time.March, 10, 14, 38, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2017, time.March, 10, 14, 45, 0, 0, time.UTC)},
+		{"0 4 * * *", time.Date(2017, time.March, 11, 4, 0, 0, 0, time.UTC)},
+		{"30 2 * * mon-fri", time.Date(2017, time.March, 13, 2, 30, 0, 0, time.UTC)},
+		{"0 0 1,15 * *", time.Date(2017, time.March, 15, 0, 0, 0, 0, time.UTC)},
+		{"0 0 20 * 5", time.Date(2017, time.March, 17, 0, 0, 0, 0, time.UTC)},
+		{"0 12 * jun 7", time.Date(2017, time.June, 4, 12, 0, 0, 0, time.UTC)},
+		{"@yearly", time.Date(2018, time.January, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", time.Time{}},
+	}
+
+	for _, test := range tests {
+		schedule, err := utils.ParseCron(test.expression)
+		if err != nil {
+			t.Errorf("ParseCron(%q) failed: %s", test.expression, err)
+			continue
+		}
+		if result := schedule.Next(start); !result.Equal(test.expected) {
+			t.Errorf("Next for %q = %s, expected %s", test.expression, result, test.expected)
+		}
+	}
+}
+
+func TestParseCron_Invalid(t *testing.T) {
+	for _, expression := range []string{"", "* * * *", "60 * * * *", "* * 0 * *", "5-1 * * * *", "*/0 * * * *", "* * * foo *"} {
+		if _, err := utils.ParseCron(expression); err == nil {
+			t.Errorf("expected ParseCron(%q) to fail", expression)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+/*
+ Copyright 2016 Padduck, LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+ 	http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package utils
+
+import (
+	"reflect"
+	"sort"
+	"strconv"
+)
+
+const (
+	DifferenceAdded   = "added"
+	DifferenceRemoved = "removed"
+	DifferenceChanged = "changed"
+)
+
+//A single difference between two decoded JSON documents.
+type Difference struct {
+	Path string      `json:"path"`
+	Type string      `json:"type"`
+	Old  interface{} `json:"old,omitempty"`
+	New  interface{} `json:"new,omitempty"`
+}
+
+//Compares two decoded JSON documents, returning the differences found under path.
+//Objects are compared key by key in sorted order and arrays element by element.
+func DiffJson(path string, old, new interface{}) []Difference {
+	result := make([]Difference, 0)
+
+	oldMap, oldIsMap := old.(map[string]interface{})
+	newMap, newIsMap := new.(map[string]interface{})
+	if oldIsMap && newIsMap {
+		keys := make([]string, 0, len(oldMap)+len(newMap))
+		for k := range oldMap {
+			keys = append(keys, k)
+		}
+		for k := range newMap {
+			if _, exists := oldMap[k]; !exists {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			oldValue, inOld := oldMap[key]
+			newValue, inNew := newMap[key]
+			childPath := JoinJsonPath(path, key)
+			switch {
+			case !inOld:
+				result = append(result, Difference{Path: childPath, Type: DifferenceAdded, New: newValue})
+			case !inNew:
+				result = append(result, Difference{Path: childPath, Type: DifferenceRemoved, Old: oldValue})
+			default:
+				result = append(result, DiffJson(childPath, oldValue, newValue)...)
+			}
+		}
+		return result
+	}
+
+	oldArray, oldIsArray := old.([]interface{})
+	newArray, newIsArray := new.([]interface{})
+	if oldIsArray && newIsArray {
+		for i := 0; i < len(oldArray) || i < len(newArray); i++ {
+			childPath := path + "[" + strconv.Itoa(i) + "]"
+			switch {
+			case i >= len(oldArray):
+				result = append(result, Difference{Path: childPath, Type: DifferenceAdded, New: newArray[i]})
+			case i >= len(newArray):
+				result = append(result, Difference{Path: childPath, Type: DifferenceRemoved, Old: oldArray[i]})
+			default:
+				result = append(result, DiffJson(childPath, oldArray[i], newArray[i])...)
+			}
+		}
+		return result
+	}
+
+	if !reflect.DeepEqual(old, new) {
+		result = append(result, Difference{Path: path, Type: DifferenceChanged, Old: old, New: new})
+	}
+	return result
+}
@@ -0,0 +1,56 @@
+/*
+ Copyright 2016 Padduck, LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+ 	http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package utils_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/pufferpanel/pufferd/utils"
+)
+
+func TestDiffJson(t *testing.T) {
+	old := map[string]interface{}{
+		"run": map[string]interface{}{
+			"stop":      "stop",
+			"arguments": []interface{}{"-jar", "server.jar"},
+		},
+		"environment": map[string]interface{}{"type": "standard"},
+	}
+	new := map[string]interface{}{
+		"run": map[string]interface{}{
+			"stop":      "end",
+			"arguments": []interface{}{"-jar", "server.jar", "nogui"},
+		},
+		"install": map[string]interface{}{},
+	}
+
+	expected := []utils.Difference{
+		{Path: "environment", Type: utils.DifferenceRemoved, Old: map[string]interface{}{"type": "standard"}},
+		{Path: "install", Type: utils.DifferenceAdded, New: map[string]interface{}{}},
+		{Path: "run.arguments[2]", Type: utils.DifferenceAdded, New: "nogui"},
+		{Path: "run.stop", Type: utils.DifferenceChanged, Old: "stop", New: "end"},
+	}
+
+	result := utils.DiffJson("", old, new)
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("expected %+v, got %+v", expected, result)
+	}
+	if result := utils.DiffJson("", old, old); len(result) != 0 {
+		t.Errorf("expected no differences, got %+v", result)
+	}
+}
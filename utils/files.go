@@ -17,8 +17,9 @@
 package utils
 
 import (
+	"io/ioutil"
+	"os"
 	"path/filepath"
-	"strings"
 )
 
 func JoinPath(paths ...string) string {
@@ -26,7 +27,36 @@ func JoinPath(paths ...string) string {
 	return result
 }
 
-func EnsureAccess(source string, prefix string) bool {
-	abs, _ := filepath.Abs(source)
-	return strings.HasPrefix(abs, prefix)
+//Writes data to a temporary file next to path, syncs it and renames it over path,
+//so readers see either the old or the new contents and never a partial write.
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	file, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	tempName := file.Name()
+
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tempName, perm)
+	}
+	if err == nil {
+		err = os.Rename(tempName, path)
+	}
+	if err != nil {
+		os.Remove(tempName)
+		return err
+	}
+
+	if dir, dirErr := os.Open(filepath.Dir(path)); dirErr == nil {
+		dir.Sync()
+		dir.Close()
+	}
+	return nil
 }
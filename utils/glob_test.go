@@ -0,0 +1,46 @@
+/*
+ Copyright 2016 Padduck, LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+ 	http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package utils_test
+
+import (
+	"testing"
+
+	"github.com/pufferpanel/pufferd/utils"
+)
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		matches bool
+	}{
+		{"*.log", "latest.log", true},
+		{"*.log", "logs/latest.log", false},
+		{"**/*.log", "logs/latest.log", true},
+		{"**/*.log", "latest.log", true},
+		{"world/**", "world/region/r.0.0.mca", true},
+		{"world/**/*.mca", "world/r.0.0.mca", true},
+		{"world/**/*.mca", "world_nether/r.0.0.mca", false},
+		{"/plugins/", "plugins", true},
+	}
+
+	for _, test := range tests {
+		if result := utils.MatchGlob(test.pattern, test.name); result != test.matches {
+			t.Errorf("MatchGlob(%q, %q) = %v, expected %v", test.pattern, test.name, result, test.matches)
+		}
+	}
+}
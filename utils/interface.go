@@ -16,6 +16,8 @@
 
 package utils
 
+import "fmt"
+
 func ToStringArray(element interface{}) []string {
 	switch element.(type) {
 	case string:
@@ -23,7 +25,11 @@ func ToStringArray(element interface{}) []string {
 	case []interface{}:
 		var arr = make([]string, 0)
 		for _, element := range element.([]interface{}) {
-			arr = append(arr, element.(string))
+			if str, ok := element.(string); ok {
+				arr = append(arr, str)
+			} else {
+				arr = append(arr, fmt.Sprint(element))
+			}
 		}
 		return arr
 	default:
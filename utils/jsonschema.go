@@ -0,0 +1,221 @@
+/*
+ Copyright 2016 Padduck, LLC
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+ 	http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package utils
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//Validates a decoded JSON document against a JSON schema, reporting every problem with its path.
+//Only the draft-04 keywords pufferd uses are supported: $ref (local definitions only), type, enum,
+//properties, required, additionalProperties, items, minItems, minimum, maximum, minLength and pattern.
+func ValidateSchema(schema map[string]interface{}, value interface{}) ValidationErrors {
+	validator := &schemaValidator{root: schema}
+	validator.validate(schema, value, "")
+	return validator.errors
+}
+
+type schemaValidator struct {
+	root   map[string]interface{}
+	errors ValidationErrors
+}
+
+func (s *schemaValidator) fail(path, message string) {
+	if path == "" {
+		path = "$"
+	}
+	s.errors = append(s.errors, ValidationError{Field: path, Message: message})
+}
+
+func (s *schemaValidator) validate(schema map[string]interface{}, value interface{}, path string) {
+	if ref, ok := schema["$ref"].(string); ok {
+		resolved := s.resolve(ref)
+		if resolved == nil {
+			s.fail(path, "Schema reference "+ref+" cannot be resolved")
+			return
+		}
+		s.validate(resolved, value, path)
+		return
+	}
+
+	if types, ok := schema["type"]; ok {
+		expected := ToStringArray(types)
+		matched := false
+		for _, t := range expected {
+			if matchesSchemaType(t, value) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			s.fail(path, "Expected "+strings.Join(expected, " or ")+" but found "+DescribeJsonType(value))
+			return
+		}
+	}
+
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		options := make([]string, len(enum))
+		for i, v := range enum {
+			options[i] = fmt.Sprint(v)
+			if v == value {
+				found = true
+			}
+		}
+		if !found {
+			s.fail(path, fmt.Sprintf("Value %v must be one of %s", value, strings.Join(options, ", ")))
+		}
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		s.validateObject(schema, v, path)
+	case []interface{}:
+		if min, ok := schema["minItems"].(float64); ok && float64(len(v)) < min {
+			s.fail(path, fmt.Sprintf("Expected at least %v items", min))
+		}
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for i, item := range v {
+				s.validate(items, item, path+"["+strconv.Itoa(i)+"]")
+			}
+		}
+	case float64:
+		if min, ok := schema["minimum"].(float64); ok && v < min {
+			s.fail(path, fmt.Sprintf("Value must be at least %v", min))
+		}
+		if max, ok := schema["maximum"].(float64); ok && v > max {
+			s.fail(path, fmt.Sprintf("Value must be at most %v", max))
+		}
+	case string:
+		if min, ok := schema["minLength"].(float64); ok && float64(len(v)) < min {
+			s.fail(path, fmt.Sprintf("Expected at least %v characters", min))
+		}
+		if pattern, ok := schema["pattern"].(string); ok {
+			if regex, err := regexp.Compile(pattern); err == nil && !regex.MatchString(v) {
+				s.fail(path, "Value does not match "+pattern)
+			}
+		}
+	}
+}
+
+func (s *schemaValidator) validateObject(schema map[string]interface{}, value map[string]interface{}, path string) {
+	if required, ok := schema["required"].([]interface{}); ok {
+		for _, r := range required {
+			key := fmt.Sprint(r)
+			if _, exists := value[key]; !exists {
+				s.fail(JoinJsonPath(path, key), "Required property is missing")
+			}
+		}
+	}
+
+	properties, _ := schema["properties"].(map[string]interface{})
+	keys := make([]string, 0, len(value))
+	for k := range value {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		childPath := JoinJsonPath(path, key)
+		if property, ok := properties[key].(map[string]interface{}); ok {
+			s.validate(property, value[key], childPath)
+			continue
+		}
+		switch additional := schema["additionalProperties"].(type) {
+		case bool:
+			if !additional {
+				s.fail(childPath, "Unknown property")
+			}
+		case map[string]interface{}:
+			s.validate(additional, value[key], childPath)
+		}
+	}
+}
+
+func (s *schemaValidator) resolve(ref string) map[string]interface{} {
+	if !strings.HasPrefix(ref, "#/") {
+		return nil
+	}
+	var current interface{} = s.root
+	for _, part := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+		mapping, ok := current.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		current = mapping[part]
+	}
+	result, _ := current.(map[string]interface{})
+	return result
+}
+
+//Appends a key to a dotted JSON path.
+func JoinJsonPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func matchesSchemaType(schemaType string, value interface{}) bool {
+	switch schemaType {
+	case "object":
+		_, ok := value.(map[string]interface{})
+		return ok
+	case "array":
+		_, ok := value.([]interface{})
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	case "integer":
+		v, ok := value.(float64)
+		return ok && v == math.Trunc(v)
+	case "null":
+		return value == nil
+	}
+	return false
+}
+
+//Describes the JSON type of a decoded value.
+func DescribeJsonType(value interface{}) string {
+	switch value.(type) {
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case nil:
+		return "null"
+	}
+	return fmt.Sprintf("%T", value)
+}
@@ -21,11 +21,10 @@ func GetStringOrDefault(data map[string]interface{}, key string, def string) str
 		return def
 	}
 	var section = data[key]
-	if section == nil {
-		return def
-	} else {
-		return section.(string)
+	if value, ok := section.(string); ok {
+		return value
 	}
+	return def
 }
 
 func GetBooleanOrDefault(data map[string]interface{}, key string, def bool) bool {
@@ -33,11 +32,10 @@ func GetBooleanOrDefault(data map[string]interface{}, key string, def bool) bool
 		return def
 	}
 	var section = data[key]
-	if section == nil {
-		return def
-	} else {
-		return section.(bool)
+	if value, ok := section.(bool); ok {
+		return value
 	}
+	return def
 }
 
 func GetMapOrNull(data map[string]interface{}, key string) map[string]interface{} {
@@ -45,11 +43,8 @@ func GetMapOrNull(data map[string]interface{}, key string) map[string]interface{
 		return (map[string]interface{})(nil)
 	}
 	var section = data[key]
-	if section == nil {
-		return (map[string]interface{})(nil)
-	} else {
-		return section.(map[string]interface{})
-	}
+	value, _ := section.(map[string]interface{})
+	return value
 }
 
 func GetObjectArrayOrNull(data map[string]interface{}, key string) []interface{} {
@@ -57,11 +52,8 @@ func GetObjectArrayOrNull(data map[string]interface{}, key string) []interface{}
 		return ([]interface{})(nil)
 	}
 	var section = data[key]
-	if section == nil {
-		return ([]interface{})(nil)
-	} else {
-		return section.([]interface{})
-	}
+	value, _ := section.([]interface{})
+	return value
 }
 
 func GetStringArrayOrNull(data map[string]interface{}, key string) []string {
@@ -69,19 +61,17 @@ func GetStringArrayOrNull(data map[string]interface{}, key string) []string {
 		return ([]string)(nil)
 	}
 	var section = data[key]
-	if section == nil {
-		return ([]string)(nil)
-	} else {
-		v, k := section.([]string)
-		if k {
-			return v
-		} else {
-			var sec = section.([]interface{})
-			var newArr = make([]string, len(sec))
-			for i := 0; i < len(sec); i++ {
-				newArr[i] = sec[i].(string)
+	switch value := section.(type) {
+	case []string:
+		return value
+	case []interface{}:
+		var newArr = make([]string, 0, len(value))
+		for _, element := range value {
+			if str, ok := element.(string); ok {
+				newArr = append(newArr, str)
 			}
-			return newArr
 		}
+		return newArr
 	}
+	return ([]string)(nil)
 }